@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/handlers/middleware"
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/services"
+	"mingda_ai_helper/utils"
+	"os"
+	"time"
+)
+
+// runCommand 执行命令行子命令。out为未经脱敏的标准输出，签发的token必须原样输出，不能经过脱敏的os.Stdout
+func runCommand(cfg *config.Config, dbService *services.DBService, args []string, out io.Writer) error {
+	switch args[0] {
+	case "rotate-key":
+		// 轮换敏感字段加密密钥
+		if err := dbService.RotateSecretKey(cfg.Security.KeyFile); err != nil {
+			return err
+		}
+		fmt.Printf("密钥轮换完成，旧密钥已备份到 %s.old\n", cfg.Security.KeyFile)
+		return nil
+	case "export-dataset":
+		return exportDataset(dbService, args[1:])
+	case "issue-token":
+		return issueToken(cfg.Security.Auth, args[1:], out)
+	default:
+		return fmt.Errorf("未知命令: %s", args[0])
+	}
+}
+
+// issueToken 签发访问本地API的JWT，用于手机App等客户端
+func issueToken(auth config.AuthConfig, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("issue-token", flag.ContinueOnError)
+	subject := fs.String("subject", "", "使用者名称，记录在操作日志中")
+	scope := fs.String("scope", string(middleware.ScopeRead), "权限: read、control或admin")
+	ttl := fs.Duration("ttl", 30*24*time.Hour, "有效期")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *subject == "" {
+		return fmt.Errorf("必须指定-subject")
+	}
+	if _, err := middleware.ParseScope(*scope); err != nil {
+		return err
+	}
+	if *ttl <= 0 {
+		return fmt.Errorf("有效期必须大于0")
+	}
+
+	// 未启用认证时也可以预先签发
+	secret, err := utils.LoadOrCreateSecret(auth.JWTSecretFile)
+	if err != nil {
+		return err
+	}
+	token, err := utils.GenerateAPIToken(*subject, *scope, string(secret), *ttl)
+	if err != nil {
+		return fmt.Errorf("签发token失败: %v", err)
+	}
+	_, err = fmt.Fprintln(out, token)
+	return err
+}
+
+// loadJWTSecret 读取JWT签名密钥，未启用认证时返回nil
+func loadJWTSecret(auth config.AuthConfig) ([]byte, error) {
+	if !auth.Enabled {
+		return nil, nil
+	}
+	return utils.LoadOrCreateSecret(auth.JWTSecretFile)
+}
+
+// exportDataset 把快照和标注导出为YOLO或COCO格式的zip
+func exportDataset(dbService *services.DBService, args []string) error {
+	fs := flag.NewFlagSet("export-dataset", flag.ContinueOnError)
+	format := fs.String("format", string(services.ExportYOLO), "导出格式: yolo或coco")
+	out := fs.String("out", "", "输出文件，默认为dataset_<format>_<日期>.zip")
+	since := fs.String("since", "", "开始时间(2006-01-02或RFC3339)，默认30天前")
+	until := fs.String("until", "", "结束时间(2006-01-02或RFC3339)，默认当前时间")
+	printer := fs.String("printer", "", "机器序列号或型号")
+	class := fs.String("class", "", "缺陷类型")
+	labelled := fs.Bool("labelled", false, "只导出有用户反馈的结果")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := services.ExportFilter{
+		Until:        time.Now(),
+		Printer:      *printer,
+		DefectType:   models.DefectType(*class),
+		LabelledOnly: *labelled,
+	}
+	if filter.DefectType != "" && !filter.DefectType.Valid() {
+		return fmt.Errorf("未知的缺陷类型: %s", *class)
+	}
+	var err error
+	if *until != "" {
+		if filter.Until, err = parseTime(*until); err != nil {
+			return err
+		}
+	}
+	filter.Since = filter.Until.AddDate(0, 0, -30)
+	if *since != "" {
+		if filter.Since, err = parseTime(*since); err != nil {
+			return err
+		}
+	}
+	if !filter.Since.Before(filter.Until) {
+		return fmt.Errorf("since必须早于until")
+	}
+
+	results, err := services.ListExportResults(dbService, filter)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("dataset_%s_%s.zip", *format, filter.Until.Format("20060102"))
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	defer f.Close()
+
+	summary, err := services.ExportDataset(f, services.ExportFormat(*format), results)
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	fmt.Printf("导出完成: %s，图片%d张，标注%d个，跳过%d张\n", *out, summary.Images, summary.Annotations, summary.Skipped)
+	return nil
+}
+
+// parseTime 解析日期(2006-01-02，本地时区)或RFC3339时间
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("无效的时间: %s", v)
+	}
+	return t, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/services"
+	"mingda_ai_helper/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIssueTokenOutput 测试标准输出已经过脱敏时，issue-token仍然输出可用的JWT
+func TestIssueTokenOutput(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "jwt.key")
+	cfg := &config.Config{}
+	cfg.Security.Auth = config.AuthConfig{Enabled: true, JWTSecretFile: secretFile}
+
+	// 与main相同：先安装脱敏的标准输出，再执行子命令
+	restore, err := services.RedirectStdout(services.NewRedactor())
+	require.NoError(t, err)
+	var out bytes.Buffer
+	err = runCommand(cfg, nil, []string{"issue-token", "-subject", "phone", "-scope", "control"}, &out)
+	restore()
+	require.NoError(t, err)
+
+	token := strings.TrimSpace(out.String())
+	assert.NotContains(t, token, "REDACTED")
+	secret, err := utils.LoadOrCreateSecret(secretFile)
+	require.NoError(t, err)
+	claims, err := utils.ValidateToken(token, string(secret))
+	require.NoError(t, err)
+	assert.Equal(t, "phone", claims.Subject)
+	assert.Equal(t, "control", claims.Scope)
+
+	assert.Error(t, runCommand(cfg, nil, []string{"issue-token"}, &out))
+}
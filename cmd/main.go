@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"mingda_ai_helper/config"
 	"mingda_ai_helper/handlers"
+	"mingda_ai_helper/handlers/middleware"
 	"mingda_ai_helper/models"
 	"mingda_ai_helper/services"
+	"mingda_ai_helper/utils"
 	"os"
 	"path/filepath"
 
+	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
@@ -76,8 +80,8 @@ func testPredictionResults(dbService *services.DBService) error {
 		PredictionStatus: models.StatusCompleted,
 		PredictionModel:  "local-model-v1",
 		HasDefect:        true,
-		DefectType:       "stringing",
-		Confidence:       95.5,
+		DefectType:       models.DefectStringing,
+		Confidence:       0.955,
 	}
 
 	if err := dbService.SavePredictionResult(predictionResult); err != nil {
@@ -89,8 +93,8 @@ func testPredictionResults(dbService *services.DBService) error {
 	if err != nil {
 		return fmt.Errorf("获取预测结果失败: %v", err)
 	}
-	fmt.Printf("获取预测结果成功: TaskID=%s, HasDefect=%v, Confidence=%.1f\n",
-		result.TaskID, result.HasDefect, result.Confidence)
+	fmt.Printf("获取预测结果成功: TaskID=%s, HasDefect=%v, Confidence=%.1f%%\n",
+		result.TaskID, result.HasDefect, result.Confidence.Percent())
 
 	results, err := dbService.ListPredictionResults(5)
 	if err != nil {
@@ -118,6 +122,9 @@ func main() {
 	fmt.Printf("  - 本地服务地址: %s\n", cfg.AI.LocalURL)
 	fmt.Printf("  - 云端服务地址: %s\n", cfg.AI.CloudURL)
 	fmt.Printf("  - 超时时间: %d秒\n", cfg.AI.Timeout)
+	for _, backend := range cfg.AI.Backends {
+		fmt.Printf("  - 后端 %s: 类型=%s, 地址=%s\n", backend.Name, backend.Type, backend.URL)
+	}
 
 	fmt.Printf("\n数据库配置:\n")
 	fmt.Printf("  - 数据库路径: %s\n", cfg.Database.Path)
@@ -145,6 +152,19 @@ func main() {
 	}
 	fmt.Println("日志服务初始化成功")
 
+	// 标准输出、标准错误和gin的输出都经过脱敏，避免token进入journal。
+	// 保留原始的标准输出给issue-token，否则签发的token会被替换为[REDACTED]
+	rawStdout := os.Stdout
+	restoreStdout, err := services.RedirectStdout(logService.Redactor())
+	if err != nil {
+		log.Fatalf("重定向标准输出失败: %v", err)
+	}
+	defer restoreStdout()
+	stderr := services.NewRedactingWriter(os.Stderr, logService.Redactor())
+	log.SetOutput(stderr)
+	gin.DefaultWriter = os.Stdout
+	gin.DefaultErrorWriter = stderr
+
 	// 初始化数据库服务
 	fmt.Println("开始初始化数据库服务...")
 	dbService, err := services.NewDBService(cfg.Database.Path)
@@ -160,6 +180,25 @@ func main() {
 	defer sqlDB.Close()
 	fmt.Println("数据库服务初始化成功")
 
+	// 启用敏感字段加密
+	fmt.Println("加载密钥文件...")
+	secretCipher, err := utils.NewSecretCipher(cfg.Security.KeyFile)
+	if err != nil {
+		log.Fatalf("加载密钥文件失败: %v", err)
+	}
+	if err := dbService.EnableSecretEncryption(secretCipher); err != nil {
+		log.Fatalf("迁移敏感字段失败: %v", err)
+	}
+	fmt.Println("敏感字段加密已启用")
+
+	// 处理命令行子命令
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, dbService, os.Args[1:], rawStdout); err != nil {
+			log.Fatalf("执行命令失败: %v", err)
+		}
+		return
+	}
+
 	// 初始化Moonraker客户端
 	fmt.Println("初始化Moonraker客户端...")
 	moonrakerClient := services.NewMoonrakerClient(cfg.Moonraker, logService)
@@ -170,31 +209,128 @@ func main() {
 	defer moonrakerClient.Close()
 	fmt.Println("Moonraker客户端初始化成功")
 
-	// 初始化本地AI服务
-	fmt.Println("初始化本地AI服务...")
-	callbackURL := fmt.Sprintf("http://%s:%d/api/v1/ai/callback", cfg.Moonraker.Host, 8584)
-	aiService := services.NewLocalAIService(cfg.AI.LocalURL, callbackURL, dbService)
-	fmt.Println("本地AI服务初始化成功")
-
-	// 初始化云端AI服务
+	// 初始化云端服务（设备认证）
 	fmt.Println("初始化云端AI服务...")
 	cloudAIService := services.NewCloudAIService(cfg.AI.CloudURL, dbService)
 	fmt.Println("云端AI服务初始化成功")
 
+	// 初始化设备凭证管理器
+	fmt.Println("初始化设备凭证管理器...")
+	credentialManager := services.NewCredentialManager(cloudAIService, dbService, logService)
+	cloudAIService.SetCredentialManager(credentialManager)
+	if info, err := dbService.GetMachineInfo(); err == nil && info.DeviceSecret == "" {
+		// 旧版本只保存了token，补充注册以获取设备密钥
+		if err := credentialManager.EnsureRegistered(context.Background(), info.MachineSN, info.MachineModel); err != nil {
+			logService.Error("设备注册失败", zap.Error(err))
+		}
+	}
+	credentialManager.Start()
+	defer credentialManager.Stop()
+	fmt.Println("设备凭证管理器启动成功")
+
+	// 实时事件推送
+	eventBus := services.NewEventBus()
+
+	// 按配置创建AI后端，熔断打开时不再等待请求超时
+	fmt.Println("初始化AI后端...")
+	healthMonitor := services.NewHealthMonitor(logService)
+	healthMonitor.SetEventBus(eventBus)
+	backendRegistry := services.NewBackendRegistry(healthMonitor)
+	callbackURL := fmt.Sprintf("http://%s:%d/api/v1/ai/callback", cfg.Moonraker.Host, 8584)
+	if err := backendRegistry.Build(cfg.AI.Backends, services.BackendDeps{
+		DBService:   dbService,
+		LogService:  logService,
+		Credentials: credentialManager,
+		CallbackURL: callbackURL,
+	}); err != nil {
+		log.Fatalf("初始化AI后端失败: %v", err)
+	}
+	backendRegistry.SetDefault(cfg.AI.Monitor.Primary)
+	healthMonitor.Start()
+	defer healthMonitor.Stop()
+	fmt.Printf("AI后端初始化成功: %v\n", backendRegistry.Names())
+	for _, info := range backendRegistry.Infos() {
+		if info.Warning != "" {
+			fmt.Printf("警告: AI后端%s%s\n", info.Name, info.Warning)
+		}
+	}
+
+	// 旧版本的缺陷类型为后端返回的原始类别，按各后端的映射统一
+	migrated, err := dbService.MigrateDefectTypes(backendRegistry.DefectMappers())
+	if err != nil {
+		log.Fatalf("迁移历史缺陷类型失败: %v", err)
+	}
+	if migrated > 0 {
+		fmt.Printf("已将%d条历史结果的缺陷类型映射为统一类型\n", migrated)
+	}
+
+	// 预测结果处理：保存结果并按用户设置暂停打印
+	predictionProcessor := services.NewPredictionProcessor(backendRegistry, dbService, moonrakerClient, logService)
+
+	// 训练样本上传，复用云端服务的设备认证
+	datasetService := services.NewDatasetService(cfg.AI.Dataset, dbService, cloudAIService, moonrakerClient, logService)
+	predictionProcessor.SetDatasetService(datasetService)
+	predictionProcessor.SetEventBus(eventBus)
+
+	// 把/predict的结果推送到请求中的callback_url
+	deliveryService := services.NewResultDeliveryService(cfg.AI.Delivery, dbService, logService)
+	predictionProcessor.SetDeliveryService(deliveryService)
+	deliveryService.Start()
+	defer deliveryService.Stop()
+	datasetService.Start()
+	defer datasetService.Stop()
+
+	// 快照目录，监控拍摄的快照和上传的图片都保存在这里
+	snapshots, err := services.NewSnapshotStore("")
+	if err != nil {
+		log.Fatalf("初始化快照目录失败: %v", err)
+	}
+
+	// 预测任务队列：每个后端固定数量的worker，重启后恢复未完成的任务
+	predictionQueue := services.NewPredictionQueue(cfg.AI.Queue, backendRegistry, predictionProcessor, dbService, logService)
+	predictionQueue.SetEventBus(eventBus)
+	predictionQueue.SetDeliveryService(deliveryService)
+	predictionProcessor.SetPredictionQueue(predictionQueue)
+	if err := predictionQueue.Start(); err != nil {
+		log.Fatalf("启动预测队列失败: %v", err)
+	}
+	defer predictionQueue.Stop()
+
 	// 初始化监控服务
 	fmt.Println("初始化监控服务...")
-	monitorService := services.NewMonitorService(moonrakerClient, aiService, cloudAIService, dbService, logService)
+	comparator := services.NewModelComparator(cfg.AI.Comparison, backendRegistry, predictionProcessor, dbService, logService)
+	monitorService := services.NewMonitorService(moonrakerClient, backendRegistry, cfg.AI.Monitor, dbService, logService, predictionQueue, snapshots, comparator)
+	monitorService.SetEventBus(eventBus)
 	if err := monitorService.Start(); err != nil {
 		log.Fatalf("启动监控服务失败: %v", err)
 	}
 	fmt.Println("监控服务启动成功")
 
+	// API认证
+	jwtSecret, err := loadJWTSecret(cfg.Security.Auth)
+	if err != nil {
+		log.Fatalf("加载JWT签名密钥失败: %v", err)
+	}
+	authenticator, err := middleware.NewAuthenticator(cfg.Security.Auth, jwtSecret, logService)
+	if err != nil {
+		log.Fatalf("初始化API认证失败: %v", err)
+	}
+	if !cfg.Security.Auth.Enabled {
+		logService.Info("API认证未启用，局域网内任何设备都可以调用接口")
+	}
+
 	// 初始化路由
 	router := handlers.SetupRouter(
-		aiService,
+		backendRegistry,
+		predictionProcessor,
+		predictionQueue,
+		snapshots,
 		dbService,
 		logService,
 		moonrakerClient,
+		datasetService,
+		eventBus,
+		authenticator,
 	)
 
 	fmt.Println("HTTP路由设置完成")
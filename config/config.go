@@ -14,6 +14,7 @@ type Config struct {
 	AI        AIConfig        `mapstructure:"ai"`
 	Database  DatabaseConfig  `mapstructure:"database"`
 	Logging   LoggingConfig   `mapstructure:"logging"`
+	Security  SecurityConfig  `mapstructure:"security"`
 }
 
 // MoonrakerConfig Moonraker连接配置
@@ -25,8 +26,109 @@ type MoonrakerConfig struct {
 // AIConfig AI服务配置
 type AIConfig struct {
 	LocalURL  string `mapstructure:"local_url"`
-	CloudURL  string `mapstructure:"cloud_url"`
+	CloudURL  string `mapstructure:"cloud_url"` // 云端服务地址，同时用于设备认证
 	Timeout   int    `mapstructure:"timeout"`
+	// 健康探测地址，为空时使用默认路径
+	LocalHealthURL string `mapstructure:"local_health_url"`
+	CloudHealthURL string `mapstructure:"cloud_health_url"`
+
+	// Backends AI后端列表，为空时由local_url和cloud_url生成local、cloud两个后端
+	Backends []BackendConfig `mapstructure:"backends"`
+	// Monitor 打印监控使用的后端
+	Monitor MonitorRoutingConfig `mapstructure:"monitor"`
+	// Comparison 按比例抽样，用参考后端对同一张快照再预测一次并记录分歧
+	Comparison ComparisonConfig `mapstructure:"comparison"`
+	// Dataset 不确定的帧和用户标记的帧上传为训练样本，需要用户在设置中开启数据共享
+	Dataset DatasetConfig `mapstructure:"dataset"`
+	// Delivery 把/predict的结果推送到请求中的callback_url
+	Delivery DeliveryConfig `mapstructure:"delivery"`
+	// Queue 预测任务队列
+	Queue QueueConfig `mapstructure:"queue"`
+}
+
+// QueueConfig 预测任务队列配置
+type QueueConfig struct {
+	JobTimeout      int `mapstructure:"job_timeout"`      // 单个任务请求后端的超时(秒)
+	CallbackTimeout int `mapstructure:"callback_timeout"` // 异步后端受理后等待回调的时间(秒)
+}
+
+// BackendConfig 单个AI后端配置
+type BackendConfig struct {
+	Name      string            `mapstructure:"name"`
+	Type      string            `mapstructure:"type"` // http-json, mingda-cloud, exec, obico, heuristic, mock ...
+	URL       string            `mapstructure:"url"`
+	HealthURL string            `mapstructure:"health_url"`
+	Timeout   int               `mapstructure:"timeout"` // 请求超时(秒)，0表示使用ai.timeout
+	Auth      BackendAuthConfig `mapstructure:"auth"`
+	// CallbackSecret 异步后端回调的HMAC签名密钥，未配置时拒绝该后端的回调
+	CallbackSecret string `mapstructure:"callback_secret"`
+	Classes   []string          `mapstructure:"classes"` // 支持的缺陷类别，启动时校验能否映射到统一的缺陷类型
+	// 后端类别到统一缺陷类型的映射，如 string: stringing；未配置的类别按内置别名映射，仍无法识别的记为unknown
+	ClassAliases map[string]string `mapstructure:"class_aliases"`
+	Options   map[string]string `mapstructure:"options"` // 各类型后端的专有参数
+	// exec后端使用：推理命令及参数，参数中的{image}替换为快照路径，未包含时追加在末尾
+	Command        []string `mapstructure:"command"`
+	MaxConcurrency int      `mapstructure:"max_concurrency"` // exec后端同时运行的进程数，默认1
+	// Workers 预测队列中同时处理该后端任务的数量，默认1
+	Workers int `mapstructure:"workers"`
+	// 后端返回的置信度刻度：unit(0-1，默认)或percent(0-100)
+	ConfidenceScale string            `mapstructure:"confidence_scale"`
+	Calibration     CalibrationConfig `mapstructure:"calibration"`
+}
+
+// CalibrationConfig 置信度校准，把后端的原始置信度映射为实际准确率
+type CalibrationConfig struct {
+	Method string  `mapstructure:"method"` // platt, isotonic，为空时不校准
+	A      float64 `mapstructure:"a"`      // platt: p = 1 / (1 + exp(a*x + b))
+	B      float64 `mapstructure:"b"`
+	// isotonic: 按原始置信度升序的[原始置信度, 校准后置信度]点，点之间线性插值
+	Points [][]float64 `mapstructure:"points"`
+}
+
+// BackendAuthConfig 后端认证配置
+type BackendAuthConfig struct {
+	Type   string `mapstructure:"type"`   // none, bearer, header, device
+	Token  string `mapstructure:"token"`
+	Header string `mapstructure:"header"` // type为header时使用的请求头名称
+}
+
+// MonitorRoutingConfig 打印监控的后端选择
+type MonitorRoutingConfig struct {
+	Primary        string `mapstructure:"primary"`         // 默认使用的后端
+	Secondary      string `mapstructure:"secondary"`       // 间隔使用的后端
+	SecondaryEvery int    `mapstructure:"secondary_every"` // 每N次检测使用一次secondary
+	// Fallback 所有后端都不可用时使用的后端，默认为内置的heuristic，设为none关闭
+	Fallback string `mapstructure:"fallback"`
+}
+
+// ComparisonConfig 模型对比配置
+type ComparisonConfig struct {
+	Enabled    bool    `mapstructure:"enabled"`
+	Reference  string  `mapstructure:"reference"`   // 参考后端，通常为云端模型
+	SampleRate float64 `mapstructure:"sample_rate"` // 抽样比例(0-1]
+	// ConfidenceGap 两个后端都判定有缺陷时，置信度相差超过该值(0-1)也记为分歧
+	ConfidenceGap float64 `mapstructure:"confidence_gap"`
+}
+
+// DatasetConfig 训练样本上传配置
+type DatasetConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Endpoint string `mapstructure:"endpoint"` // 以/开头时相对于云端API地址，使用设备token认证
+	// 置信度(0-1)落在[uncertain_low, uncertain_high]之间的帧视为不确定
+	UncertainLow   float64 `mapstructure:"uncertain_low"`
+	UncertainHigh  float64 `mapstructure:"uncertain_high"`
+	DailyQuota     int     `mapstructure:"daily_quota"`     // 每天最多上传的样本数
+	UploadInterval int     `mapstructure:"upload_interval"` // 上传间隔(秒)
+}
+
+// DeliveryConfig 预测结果推送配置，失败后按指数退避重试
+type DeliveryConfig struct {
+	MaxAttempts    int `mapstructure:"max_attempts"`    // 最多发送的次数
+	InitialBackoff int `mapstructure:"initial_backoff"` // 第一次重试的等待时间(秒)，之后每次翻倍
+	MaxBackoff     int `mapstructure:"max_backoff"`     // 重试等待时间上限(秒)
+	Timeout        int `mapstructure:"timeout"`         // 请求超时(秒)
+	// Secret 签名密钥，为空时使用设备注册时云端下发的设备密钥
+	Secret string `mapstructure:"secret"`
 }
 
 // DatabaseConfig 数据库配置
@@ -34,6 +136,35 @@ type DatabaseConfig struct {
 	Path string `mapstructure:"path"`
 }
 
+// SecurityConfig 安全配置
+type SecurityConfig struct {
+	// KeyFile 本机密钥文件，用于派生敏感字段的加密密钥（权限0600）
+	KeyFile string `mapstructure:"key_file"`
+	// Auth 本地HTTP API的认证
+	Auth AuthConfig `mapstructure:"auth"`
+}
+
+// AuthConfig API认证配置，权限分为read、control、admin三级，高级权限包含低级权限
+type AuthConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// JWTSecretFile JWT签名密钥文件，首次启动自动生成，token由issue-token子命令签发
+	JWTSecretFile string         `mapstructure:"jwt_secret_file"`
+	APIKeys       []APIKeyConfig `mapstructure:"api_keys"`
+	// Exempt 不需要认证的路由，如健康检查
+	Exempt []string `mapstructure:"exempt"`
+	// LoopbackOnly 只允许本机访问、不需要token的路由，如本机AI服务的回调
+	LoopbackOnly []string `mapstructure:"loopback_only"`
+	// AllowedOrigins 允许建立WebSocket连接的其他页面来源，如 http://mainsail.local；与本服务同一主机的页面总是允许
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
+}
+
+// APIKeyConfig 静态API Key，通过X-API-Key请求头传递
+type APIKeyConfig struct {
+	Name  string `mapstructure:"name"` // 记录到操作日志中的使用者
+	Key   string `mapstructure:"key"`
+	Scope string `mapstructure:"scope"`
+}
+
 // LoggingConfig 日志配置
 type LoggingConfig struct {
 	Level      string `mapstructure:"level"`
@@ -53,6 +184,26 @@ func LoadConfig(configPath string) (*Config, error) {
 	viper.SetConfigName("config")         // 配置文件名（不带扩展名）
 	viper.SetConfigType("yaml")           // 配置文件类型
 
+	// 默认值
+	viper.SetDefault("ai.comparison.sample_rate", 0.1)
+	viper.SetDefault("ai.comparison.confidence_gap", 0.3)
+	viper.SetDefault("ai.dataset.endpoint", "/device/dataset/upload")
+	viper.SetDefault("ai.dataset.uncertain_low", 0.35)
+	viper.SetDefault("ai.dataset.uncertain_high", 0.65)
+	viper.SetDefault("ai.dataset.daily_quota", 50)
+	viper.SetDefault("ai.dataset.upload_interval", 60)
+	viper.SetDefault("ai.delivery.max_attempts", 6)
+	viper.SetDefault("ai.delivery.initial_backoff", 5)
+	viper.SetDefault("ai.delivery.max_backoff", 600)
+	viper.SetDefault("ai.delivery.timeout", 10)
+	viper.SetDefault("ai.queue.job_timeout", 60)
+	viper.SetDefault("ai.queue.callback_timeout", 300)
+	viper.SetDefault("security.key_file", "/home/mingda/printer_data/config/.mingda_ai_helper.key")
+	viper.SetDefault("security.auth.enabled", true)
+	viper.SetDefault("security.auth.jwt_secret_file", "/home/mingda/printer_data/config/.mingda_ai_helper.jwt")
+	viper.SetDefault("security.auth.exempt", []string{"/api/v1/ai/health"})
+	viper.SetDefault("security.auth.loopback_only", []string{"/api/v1/ai/callback"})
+
 	fmt.Println("尝试读取配置文件...")
 	// 读取配置文件
 	if err := viper.ReadInConfig(); err != nil {
@@ -93,10 +244,131 @@ func validateConfig(config *Config) error {
 	if config.AI.Timeout <= 0 {
 		return fmt.Errorf("无效的AI超时时间: %d", config.AI.Timeout)
 	}
+	normalizeAIConfig(&config.AI)
+
+	names := make(map[string]bool)
+	for _, backend := range config.AI.Backends {
+		if backend.Name == "" || backend.Type == "" {
+			return fmt.Errorf("AI后端必须配置name和type")
+		}
+		if names[backend.Name] {
+			return fmt.Errorf("AI后端名称重复: %s", backend.Name)
+		}
+		if backend.Workers < 0 {
+			return fmt.Errorf("AI后端%s的workers不能小于0", backend.Name)
+		}
+		names[backend.Name] = true
+	}
+	if !names[config.AI.Monitor.Primary] {
+		return fmt.Errorf("监控使用的AI后端不存在: %s", config.AI.Monitor.Primary)
+	}
+	if config.AI.Monitor.Secondary != "" && !names[config.AI.Monitor.Secondary] {
+		return fmt.Errorf("监控使用的AI后端不存在: %s", config.AI.Monitor.Secondary)
+	}
+	if config.AI.Monitor.Fallback != "none" && !names[config.AI.Monitor.Fallback] {
+		return fmt.Errorf("监控使用的AI后端不存在: %s", config.AI.Monitor.Fallback)
+	}
+
+	if comparison := config.AI.Comparison; comparison.Enabled {
+		if !names[comparison.Reference] {
+			return fmt.Errorf("模型对比的参考后端不存在: %s", comparison.Reference)
+		}
+		if comparison.SampleRate <= 0 || comparison.SampleRate > 1 {
+			return fmt.Errorf("模型对比的抽样比例必须在0-1之间: %v", comparison.SampleRate)
+		}
+		if comparison.ConfidenceGap < 0 || comparison.ConfidenceGap > 1 {
+			return fmt.Errorf("模型对比的置信度差必须在0-1之间: %v", comparison.ConfidenceGap)
+		}
+	}
+
+	if dataset := config.AI.Dataset; dataset.Enabled {
+		if dataset.Endpoint == "" {
+			return fmt.Errorf("训练样本上传地址不能为空")
+		}
+		if dataset.UncertainLow < 0 || dataset.UncertainLow >= dataset.UncertainHigh || dataset.UncertainHigh > 1 {
+			return fmt.Errorf("不确定区间必须满足0 <= uncertain_low < uncertain_high <= 1")
+		}
+		if dataset.DailyQuota <= 0 || dataset.UploadInterval <= 0 {
+			return fmt.Errorf("训练样本的daily_quota和upload_interval必须大于0")
+		}
+	}
+
+	if config.AI.Queue.JobTimeout <= 0 || config.AI.Queue.CallbackTimeout <= 0 {
+		return fmt.Errorf("预测队列的job_timeout和callback_timeout必须大于0")
+	}
+
+	if delivery := config.AI.Delivery; delivery.MaxAttempts <= 0 || delivery.InitialBackoff <= 0 ||
+		delivery.MaxBackoff < delivery.InitialBackoff || delivery.Timeout <= 0 {
+		return fmt.Errorf("结果推送的max_attempts、initial_backoff、timeout必须大于0，且max_backoff不小于initial_backoff")
+	}
+
+	if auth := config.Security.Auth; auth.Enabled {
+		if auth.JWTSecretFile == "" {
+			return fmt.Errorf("启用API认证时jwt_secret_file不能为空")
+		}
+		keys := make(map[string]bool)
+		for _, key := range auth.APIKeys {
+			if key.Name == "" || len(key.Key) < 16 {
+				return fmt.Errorf("API Key必须配置name，且key至少16个字符")
+			}
+			if keys[key.Key] {
+				return fmt.Errorf("API Key重复: %s", key.Name)
+			}
+			keys[key.Key] = true
+		}
+	}
 
 	return nil
 }
 
+// normalizeAIConfig 兼容旧配置：未声明backends时按local_url、cloud_url生成
+func normalizeAIConfig(ai *AIConfig) {
+	if len(ai.Backends) == 0 {
+		ai.Backends = []BackendConfig{
+			{Name: "local", Type: "http-json", URL: ai.LocalURL, HealthURL: ai.LocalHealthURL},
+			{Name: "cloud", Type: "mingda-cloud", URL: ai.CloudURL, HealthURL: ai.CloudHealthURL},
+		}
+		if ai.Backends[0].HealthURL == "" {
+			ai.Backends[0].HealthURL = ai.LocalURL + "/health"
+		}
+		if ai.Backends[1].HealthURL == "" {
+			ai.Backends[1].HealthURL = ai.CloudURL + "/api/v1/health"
+		}
+		if ai.Monitor.Primary == "" {
+			ai.Monitor.Primary = "local"
+			ai.Monitor.Secondary = "cloud"
+		}
+	}
+
+	if ai.Monitor.Primary == "" {
+		ai.Monitor.Primary = ai.Backends[0].Name
+	}
+	if ai.Monitor.SecondaryEvery <= 0 {
+		ai.Monitor.SecondaryEvery = 4
+	}
+	// 未单独配置时自动添加内置的启发式检测
+	if ai.Monitor.Fallback == "" {
+		ai.Monitor.Fallback = "heuristic"
+	}
+	if ai.Monitor.Fallback == "heuristic" && !hasBackend(ai.Backends, "heuristic") {
+		ai.Backends = append(ai.Backends, BackendConfig{Name: "heuristic", Type: "heuristic"})
+	}
+	for i := range ai.Backends {
+		if ai.Backends[i].Timeout <= 0 {
+			ai.Backends[i].Timeout = ai.Timeout
+		}
+	}
+}
+
+func hasBackend(backends []BackendConfig, name string) bool {
+	for _, backend := range backends {
+		if backend.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // createRequiredDirectories 创建必要的目录
 func createRequiredDirectories(config *Config) error {
 	// 创建数据库目录
@@ -3,41 +3,82 @@ package handlers
 import (
 	"github.com/gin-gonic/gin"
 	"mingda_ai_helper/handlers/middleware"
+	"mingda_ai_helper/models"
 	"mingda_ai_helper/services"
 )
 
 func SetupRouter(
-	aiService services.AIService,
+	backends *services.BackendRegistry,
+	processor *services.PredictionProcessor,
+	jobs *services.PredictionQueue,
+	snapshots *services.SnapshotStore,
 	dbService services.DBInterface,
 	logService services.LogInterface,
 	moonraker *services.MoonrakerClient,
+	dataset *services.DatasetService,
+	events *services.EventBus,
+	auth *middleware.Authenticator,
 ) *gin.Engine {
 	router := gin.New() // 使用gin.New()而不是Default()以自定义中间件
+	stats := services.NewStatsCache(dbService, statsCacheTTL)
+	printer := services.NewPrinterControl(moonraker, dbService, events, logService)
+	callbacks := services.NewCallbackVerifier(backends, dbService)
 
 	// 添加全局中间件
 	router.Use(gin.Recovery())
 	router.Use(middleware.ErrorHandler(logService))
 	router.Use(middleware.RequestLogger(logService))
 
-	// API路由组
+	// API路由组，每个路由声明所需权限，豁免和仅本机访问的路由由配置决定
+	read := auth.Require(middleware.ScopeRead)
+	control := auth.Require(middleware.ScopeControl)
+	admin := auth.Require(middleware.ScopeAdmin)
 	v1 := router.Group("/api/v1")
 	{
 		// 健康检查
-		v1.GET("/ai/health", HealthCheck)
+		v1.GET("/ai/health", read, HealthCheck)
+		v1.GET("/ai/backends", read, BackendStatus(backends))
 
 		// 设备管理
-		v1.POST("/machine/register", MachineRegister(dbService, logService))
-		v1.POST("/token/refresh", TokenRefresh(dbService, logService))
+		v1.POST("/machine/register", admin, MachineRegister(dbService, logService))
+		v1.POST("/token/refresh", admin, TokenRefresh(dbService, logService))
 
 		// 用户设置
-		v1.POST("/settings/sync", SettingsSync(dbService, logService))
+		v1.POST("/settings/sync", admin, SettingsSync(dbService, events, logService))
+		v1.GET("/settings/defect-policies", read, DefectPolicies(dbService, logService))
+		v1.PUT("/settings/defect-policies", admin, UpdateDefectPolicies(dbService, events, logService))
+
+		// 报表
+		v1.POST("/reports/policy-replay", read, PolicyReplay(dbService, logService))
+		v1.GET("/reports/disagreements", read, ModelDisagreements(dbService, logService))
+		v1.GET("/reports/stats", read, DefectStats(stats, logService))
+		v1.GET("/reports/disagreements/export", read, ExportModelDisagreements(dbService, logService))
 
 		// AI预测
-		v1.POST("/predict", Predict(aiService, dbService, logService))
-		v1.POST("/ai/callback", AICallback(dbService, logService, moonraker))
+		v1.POST("/predict", control, Predict(jobs, logService))
+		v1.POST("/predict/upload", control, PredictUpload(jobs, snapshots, logService))
+		v1.POST("/ai/callback", control, AICallback(callbacks, processor, logService))
+		v1.GET("/predictions", read, ListPredictions(dbService, logService))
+		v1.GET("/predictions/export", read, ExportPredictions(dbService, logService))
+		v1.GET("/predictions/:task_id", read, GetPrediction(dbService, logService))
+		v1.GET("/predictions/:task_id/export", read, ExportPrediction(dbService, logService))
+		v1.GET("/predictions/:task_id/deliveries", read, PredictionDeliveries(dbService, logService))
+		v1.POST("/predictions/:task_id/flag", control, FlagPrediction(dataset, logService))
+		v1.POST("/predictions/:task_id/feedback", control, PredictionFeedback(dbService, dataset, logService))
+
+		// 训练样本
+		v1.GET("/dataset/status", read, DatasetStatus(dataset, logService))
+		v1.GET("/dataset/export", admin, ExportDataset(dbService, logService))
+
+		// 实时事件
+		v1.GET("/events", read, EventStream(events))
+		v1.GET("/events/ws", read, EventWebSocket(events, auth.CheckOrigin, logService))
 
 		// 打印机控制
-		v1.POST("/printer/pause", PrinterPause(logService))
+		v1.POST("/printer/pause", control, PrinterCommand(models.CommandPause, printer, logService))
+		v1.POST("/printer/resume", control, PrinterCommand(models.CommandResume, printer, logService))
+		v1.POST("/printer/cancel", control, PrinterCommand(models.CommandCancel, printer, logService))
+		v1.GET("/printer/actions", read, PrinterActions(dbService, logService))
 	}
 
 	return router
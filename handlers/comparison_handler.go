@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxDisagreementList 分歧列表一次最多返回的条数
+const maxDisagreementList = 500
+
+// queryTime 解析RFC3339格式的时间参数，未传时返回零值
+func queryTime(c *gin.Context, key string) (time.Time, error) {
+	v := c.Query(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("无效的%s: %s", key, v)
+	}
+	return t, nil
+}
+
+// queryTimeRange 解析since、until(RFC3339)查询参数，until默认为当前时间，since默认为until之前days天
+func queryTimeRange(c *gin.Context, days int) (since, until time.Time, err error) {
+	if until, err = queryTime(c, "until"); err != nil {
+		return since, until, err
+	}
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if since, err = queryTime(c, "since"); err != nil {
+		return since, until, err
+	}
+	if since.IsZero() {
+		since = until.AddDate(0, 0, -days)
+	}
+	if !since.Before(until) {
+		return since, until, fmt.Errorf("since必须早于until")
+	}
+	return since, until, nil
+}
+
+// disagreementFilter 解析时间范围和kind参数，时间范围默认最近7天
+func disagreementFilter(c *gin.Context) (services.DisagreementFilter, error) {
+	filter := services.DisagreementFilter{Kind: models.DisagreementKind(c.Query("kind"))}
+	var err error
+	if filter.Since, filter.Until, err = queryTimeRange(c, 7); err != nil {
+		return filter, err
+	}
+	switch filter.Kind {
+	case "", models.DisagreeVerdict, models.DisagreeDefectType, models.DisagreeConfidence:
+	default:
+		return filter, fmt.Errorf("无效的分歧类型: %s", filter.Kind)
+	}
+	return filter, nil
+}
+
+// ModelDisagreements 列出模型分歧，并返回同一时间段内两个模型的一致率
+func ModelDisagreements(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := disagreementFilter(c)
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		filter.Limit = 100
+		if v := c.Query("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit <= 0 || limit > maxDisagreementList {
+				response.ValidationError(c, fmt.Sprintf("limit必须在1-%d之间", maxDisagreementList))
+				return
+			}
+			filter.Limit = limit
+		}
+
+		total, agreed, err := db.CountModelComparisons(filter.Since, filter.Until)
+		if err != nil {
+			log.Error("统计模型对比失败", zap.Error(err))
+			response.ServerError(c, "统计模型对比失败")
+			return
+		}
+		disagreements, err := db.ListModelDisagreements(filter)
+		if err != nil {
+			log.Error("获取模型分歧失败", zap.Error(err))
+			response.ServerError(c, "获取模型分歧失败")
+			return
+		}
+
+		var agreement float64
+		if total > 0 {
+			agreement = float64(agreed) / float64(total)
+		}
+		response.Success(c, gin.H{
+			"since":         filter.Since,
+			"until":         filter.Until,
+			"comparisons":   total,
+			"agreement":     agreement,
+			"disagreements": disagreements,
+		})
+	}
+}
+
+// ExportModelDisagreements 以CSV导出时间段内的全部分歧，置信度为0-1
+func ExportModelDisagreements(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := disagreementFilter(c)
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+
+		disagreements, err := db.ListModelDisagreements(filter)
+		if err != nil {
+			log.Error("获取模型分歧失败", zap.Error(err))
+			response.ServerError(c, "获取模型分歧失败")
+			return
+		}
+
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="disagreements_%s.csv"`, filter.Until.Format("20060102")))
+
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{
+			"time", "task_id", "kind", "image_path",
+			"primary_backend", "primary_model", "primary_has_defect", "primary_defect_type", "primary_confidence",
+			"reference_backend", "reference_model", "reference_has_defect", "reference_defect_type", "reference_confidence",
+		})
+		for _, d := range disagreements {
+			p, r := d.Comparison.Primary, d.Comparison.Reference
+			w.Write([]string{
+				d.CreatedAt.Format(time.RFC3339), d.Comparison.TaskID, string(d.Kind), d.Comparison.ImagePath,
+				p.Backend, p.PredictionModel, strconv.FormatBool(p.HasDefect), string(p.DefectType), strconv.FormatFloat(float64(p.Confidence), 'f', 4, 64),
+				r.Backend, r.PredictionModel, strconv.FormatBool(r.HasDefect), string(r.DefectType), strconv.FormatFloat(float64(r.Confidence), 'f', 4, 64),
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			log.Error("导出模型分歧失败", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// FlagPrediction 用户标记预测结果（如误报、漏报），快照加入训练样本上传队列
+func FlagPrediction(dataset *services.DatasetService, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Note string `json:"note" binding:"max=255"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.ValidationError(c, "无效的请求参数")
+			return
+		}
+
+		sample, err := dataset.Flag(c.Param("task_id"), req.Note)
+		if err != nil {
+			if !errors.Is(err, services.ErrDatasetDisabled) && !errors.Is(err, services.ErrDatasetOptOut) {
+				log.Error("标记预测结果失败", zap.String("task_id", c.Param("task_id")), zap.Error(err))
+			}
+			response.ValidationError(c, err.Error())
+			return
+		}
+
+		response.Success(c, gin.H{"sample": sample})
+	}
+}
+
+// DatasetStatus 训练样本上传队列和当天配额
+func DatasetStatus(dataset *services.DatasetService, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, err := dataset.Status()
+		if err != nil {
+			log.Error("获取训练样本状态失败", zap.Error(err))
+			response.ServerError(c, "获取训练样本状态失败")
+			return
+		}
+		response.Success(c, status)
+	}
+}
+
+// ExportDataset 把快照、检测框和用户反馈打包为YOLO或COCO格式的zip。
+// 查询参数：format(yolo|coco)、since、until(默认最近30天)、printer、defect_type、labelled_only
+func ExportDataset(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := services.ExportFormat(c.DefaultQuery("format", string(services.ExportYOLO)))
+		if format != services.ExportYOLO && format != services.ExportCOCO {
+			response.ValidationError(c, "format必须为yolo或coco")
+			return
+		}
+		filter := services.ExportFilter{
+			Printer:      c.Query("printer"),
+			DefectType:   models.DefectType(c.Query("defect_type")),
+			LabelledOnly: c.Query("labelled_only") == "true",
+		}
+		if filter.DefectType != "" && !filter.DefectType.Valid() {
+			response.ValidationError(c, "未知的缺陷类型: "+string(filter.DefectType))
+			return
+		}
+		var err error
+		if filter.Since, filter.Until, err = queryTimeRange(c, 30); err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+
+		results, err := services.ListExportResults(db, filter)
+		if err != nil {
+			log.Error("获取导出数据失败", zap.Error(err))
+			response.ServerError(c, "获取导出数据失败")
+			return
+		}
+
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="dataset_%s_%s.zip"`, format, filter.Until.Format("20060102")))
+		summary, err := services.ExportDataset(c.Writer, format, results)
+		if err != nil {
+			// 响应已开始写入，只能记录日志
+			log.Error("导出数据集失败", zap.Error(err))
+			return
+		}
+		log.Info("导出数据集完成",
+			zap.String("format", string(format)),
+			zap.Int("images", summary.Images),
+			zap.Int("annotations", summary.Annotations),
+			zap.Int("skipped", summary.Skipped))
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// eventHeartbeat 连接空闲时的心跳间隔，避免代理断开长连接
+const eventHeartbeat = 15 * time.Second
+
+// EventStream 以Server-Sent Events推送实时事件，types为逗号分隔的事件类型，不传时推送全部
+func EventStream(events *services.EventBus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		types, err := services.ParseEventTypes(c.Query("types"))
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		sub := events.Subscribe(types...)
+		defer sub.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case event, ok := <-sub.Events():
+				if !ok {
+					return false
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					return true
+				}
+				_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+				return err == nil
+			case <-heartbeat.C:
+				_, err := io.WriteString(w, ": ping\n\n")
+				return err == nil
+			}
+		})
+	}
+}
+
+// EventWebSocket 以WebSocket推送实时事件，每条消息为一个JSON事件，参数与EventStream相同。
+// checkOrigin校验握手请求的来源，拒绝时返回403
+func EventWebSocket(events *services.EventBus, checkOrigin func(*http.Request) bool, log services.LogInterface) gin.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: checkOrigin}
+	return func(c *gin.Context) {
+		types, err := services.ParseEventTypes(c.Query("types"))
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// Upgrade已写入错误响应
+			log.Error("建立事件WebSocket失败", zap.Error(err))
+			return
+		}
+		defer conn.Close()
+
+		sub := events.Subscribe(types...)
+		defer sub.Close()
+
+		// 客户端不发送消息，读取只用于处理ping/pong和发现连接断开
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case event, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
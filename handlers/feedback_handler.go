@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// PredictionFeedback 用户对预测结果的反馈。误报恢复打印后可以只提交 {"verdict": "false_positive"}。
+// 误报和漏报在开启训练样本上传时自动加入上传队列
+func PredictionFeedback(db services.DBInterface, dataset *services.DatasetService, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var feedback models.PredictionFeedback
+		if err := c.ShouldBindJSON(&feedback); err != nil {
+			response.ValidationError(c, "无效的反馈参数")
+			return
+		}
+		feedback.At = nil
+
+		taskID := c.Param("task_id")
+		result, err := db.GetPredictionResult(taskID)
+		if err != nil {
+			log.Error("获取预测结果失败", zap.Error(err))
+			response.ServerError(c, "获取预测结果失败")
+			return
+		}
+		if result == nil {
+			response.NotFound(c, "预测任务不存在")
+			return
+		}
+		if err := feedback.Validate(result); err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+
+		if err := db.SavePredictionFeedback(taskID, &feedback); err != nil {
+			log.Error("保存反馈失败", zap.Error(err))
+			response.ServerError(c, "保存反馈失败")
+			return
+		}
+
+		if feedback.Verdict == models.FeedbackFalsePositive || feedback.Verdict == models.FeedbackFalseNegative {
+			note := string(feedback.Verdict)
+			if feedback.Note != "" {
+				note += ": " + feedback.Note
+			}
+			if _, err := dataset.Flag(taskID, note); err != nil &&
+				!errors.Is(err, services.ErrDatasetDisabled) && !errors.Is(err, services.ErrDatasetOptOut) {
+				log.Error("反馈样本加入上传队列失败", zap.String("task_id", taskID), zap.Error(err))
+			}
+		}
+
+		response.Success(c, gin.H{"task_id": taskID, "feedback": feedback})
+	}
+}
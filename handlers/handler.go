@@ -1,7 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"go.uber.org/zap"
 	"mingda_ai_helper/models"
 	"mingda_ai_helper/pkg/response"
@@ -13,6 +19,13 @@ func HealthCheck(c *gin.Context) {
 	response.Success(c, gin.H{"status": "ok"})
 }
 
+// BackendStatus AI后端列表及健康、熔断状态
+func BackendStatus(backends *services.BackendRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response.Success(c, gin.H{"backends": backends.Infos()})
+	}
+}
+
 // MachineRegister 设备注册
 func MachineRegister(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -69,7 +82,7 @@ func TokenRefresh(db services.DBInterface, log services.LogInterface) gin.Handle
 }
 
 // SettingsSync 同步用户设置
-func SettingsSync(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+func SettingsSync(db services.DBInterface, events *services.EventBus, log services.LogInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var settings models.UserSettings
 		if err := c.ShouldBindJSON(&settings); err != nil {
@@ -88,125 +101,152 @@ func SettingsSync(db services.DBInterface, log services.LogInterface) gin.Handle
 			response.ServerError(c, "保存用户设置失败")
 			return
 		}
+		events.Publish(services.EventSettingsChanged, gin.H{"kind": "user_settings", "settings": settings})
 
 		response.Success(c, gin.H{"status": "ok"})
 	}
 }
 
-// Predict AI预测请求
-func Predict(ai services.AIService, db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+// DefectPolicies 获取缺陷处理策略
+func DefectPolicies(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req struct {
-			ImageURL    string `json:"image_url" binding:"required,url"`
-			TaskID      string `json:"task_id" binding:"required"`
-			CallbackURL string `json:"callback_url" binding:"required,url"`
-		}
-
-		if err := c.ShouldBindJSON(&req); err != nil {
-			response.ValidationError(c, "无效的请求参数")
+		policies, err := db.GetDefectPolicies()
+		if err != nil {
+			log.Error("获取缺陷处理策略失败", zap.Error(err))
+			response.ServerError(c, "获取缺陷处理策略失败")
 			return
 		}
 
-		// 创建预测任务
-		result := &models.PredictionResult{
-			TaskID:           req.TaskID,
-			PredictionStatus: models.StatusPending,
+		response.Success(c, gin.H{"policies": policies})
+	}
+}
+
+// UpdateDefectPolicies 更新缺陷处理策略，未包含的缺陷类型保持不变
+func UpdateDefectPolicies(db services.DBInterface, events *services.EventBus, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Policies []models.DefectPolicy `json:"policies" binding:"required,min=1"`
 		}
 
-		// 保存初始状态
-		if err := db.SavePredictionResult(result); err != nil {
-			log.Error("保存预测任务失败", zap.Error(err))
-			response.ServerError(c, "保存预测任务失败")
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.ValidationError(c, "无效的策略参数")
 			return
 		}
 
-		// 异步执行预测
-		go func() {
-			result, err := ai.Predict(c.Request.Context(), req.ImageURL, req.TaskID)
-			if err != nil {
-				log.Error("预测失败", zap.Error(err))
+		seen := make(map[models.DefectType]bool)
+		for i := range req.Policies {
+			if err := req.Policies[i].Validate(); err != nil {
+				response.ValidationError(c, err.Error())
 				return
 			}
-
-			if err := db.SavePredictionResult(result); err != nil {
-				log.Error("保存预测结果失败", zap.Error(err))
+			if seen[req.Policies[i].DefectType] {
+				response.ValidationError(c, "缺陷类型重复: "+string(req.Policies[i].DefectType))
+				return
 			}
-		}()
+			seen[req.Policies[i].DefectType] = true
+		}
 
-		response.Success(c, gin.H{"task_id": req.TaskID})
+		if err := db.SaveDefectPolicies(req.Policies); err != nil {
+			log.Error("保存缺陷处理策略失败", zap.Error(err))
+			response.ServerError(c, "保存缺陷处理策略失败")
+			return
+		}
+		events.Publish(services.EventSettingsChanged, gin.H{"kind": "defect_policies", "policies": req.Policies})
+
+		response.Success(c, gin.H{"status": "ok"})
 	}
 }
 
-// AICallback AI回调处理
-func AICallback(db services.DBInterface, log services.LogInterface, moonraker *services.MoonrakerClient) gin.HandlerFunc {
+// maxReplayCandidates 一次回放最多评估的方案数
+const maxReplayCandidates = 20
+
+// PolicyReplay 用候选阈值或策略回放已保存的预测结果，统计各方案会触发多少次暂停。
+// 结果的第一项总是当前设置，便于对比
+func PolicyReplay(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			TaskID  string `json:"task_id" binding:"required"`
-			Status  string `json:"status" binding:"required"`
-			Result  struct {
-				PredictModel string  `json:"predict_model"`
-				HasDefect    bool    `json:"has_defect"`
-				DefectType   string  `json:"defect_type"`
-				Confidence   float64 `json:"confidence"`
-			} `json:"result"`
+			Since      *time.Time                 `json:"since"` // 默认最近7天
+			Until      *time.Time                 `json:"until"` // 默认当前时间
+			Candidates []services.ReplayCandidate `json:"candidates"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
-			response.ValidationError(c, "无效的回调参数")
+			response.ValidationError(c, "无效的回放参数")
 			return
 		}
-
-		// 更新预测结果
-		result := &models.PredictionResult{
-			TaskID:           req.TaskID,
-			PredictionStatus: models.StatusCompleted,
-			PredictionModel:  req.Result.PredictModel,
-			HasDefect:        req.Result.HasDefect,
-			DefectType:       req.Result.DefectType,
-			Confidence:       req.Result.Confidence * 100, // 转换为百分比
+		if len(req.Candidates) > maxReplayCandidates {
+			response.ValidationError(c, fmt.Sprintf("候选方案不能超过%d个", maxReplayCandidates))
+			return
 		}
 
-		if err := db.SavePredictionResult(result); err != nil {
-			log.Error("保存预测结果失败", zap.Error(err))
-			response.ServerError(c, "保存预测结果失败")
+		until := time.Now()
+		if req.Until != nil {
+			until = *req.Until
+		}
+		since := until.AddDate(0, 0, -7)
+		if req.Since != nil {
+			since = *req.Since
+		}
+		if !since.Before(until) {
+			response.ValidationError(c, "since必须早于until")
 			return
 		}
 
-		// 检查是否需要暂停打印
 		settings, err := db.GetUserSettings()
 		if err != nil {
 			log.Error("获取用户设置失败", zap.Error(err))
 			response.ServerError(c, "获取用户设置失败")
 			return
 		}
+		policies, err := db.GetDefectPolicies()
+		if err != nil {
+			log.Error("获取缺陷处理策略失败", zap.Error(err))
+			response.ServerError(c, "获取缺陷处理策略失败")
+			return
+		}
 
-		if settings.PauseOnThreshold && result.HasDefect && result.Confidence >= float64(settings.ConfidenceThreshold) {
-			// 获取打印机状态
-			status, err := moonraker.GetPrinterStatus()
+		candidates := append([]services.ReplayCandidate{{Name: "current"}}, req.Candidates...)
+		sets := make([]services.PolicySet, len(candidates))
+		for i, candidate := range candidates {
+			if candidate.Name == "" {
+				candidates[i].Name = fmt.Sprintf("candidate-%d", i)
+			}
+			set, err := services.CandidatePolicySet(candidate, policies, settings)
 			if err != nil {
-				log.Error("获取打印机状态失败", zap.Error(err))
-			} else if status != nil && status.IsPrinting() {
-				// 调用打印机暂停接口
-				if err := moonraker.PausePrint(); err != nil {
-					log.Error("暂停打印失败", zap.Error(err))
-				} else {
-					log.Info("已暂停打印", 
-						zap.String("task_id", result.TaskID), 
-						zap.Float64("confidence", result.Confidence),
-						zap.String("defect_type", result.DefectType))
-				}
+				response.ValidationError(c, fmt.Sprintf("%s: %v", candidates[i].Name, err))
+				return
 			}
+			sets[i] = set
 		}
 
-		response.Success(c, gin.H{"status": "ok"})
+		results, err := db.ListCompletedPredictions(since, until)
+		if err != nil {
+			log.Error("获取预测结果失败", zap.Error(err))
+			response.ServerError(c, "获取预测结果失败")
+			return
+		}
+
+		reports := make([]services.ReplayReport, len(candidates))
+		for i := range candidates {
+			reports[i] = services.ReplayPolicies(candidates[i].Name, sets[i], results)
+		}
+
+		response.Success(c, gin.H{
+			"since":   since,
+			"until":   until,
+			"reports": reports,
+		})
 	}
 }
 
-// PrinterPause 打印机暂停
-func PrinterPause(log services.LogInterface) gin.HandlerFunc {
+// Predict AI预测请求
+func Predict(jobs *services.PredictionQueue, log services.LogInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			MachineSN string `json:"machine_sn" binding:"required"`
+			ImageURL    string `json:"image_url" binding:"required,url"`
+			TaskID      string `json:"task_id" binding:"required"`
+			CallbackURL string `json:"callback_url" binding:"required,url"`
+			Backend     string `json:"backend"` // 可选，为空时使用默认后端
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -214,9 +254,103 @@ func PrinterPause(log services.LogInterface) gin.HandlerFunc {
 			return
 		}
 
-		// TODO: 实现打印机暂停逻辑，需要调用Moonraker客户端
-		log.Info("请求打印机暂停", zap.String("machine_sn", req.MachineSN))
+		// 加入预测队列，手动请求优先于定时检测；完成、失败或超时后推送到callback_url
+		job, err := jobs.Submit(services.PredictionRequest{
+			TaskID:      req.TaskID,
+			Backend:     req.Backend,
+			ImageURL:    req.ImageURL,
+			Priority:    models.PriorityManual,
+			CallbackURL: req.CallbackURL,
+		})
+		switch {
+		case errors.Is(err, services.ErrTaskExists):
+			response.Error(c, http.StatusConflict, err.Error())
+			return
+		case errors.Is(err, services.ErrUnknownBackend):
+			response.ValidationError(c, err.Error())
+			return
+		case err != nil:
+			log.Error("保存预测任务失败", zap.Error(err))
+			response.ServerError(c, "保存预测任务失败")
+			return
+		}
+
+		response.Success(c, gin.H{"task_id": job.TaskID, "backend": job.Backend})
+	}
+}
+
+// AICallback AI回调处理。回调必须带有所属后端的签名，且只能提交本机创建、仍在等待结果的任务
+func AICallback(callbacks *services.CallbackVerifier, processor *services.PredictionProcessor, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			TaskID  string `json:"task_id" binding:"required"`
+			Status  string `json:"status" binding:"required"` // success，其他状态按失败处理
+			Error   string `json:"error"`                     // 失败原因，可选
+			Result  struct {
+				PredictModel string  `json:"predict_model"`
+				HasDefect    bool    `json:"has_defect"`
+				DefectType   string  `json:"defect_type"`
+				Confidence   float64 `json:"confidence"`
+			} `json:"result"`
+		}
+
+		// 签名针对原始请求体计算
+		body, err := c.GetRawData()
+		if err != nil || binding.JSON.BindBody(body, &req) != nil {
+			response.ValidationError(c, "无效的回调参数")
+			return
+		}
+
+		if _, err := callbacks.Verify(req.TaskID,
+			c.GetHeader(services.CallbackTimestampHeader),
+			c.GetHeader(services.CallbackNonceHeader),
+			c.GetHeader(services.CallbackSignatureHeader),
+			body); err != nil {
+			log.Info("拒绝AI回调", zap.String("task_id", req.TaskID), zap.String("remote_ip", c.RemoteIP()), zap.Error(err))
+			switch {
+			case errors.Is(err, services.ErrCallbackSignature), errors.Is(err, services.ErrCallbackReplay):
+				response.UnauthorizedError(c)
+			case errors.Is(err, services.ErrCallbackUnknownTask):
+				response.NotFound(c, err.Error())
+			case errors.Is(err, services.ErrCallbackTaskDone):
+				response.Error(c, http.StatusConflict, err.Error())
+			default:
+				response.ServerError(c, "校验回调失败")
+			}
+			return
+		}
+
+		// 后端报告失败或超时时以失败结束任务，不能当作没有缺陷的结果保存
+		if req.Status != "success" {
+			message := "AI后端返回状态: " + req.Status
+			if req.Error != "" {
+				message += ", " + req.Error
+			}
+			processor.Fail(req.TaskID, message)
+			response.Success(c, gin.H{"status": "ok"})
+			return
+		}
+
+		// 更新预测结果
+		result := &models.PredictionResult{
+			TaskID:           req.TaskID,
+			PredictionStatus: models.StatusCompleted,
+			PredictionModel:  req.Result.PredictModel,
+			HasDefect:        req.Result.HasDefect,
+			DefectType:       models.DefectType(req.Result.DefectType),
+			Confidence:       models.Confidence(req.Result.Confidence), // 0-1，按后端配置换算
+		}
+
+		if err := processor.Process(result); err != nil {
+			log.Error("处理预测结果失败", zap.Error(err))
+			if errors.Is(err, services.ErrInvalidConfidence) {
+				response.ValidationError(c, err.Error())
+				return
+			}
+			response.ServerError(c, "处理预测结果失败")
+			return
+		}
 
 		response.Success(c, gin.H{"status": "ok"})
 	}
-} 
\ No newline at end of file
+}
@@ -1,21 +1,38 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"image"
+	imagepng "image/png"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
 
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/handlers/middleware"
 	"mingda_ai_helper/models"
 	"mingda_ai_helper/pkg/response"
 	"mingda_ai_helper/services"
+	"mingda_ai_helper/utils"
 )
 
 // MockDBService 模拟数据库服务
@@ -59,6 +76,103 @@ func (m *MockDBService) SavePredictionResult(result *models.PredictionResult) er
 	return args.Error(0)
 }
 
+func (m *MockDBService) GetPredictionResult(taskID string) (*models.PredictionResult, error) {
+	args := m.Called(taskID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PredictionResult), args.Error(1)
+}
+
+func (m *MockDBService) GetDefectPolicies() ([]models.DefectPolicy, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.DefectPolicy), args.Error(1)
+}
+
+func (m *MockDBService) SaveDefectPolicies(policies []models.DefectPolicy) error {
+	args := m.Called(policies)
+	return args.Error(0)
+}
+
+func (m *MockDBService) ListPredictions(q services.PredictionQuery) ([]models.PredictionResult, string, error) {
+	args := m.Called(q)
+	if args.Get(0) == nil {
+		return nil, args.String(1), args.Error(2)
+	}
+	return args.Get(0).([]models.PredictionResult), args.String(1), args.Error(2)
+}
+
+func (m *MockDBService) DefectStats(q services.StatsQuery) ([]services.StatsGroup, error) {
+	args := m.Called(q)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]services.StatsGroup), args.Error(1)
+}
+
+func (m *MockDBService) SavePrinterAction(action *models.PrinterAction) error {
+	args := m.Called(action)
+	return args.Error(0)
+}
+
+func (m *MockDBService) ListPrinterActions(limit int) ([]models.PrinterAction, error) {
+	args := m.Called(limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.PrinterAction), args.Error(1)
+}
+
+func (m *MockDBService) ListResultDeliveries(taskID string) ([]models.ResultDelivery, error) {
+	args := m.Called(taskID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.ResultDelivery), args.Error(1)
+}
+
+func (m *MockDBService) ListExportPredictions(filter services.ExportFilter) ([]models.PredictionResult, error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.PredictionResult), args.Error(1)
+}
+
+func (m *MockDBService) SavePredictionFeedback(taskID string, feedback *models.PredictionFeedback) error {
+	args := m.Called(taskID, feedback)
+	return args.Error(0)
+}
+
+func (m *MockDBService) UpdatePredictionAction(taskID string, action models.PolicyAction, shadow bool) error {
+	args := m.Called(taskID, action, shadow)
+	return args.Error(0)
+}
+
+func (m *MockDBService) ListCompletedPredictions(since, until time.Time) ([]models.PredictionResult, error) {
+	args := m.Called(since, until)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.PredictionResult), args.Error(1)
+}
+
+func (m *MockDBService) ListModelDisagreements(filter services.DisagreementFilter) ([]models.ModelDisagreement, error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.ModelDisagreement), args.Error(1)
+}
+
+func (m *MockDBService) CountModelComparisons(since, until time.Time) (int64, int64, error) {
+	args := m.Called(since, until)
+	return args.Get(0).(int64), args.Get(1).(int64), args.Error(2)
+}
+
 // MockAIService 模拟AI服务
 type MockAIService struct {
 	mock.Mock
@@ -72,6 +186,14 @@ func (m *MockAIService) Predict(ctx context.Context, imageURL string, taskID str
 	return args.Get(0).(*models.PredictionResult), args.Error(1)
 }
 
+func (m *MockAIService) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
+	args := m.Called(ctx, imagePath, taskID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PredictionResult), args.Error(1)
+}
+
 // MockLogService 模拟日志服务
 type MockLogService struct {
 	mock.Mock
@@ -85,13 +207,73 @@ func (m *MockLogService) Error(msg string, fields ...zap.Field) {
 	m.Called(msg, fields)
 }
 
+// newTestMoonraker 创建连接到模拟Moonraker的客户端，打印机处于空闲状态
+func newTestMoonraker(t *testing.T) *services.MoonrakerClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":{"status":{"print_stats":{"state":"standby"}}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	logService, err := services.NewLogService("error", filepath.Join(t.TempDir(), "test.log"))
+	if err != nil {
+		t.Fatalf("初始化日志服务失败: %v", err)
+	}
+
+	serverURL, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverURL.Port())
+	return services.NewMoonrakerClient(config.MoonrakerConfig{Host: serverURL.Hostname(), Port: port}, logService)
+}
+
+const testCallbackSecret = "test-callback-secret"
+
+// signedCallback 创建带签名的回调请求
+func signedCallback(body []byte, nonce string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, _ := http.NewRequest("POST", "/api/v1/ai/callback", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(services.CallbackTimestampHeader, timestamp)
+	req.Header.Set(services.CallbackNonceHeader, nonce)
+	req.Header.Set(services.CallbackSignatureHeader, services.SignCallback([]byte(testCallbackSecret), timestamp, nonce, body))
+	return req
+}
+
 // setupTestRouter 测试辅助函数
-func setupTestRouter(db *MockDBService, ai *MockAIService, log *MockLogService) *gin.Engine {
+func setupTestRouter(t *testing.T, db *MockDBService, ai *MockAIService, log *MockLogService) *gin.Engine {
+	router, _, _ := setupQueueRouter(t, db, ai, log, config.AuthConfig{}, nil)
+	return router
+}
+
+// setupAuthRouter 按认证配置创建路由
+func setupAuthRouter(t *testing.T, db *MockDBService, ai *MockAIService, log *MockLogService, authCfg config.AuthConfig, secret []byte) *gin.Engine {
+	router, _, _ := setupQueueRouter(t, db, ai, log, authCfg, secret)
+	return router
+}
+
+// setupQueueRouter 创建路由，同时返回预测队列及其数据库。队列使用临时的sqlite数据库，worker不启动，由测试调用RunNext
+func setupQueueRouter(t *testing.T, db *MockDBService, ai *MockAIService, log *MockLogService, authCfg config.AuthConfig, secret []byte) (*gin.Engine, *services.PredictionQueue, *services.DBService) {
 	gin.SetMode(gin.TestMode)
 	// 设置日志服务的通用期望
 	log.On("Info", mock.Anything, mock.Anything).Return()
 	log.On("Error", mock.Anything, mock.Anything).Return()
-	return SetupRouter(ai, db, log)
+	backends := services.NewBackendRegistry(services.NewHealthMonitor(log))
+	backends.Add("test", "mock", services.InputURL, nil, ai, "").CallbackSecret = []byte(testCallbackSecret)
+	moonraker := newTestMoonraker(t)
+	processor := services.NewPredictionProcessor(backends, db, moonraker, log)
+	queueDB, err := services.NewDBService(filepath.Join(t.TempDir(), "queue.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := services.NewPredictionQueue(config.QueueConfig{JobTimeout: 5, CallbackTimeout: 60}, backends, processor, queueDB, log)
+	snapshots, err := services.NewSnapshotStore(filepath.Join(t.TempDir(), "snapshots"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := middleware.NewAuthenticator(authCfg, secret, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor.SetPredictionQueue(queue)
+	return SetupRouter(backends, processor, queue, snapshots, db, log, moonraker, nil, services.NewEventBus(), auth), queue, queueDB
 }
 
 // TestHealthCheck 测试健康检查接口
@@ -99,7 +281,7 @@ func TestHealthCheck(t *testing.T) {
 	db := new(MockDBService)
 	ai := new(MockAIService)
 	log := new(MockLogService)
-	router := setupTestRouter(db, ai, log)
+	router := setupTestRouter(t, db, ai, log)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/v1/ai/health", nil)
@@ -118,7 +300,7 @@ func TestMachineRegister(t *testing.T) {
 	db := new(MockDBService)
 	ai := new(MockAIService)
 	log := new(MockLogService)
-	router := setupTestRouter(db, ai, log)
+	router := setupTestRouter(t, db, ai, log)
 
 	// 准备测试数据
 	reqBody := map[string]string{
@@ -151,7 +333,7 @@ func TestSettingsSync(t *testing.T) {
 	db := new(MockDBService)
 	ai := new(MockAIService)
 	log := new(MockLogService)
-	router := setupTestRouter(db, ai, log)
+	router := setupTestRouter(t, db, ai, log)
 
 	// 准备测试数据
 	settings := models.UserSettings{
@@ -184,7 +366,16 @@ func TestPredict(t *testing.T) {
 	db := new(MockDBService)
 	ai := new(MockAIService)
 	log := new(MockLogService)
-	router := setupTestRouter(db, ai, log)
+	router, queue, queueDB := setupQueueRouter(t, db, ai, log, config.AuthConfig{}, nil)
+
+	post := func(body map[string]string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/predict", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
 
 	// 准备测试数据
 	reqBody := map[string]string{
@@ -192,16 +383,15 @@ func TestPredict(t *testing.T) {
 		"task_id":      "TASK001",
 		"callback_url": "http://callback.example.com",
 	}
-	jsonBody, _ := json.Marshal(reqBody)
 
 	// 设置Mock期望
-	db.On("SavePredictionResult", mock.AnythingOfType("*models.PredictionResult")).Return(nil)
+	ai.On("Predict", mock.Anything, "http://example.com/test.jpg", "TASK001").Return(&models.PredictionResult{
+		TaskID:           "TASK001",
+		PredictionStatus: models.StatusProcessing,
+	}, nil)
 
 	// 发送请求
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/v1/predict", bytes.NewBuffer(jsonBody))
-	req.Header.Set("Content-Type", "application/json")
-	router.ServeHTTP(w, req)
+	w := post(reqBody)
 
 	// 验证结果
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -214,6 +404,167 @@ func TestPredict(t *testing.T) {
 	data, ok := resp.Data.(map[string]interface{})
 	assert.True(t, ok)
 	assert.Equal(t, "TASK001", data["task_id"])
+	assert.Equal(t, "test", data["backend"])
+
+	// 任务进入队列，预测结果为等待中，推送地址等待预测完成
+	pending, err := queueDB.GetPredictionResult("TASK001")
+	assert.NoError(t, err)
+	if assert.NotNil(t, pending) {
+		assert.Equal(t, models.StatusPending, pending.PredictionStatus)
+	}
+	list, err := queueDB.ListResultDeliveries("TASK001")
+	assert.NoError(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "http://callback.example.com", list[0].URL)
+		assert.Equal(t, models.DeliveryWaiting, list[0].Status)
+	}
+
+	// 重复的task_id和不存在的后端
+	assert.Equal(t, http.StatusConflict, post(reqBody).Code)
+	assert.Equal(t, http.StatusBadRequest, post(map[string]string{
+		"image_url": "http://example.com/test.jpg", "task_id": "TASK002", "callback_url": "http://callback.example.com", "backend": "missing",
+	}).Code)
+
+	// worker取出任务发送给后端，异步后端受理后等待回调
+	assert.True(t, queue.RunNext(context.Background(), "test"))
+	assert.False(t, queue.RunNext(context.Background(), "test"))
+	ai.AssertCalled(t, "Predict", mock.Anything, "http://example.com/test.jpg", "TASK001")
+	processing, err := queueDB.GetPredictionResult("TASK001")
+	assert.NoError(t, err)
+	if assert.NotNil(t, processing) {
+		assert.Equal(t, models.StatusProcessing, processing.PredictionStatus)
+	}
+
+	// 推送记录
+	db.On("GetPredictionResult", "TASK001").Return(&models.PredictionResult{TaskID: "TASK001"}, nil)
+	db.On("GetPredictionResult", "TASK404").Return(nil, nil)
+	db.On("ListResultDeliveries", "TASK001").Return([]models.ResultDelivery{{
+		TaskID: "TASK001", URL: "http://callback.example.com", Status: models.DeliveryPending, Attempts: 1, LastStatusCode: 503,
+		History: []models.DeliveryAttempt{{Attempt: 1, StatusCode: 503, Error: "对方返回503"}},
+	}}, nil)
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/predictions/TASK001/deliveries", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deliveries struct {
+		Data struct {
+			Deliveries []models.ResultDelivery `json:"deliveries"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	if assert.Len(t, deliveries.Data.Deliveries, 1) {
+		assert.Equal(t, models.DeliveryPending, deliveries.Data.Deliveries[0].Status)
+		assert.Len(t, deliveries.Data.Deliveries[0].History, 1)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/predictions/TASK404/deliveries", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// uploadImage 以multipart表单上传图片
+func uploadImage(router *gin.Engine, data []byte, fields map[string]string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	for k, v := range fields {
+		form.WriteField(k, v)
+	}
+	part, _ := form.CreateFormFile("image", "phone.jpg")
+	part.Write(data)
+	form.Close()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/predict/upload", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestPredictUpload 测试上传图片预测：格式和大小校验、保存到快照目录、同步等待结果
+func TestPredictUpload(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router, queue, queueDB := setupQueueRouter(t, db, ai, log, config.AuthConfig{}, nil)
+
+	var png bytes.Buffer
+	assert.NoError(t, imagepng.Encode(&png, image.NewRGBA(image.Rect(0, 0, 8, 8))))
+
+	// 不等待结果
+	w := uploadImage(router, png.Bytes(), map[string]string{"task_id": "PU001"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	saved, err := queueDB.GetPredictionResult("PU001")
+	assert.NoError(t, err)
+	if assert.NotNil(t, saved) {
+		assert.Equal(t, models.StatusPending, saved.PredictionStatus)
+		assert.True(t, strings.HasSuffix(saved.ImagePath, ".png"))
+		data, err := os.ReadFile(saved.ImagePath)
+		assert.NoError(t, err)
+		assert.Equal(t, png.Bytes(), data)
+	}
+	assert.Equal(t, http.StatusConflict, uploadImage(router, png.Bytes(), map[string]string{"task_id": "PU001"}).Code)
+
+	// 格式、大小和参数校验
+	assert.Equal(t, http.StatusUnsupportedMediaType, uploadImage(router, []byte("GIF89a not really"), nil).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, uploadImage(router, make([]byte, 11<<20), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, uploadImage(router, png.Bytes(), map[string]string{"wait": "600"}).Code)
+	assert.Equal(t, http.StatusBadRequest, uploadImage(router, png.Bytes(), map[string]string{"backend": "missing"}).Code)
+	assert.Equal(t, http.StatusBadRequest, uploadImage(router, png.Bytes(), map[string]string{"task_id": "../PU001"}).Code)
+	assert.Equal(t, http.StatusBadRequest, uploadImage(router, png.Bytes(), map[string]string{"task_id": strings.Repeat("P", 65)}).Code)
+
+	// 未指定task_id时同一时刻的上传生成不同的ID
+	var generated []string
+	for i := 0; i < 2; i++ {
+		w := uploadImage(router, png.Bytes(), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var accepted struct {
+			Data struct {
+				TaskID string `json:"task_id"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+		assert.True(t, strings.HasPrefix(accepted.Data.TaskID, "PU"))
+		generated = append(generated, accepted.Data.TaskID)
+	}
+	assert.NotEqual(t, generated[0], generated[1])
+
+	// 同步等待：后端返回错误时返回失败的结果，等待超时返回202。启动后先处理排队中的PU001
+	// 任务ID传给按文件预测的后端，避免后端自己按时间生成的ID互相冲突
+	for _, id := range append([]string{"PU001"}, generated...) {
+		ai.On("PredictWithFile", mock.Anything, mock.Anything, id).Return(&models.PredictionResult{
+			TaskID: id, PredictionStatus: models.StatusProcessing,
+		}, nil).Once()
+	}
+	ai.On("PredictWithFile", mock.Anything, mock.Anything, "PU002").Return(nil, errors.New("model not loaded")).Once()
+	ai.On("PredictWithFile", mock.Anything, mock.Anything, "PU003").Return(&models.PredictionResult{
+		TaskID: "LOCAL1", PredictionStatus: models.StatusProcessing,
+	}, nil).Once()
+	assert.NoError(t, queue.Start())
+	defer queue.Stop()
+
+	w = uploadImage(router, png.Bytes(), map[string]string{"task_id": "PU002", "wait": "5"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var failed struct {
+		Data struct {
+			Prediction struct {
+				TaskID string `json:"task_id"`
+				Status string `json:"status"`
+			} `json:"prediction"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &failed))
+	assert.Equal(t, "PU002", failed.Data.Prediction.TaskID)
+	assert.Equal(t, "failed", failed.Data.Prediction.Status)
+
+	w = uploadImage(router, png.Bytes(), map[string]string{"task_id": "PU003", "wait": "1"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), `"task_id":"LOCAL1"`)
+	job, err := queueDB.GetPredictionJob("PU003")
+	assert.NoError(t, err)
+	if assert.NotNil(t, job) {
+		assert.Equal(t, "LOCAL1", job.TaskID)
+		assert.Equal(t, models.StatusProcessing, job.Status)
+	}
 }
 
 // TestAICallback 测试AI回调接口
@@ -221,18 +572,20 @@ func TestAICallback(t *testing.T) {
 	db := new(MockDBService)
 	ai := new(MockAIService)
 	log := new(MockLogService)
-	router := setupTestRouter(db, ai, log)
+	router := setupTestRouter(t, db, ai, log)
 
-	// 准备测试数据
-	result := models.PredictionResult{
-		TaskID:           "TASK001",
-		PredictionStatus: models.StatusCompleted,
-		PredictionModel:  "test-model",
-		HasDefect:        true,
-		DefectType:       "stringing",
-		Confidence:       95.5,
+	// 准备测试数据（与接口文档中的回调格式一致）
+	callback := map[string]interface{}{
+		"task_id": "TASK001",
+		"status":  "success",
+		"result": map[string]interface{}{
+			"predict_model": "test-model",
+			"has_defect":    true,
+			"defect_type":   "stringing",
+			"confidence":    0.955,
+		},
 	}
-	jsonBody, _ := json.Marshal(result)
+	jsonBody, _ := json.Marshal(callback)
 
 	// 设置Mock期望
 	db.On("SavePredictionResult", mock.AnythingOfType("*models.PredictionResult")).Return(nil)
@@ -240,12 +593,23 @@ func TestAICallback(t *testing.T) {
 		ConfidenceThreshold: 90,
 		PauseOnThreshold:   true,
 	}, nil)
+	db.On("GetDefectPolicies").Return(models.DefaultDefectPolicies(), nil)
+	db.On("GetPredictionResult", "TASK001").Return(&models.PredictionResult{
+		TaskID:           "TASK001",
+		PredictionStatus: models.StatusPending,
+		Backend:          "test",
+	}, nil)
+
+	db.On("GetPredictionResult", "TASK404").Return(nil, nil)
+	db.On("GetPredictionResult", "TASK002").Return(&models.PredictionResult{
+		TaskID:           "TASK002",
+		PredictionStatus: models.StatusCompleted,
+		Backend:          "test",
+	}, nil)
 
 	// 发送请求
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/v1/ai/callback", bytes.NewBuffer(jsonBody))
-	req.Header.Set("Content-Type", "application/json")
-	router.ServeHTTP(w, req)
+	router.ServeHTTP(w, signedCallback(jsonBody, "nonce-0001"))
 
 	// 验证结果
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -253,6 +617,483 @@ func TestAICallback(t *testing.T) {
 	err := json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.NoError(t, err)
 	assert.Equal(t, 0, resp.Code)
+	db.AssertCalled(t, "SavePredictionResult", mock.MatchedBy(func(r *models.PredictionResult) bool {
+		return r.Backend == "test" && r.Confidence == 0.955
+	}))
+
+	send := func(req *http.Request) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 重放同一个nonce
+	assert.Equal(t, http.StatusUnauthorized, send(signedCallback(jsonBody, "nonce-0001")))
+
+	// 没有签名或请求体被篡改
+	unsigned, _ := http.NewRequest("POST", "/api/v1/ai/callback", bytes.NewBuffer(jsonBody))
+	unsigned.Header.Set("Content-Type", "application/json")
+	assert.Equal(t, http.StatusUnauthorized, send(unsigned))
+	tampered := signedCallback(jsonBody, "nonce-0002")
+	tampered.Body = io.NopCloser(bytes.NewBuffer(bytes.Replace(jsonBody, []byte("0.955"), []byte("0.1"), 1)))
+	assert.Equal(t, http.StatusUnauthorized, send(tampered))
+
+	// 不是本机创建的任务和已完成的任务
+	callback["task_id"] = "TASK404"
+	jsonBody, _ = json.Marshal(callback)
+	assert.Equal(t, http.StatusNotFound, send(signedCallback(jsonBody, "nonce-0003")))
+	callback["task_id"] = "TASK002"
+	jsonBody, _ = json.Marshal(callback)
+	assert.Equal(t, http.StatusConflict, send(signedCallback(jsonBody, "nonce-0004")))
+
+	// 超出0-1的置信度被拒绝
+	callback["task_id"] = "TASK001"
+	callback["result"].(map[string]interface{})["confidence"] = 95.5
+	jsonBody, _ = json.Marshal(callback)
+	assert.Equal(t, http.StatusBadRequest, send(signedCallback(jsonBody, "nonce-0005")))
+}
+
+// TestAICallbackFailed 测试后端回调失败状态时以失败结束任务，不保存为没有缺陷的结果
+func TestAICallbackFailed(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router, queue, queueDB := setupQueueRouter(t, db, ai, log, config.AuthConfig{}, nil)
+
+	_, err := queue.Submit(services.PredictionRequest{TaskID: "TASK003", ImageURL: "http://example.com/a.jpg"})
+	assert.NoError(t, err)
+	db.On("GetPredictionResult", "TASK003").Return(&models.PredictionResult{
+		TaskID:           "TASK003",
+		PredictionStatus: models.StatusProcessing,
+		Backend:          "test",
+	}, nil)
+
+	body, _ := json.Marshal(map[string]interface{}{"task_id": "TASK003", "status": "failed", "error": "CUDA out of memory"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, signedCallback(body, "nonce-0001"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.AssertNotCalled(t, "SavePredictionResult", mock.Anything)
+
+	job, err := queueDB.GetPredictionJob("TASK003")
+	assert.NoError(t, err)
+	if assert.NotNil(t, job) {
+		assert.Equal(t, models.StatusFailed, job.Status)
+		assert.Contains(t, job.Error, "failed")
+		assert.Contains(t, job.Error, "CUDA out of memory")
+	}
+	saved, err := queueDB.GetPredictionResult("TASK003")
+	assert.NoError(t, err)
+	if assert.NotNil(t, saved) {
+		assert.Equal(t, models.StatusFailed, saved.PredictionStatus)
+		assert.False(t, saved.HasDefect)
+	}
+}
+
+// TestUpdateDefectPolicies 测试更新缺陷处理策略
+func TestUpdateDefectPolicies(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router := setupTestRouter(t, db, ai, log)
+
+	body := map[string]interface{}{
+		"policies": []map[string]interface{}{
+			{"defect_type": "stringing", "enabled": true, "threshold": 80, "consecutive_hits": 3, "action": "notify"},
+			{"defect_type": "spaghetti", "enabled": true, "threshold": 60, "consecutive_hits": 2, "action": "pause"},
+		},
+	}
+	jsonBody, _ := json.Marshal(body)
+
+	db.On("SaveDefectPolicies", mock.MatchedBy(func(policies []models.DefectPolicy) bool {
+		return len(policies) == 2 && policies[1].Action == models.ActionPause
+	})).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/settings/defect-policies", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.AssertExpectations(t)
+
+	// 无效的动作
+	body["policies"] = []map[string]interface{}{
+		{"defect_type": "warping", "enabled": true, "threshold": 70, "consecutive_hits": 1, "action": "explode"},
+	}
+	jsonBody, _ = json.Marshal(body)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/settings/defect-policies", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestPolicyReplay 测试按候选阈值回放预测结果
+func TestPolicyReplay(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router := setupTestRouter(t, db, ai, log)
+
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	var results []models.PredictionResult
+	for i, confidence := range []models.Confidence{0.65, 0.72, 0.68} {
+		r := models.PredictionResult{
+			TaskID: "TASK" + strconv.Itoa(i), PredictionStatus: models.StatusCompleted, PredictionModel: "yolo",
+			HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: confidence,
+		}
+		r.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		results = append(results, r)
+	}
+
+	db.On("GetUserSettings").Return(&models.UserSettings{EnableAI: true, ConfidenceThreshold: 80}, nil)
+	db.On("GetDefectPolicies").Return(models.DefaultDefectPolicies(), nil)
+	db.On("ListCompletedPredictions", mock.Anything, mock.Anything).Return(results, nil)
+
+	body := map[string]interface{}{
+		"since":      start.Add(-time.Hour),
+		"until":      start.Add(time.Hour),
+		"candidates": []map[string]interface{}{{"name": "strict", "confidence_threshold": 70}},
+	}
+	jsonBody, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/reports/policy-replay", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Data struct {
+			Reports []services.ReplayReport `json:"reports"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Data.Reports, 2)
+	// 当前策略：炒面阈值60、连续2次，第2次时暂停
+	assert.Equal(t, "current", resp.Data.Reports[0].Name)
+	assert.Equal(t, 1, resp.Data.Reports[0].Pauses)
+	// 阈值70：只有0.72达到阈值，不满足连续命中
+	assert.Equal(t, "strict", resp.Data.Reports[1].Name)
+	assert.Equal(t, 0, resp.Data.Reports[1].Pauses)
+
+	// 无效的阈值
+	body["candidates"] = []map[string]interface{}{{"confidence_threshold": 120}}
+	jsonBody, _ = json.Marshal(body)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/reports/policy-replay", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestModelDisagreements 测试分歧列表和CSV导出
+func TestModelDisagreements(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router := setupTestRouter(t, db, ai, log)
+
+	disagreement := models.ModelDisagreement{
+		Kind: models.DisagreeVerdict,
+		Comparison: models.ModelComparison{
+			TaskID:    "PT001",
+			ImagePath: "/tmp/snapshot.jpg",
+			Primary:   models.ModelVerdict{Backend: "local", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.8},
+			Reference: models.ModelVerdict{Backend: "cloud", Confidence: 0.1},
+		},
+	}
+	db.On("CountModelComparisons", mock.Anything, mock.Anything).Return(int64(10), int64(9), nil)
+	db.On("ListModelDisagreements", mock.MatchedBy(func(f services.DisagreementFilter) bool {
+		return f.Kind == models.DisagreeVerdict
+	})).Return([]models.ModelDisagreement{disagreement}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/reports/disagreements?kind=verdict", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data struct {
+			Agreement     float64                    `json:"agreement"`
+			Disagreements []models.ModelDisagreement `json:"disagreements"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.InDelta(t, 0.9, resp.Data.Agreement, 1e-9)
+	assert.Len(t, resp.Data.Disagreements, 1)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/reports/disagreements/export?kind=verdict", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	assert.Contains(t, w.Body.String(), "PT001,verdict,/tmp/snapshot.jpg,local")
+
+	// 无效的分歧类型
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/reports/disagreements?kind=mood", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestExportDataset 测试导出训练数据集
+func TestExportDataset(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router := setupTestRouter(t, db, ai, log)
+
+	db.On("ListExportPredictions", mock.MatchedBy(func(f services.ExportFilter) bool {
+		return f.DefectType == models.DefectSpaghetti && f.LabelledOnly
+	})).Return([]models.PredictionResult{}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/dataset/export?format=coco&defect_type=spaghetti&labelled_only=true", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "dataset_coco_")
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.NoError(t, err)
+	assert.Len(t, zr.File, 1)
+
+	for _, query := range []string{"format=voc", "defect_type=mood", "since=yesterday"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/dataset/export?"+query, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	db.AssertExpectations(t)
+}
+
+// TestListPredictions 测试预测历史的筛选、单条查询和导出
+func TestListPredictions(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router := setupTestRouter(t, db, ai, log)
+
+	result := models.PredictionResult{
+		TaskID: "PT001", PredictionModel: "yolo", PredictionStatus: models.StatusCompleted,
+		HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.9, Backend: "local", Session: "PS1",
+	}
+	db.On("ListPredictions", mock.MatchedBy(func(q services.PredictionQuery) bool {
+		return q.HasDefect != nil && *q.HasDefect && q.Session == "PS1" && q.Sort == services.SortByConfidence &&
+			q.Status != nil && *q.Status == models.StatusCompleted
+	})).Return([]models.PredictionResult{result}, "next", nil)
+	db.On("GetPredictionResult", "PT001").Return(&result, nil)
+	db.On("GetPredictionResult", "PT404").Return(nil, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/predictions?has_defect=true&session=PS1&sort=confidence&status=completed", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data struct {
+			Predictions []map[string]interface{} `json:"predictions"`
+			NextCursor  string                   `json:"next_cursor"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "next", resp.Data.NextCursor)
+	assert.Len(t, resp.Data.Predictions, 1)
+	assert.Equal(t, "completed", resp.Data.Predictions[0]["status"])
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/predictions/export?has_defect=true&session=PS1&sort=confidence&status=completed", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	assert.Contains(t, w.Body.String(), "PT001,completed,PS1,local,yolo,true,spaghetti,0.9000")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/predictions/PT001", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"task_id":"PT001"`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/predictions/PT001/export?format=json", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "prediction_PT001.json")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/predictions/PT404", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	for _, query := range []string{"has_defect=maybe", "status=done", "order=up", "limit=1000", "cursor=@@", "defect_type=mood"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/predictions?"+query, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+// TestDefectStats 测试统计接口的分组和参数校验
+func TestDefectStats(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router := setupTestRouter(t, db, ai, log)
+
+	db.On("DefectStats", mock.MatchedBy(func(q services.StatsQuery) bool {
+		return q.GroupBy == services.GroupByNone
+	})).Return([]services.StatsGroup{{Key: "total", Predictions: 10, Defects: 2}}, nil).Once()
+	db.On("DefectStats", mock.MatchedBy(func(q services.StatsQuery) bool {
+		return q.GroupBy == services.GroupByWeek
+	})).Return([]services.StatsGroup{{Key: "2026-W41", Predictions: 10}}, nil).Once()
+
+	// 第二次请求使用缓存
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/reports/stats?group_by=week", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Data struct {
+				Total  services.StatsGroup   `json:"total"`
+				Groups []services.StatsGroup `json:"groups"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, int64(2), resp.Data.Total.Defects)
+		assert.Len(t, resp.Data.Groups, 1)
+	}
+	db.AssertExpectations(t)
+
+	for _, query := range []string{"group_by=month", "since=2026-10-19T00:00:00Z&until=2026-10-01T00:00:00Z"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/reports/stats?"+query, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+// TestEventStream 测试SSE和WebSocket推送订阅的事件
+func TestEventStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log := new(MockLogService)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	log.On("Error", mock.Anything, mock.Anything).Return()
+	auth, err := middleware.NewAuthenticator(config.AuthConfig{AllowedOrigins: []string{"http://Mainsail.local/"}}, nil, log)
+	assert.NoError(t, err)
+	bus := services.NewEventBus()
+	router := gin.New()
+	router.GET("/events", EventStream(bus))
+	router.GET("/events/ws", EventWebSocket(bus, auth.CheckOrigin, log))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?types=pause_issued")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// 其他站点的页面不能连接，同一主机其他端口的页面和配置允许的来源可以连接
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/events/ws"
+	_, rejected, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"http://evil.example"}})
+	assert.Error(t, err)
+	if assert.NotNil(t, rejected) {
+		assert.Equal(t, http.StatusForbidden, rejected.StatusCode)
+	}
+	for _, origin := range []string{"http://127.0.0.1", "http://mainsail.local"} {
+		allowed, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {origin}})
+		if assert.NoError(t, err, origin) {
+			allowed.Close()
+		}
+	}
+	assert.Eventually(t, func() bool { return bus.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// 等待两个订阅都建立后再发布
+	assert.Eventually(t, func() bool { return bus.Subscribers() == 2 }, time.Second, 10*time.Millisecond)
+	bus.Publish(services.EventPrinterState, gin.H{"state": "printing"})
+	bus.Publish(services.EventPauseIssued, gin.H{"task_id": "PT001"})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, "event: pause_issued", lines[1])
+	assert.Contains(t, lines[2], `"task_id":"PT001"`)
+
+	// WebSocket订阅全部事件
+	var event services.Event
+	assert.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, services.EventPrinterState, event.Type)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/events?types=coffee", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestPrinterCommands 测试暂停和恢复打印返回打印机的实际状态
+func TestPrinterCommands(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := new(MockDBService)
+	log := new(MockLogService)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	log.On("Error", mock.Anything, mock.Anything).Return()
+
+	state := "printing"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/printer/print/pause":
+			state = "paused"
+		case "/printer/print/resume":
+			state = "printing"
+		}
+		w.Write([]byte(`{"result":{"status":{"print_stats":{"state":"` + state + `"}}}}`))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverURL.Port())
+	logService, err := services.NewLogService("error", filepath.Join(t.TempDir(), "test.log"))
+	assert.NoError(t, err)
+	moonraker := services.NewMoonrakerClient(config.MoonrakerConfig{Host: serverURL.Hostname(), Port: port}, logService)
+
+	backends := services.NewBackendRegistry(services.NewHealthMonitor(log))
+	processor := services.NewPredictionProcessor(backends, db, moonraker, log)
+	auth, _ := middleware.NewAuthenticator(config.AuthConfig{}, nil, log)
+	router := SetupRouter(backends, processor, nil, nil, db, log, moonraker, nil, services.NewEventBus(), auth)
+
+	db.On("GetMachineInfo").Return(&models.MachineInfo{MachineSN: "SN001"}, nil)
+	db.On("SavePrinterAction", mock.MatchedBy(func(a *models.PrinterAction) bool {
+		return a.Actor == "alice" && a.Reason == "看到炒面"
+	})).Return(nil)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/api/v1/printer/pause", `{"machine_sn":"SN001","operator":"alice","reason":"看到炒面"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"state":"paused"`)
+	assert.Contains(t, w.Body.String(), `"confirmed":true`)
+
+	// 已暂停时不能再次暂停，状态检查失败也会记录
+	w = post("/api/v1/printer/pause", `{"machine_sn":"SN001","operator":"alice","reason":"看到炒面"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = post("/api/v1/printer/resume", `{"machine_sn":"SN002"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = post("/api/v1/printer/cancel", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	db.AssertNumberOfCalls(t, "SavePrinterAction", 2)
 }
 
 // TestValidationErrors 测试参数验证错误
@@ -260,7 +1101,7 @@ func TestValidationErrors(t *testing.T) {
 	db := new(MockDBService)
 	ai := new(MockAIService)
 	log := new(MockLogService)
-	router := setupTestRouter(db, ai, log)
+	router := setupTestRouter(t, db, ai, log)
 
 	testCases := []struct {
 		name     string
@@ -313,4 +1154,129 @@ func TestValidationErrors(t *testing.T) {
 			assert.Equal(t, tc.expected, w.Code)
 		})
 	}
-} 
\ No newline at end of file
+} 
+// TestFlagPredictionDisabled 测试未开启训练样本上传时标记返回参数错误
+func TestFlagPredictionDisabled(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router := setupTestRouter(t, db, ai, log)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/predictions/PT001/flag", bytes.NewBufferString(`{"note":"误报"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/dataset/status", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"enabled":false`)
+}
+
+// TestPredictionFeedback 测试误报反馈和与结果不符的反馈
+func TestPredictionFeedback(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	router := setupTestRouter(t, db, ai, log)
+
+	db.On("GetPredictionResult", "PT001").Return(&models.PredictionResult{
+		TaskID: "PT001", PredictionStatus: models.StatusCompleted, HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.8,
+	}, nil)
+	db.On("GetPredictionResult", "PT404").Return(nil, nil)
+	db.On("SavePredictionFeedback", "PT001", mock.MatchedBy(func(f *models.PredictionFeedback) bool {
+		return f.Verdict == models.FeedbackFalsePositive
+	})).Return(nil)
+
+	post := func(taskID, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/predictions/"+taskID+"/feedback", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post("PT001", `{"verdict":"false_positive"}`))
+	db.AssertCalled(t, "SavePredictionFeedback", "PT001", mock.Anything)
+
+	// 判定有缺陷的结果不能反馈为漏报
+	assert.Equal(t, http.StatusBadRequest, post("PT001", `{"verdict":"false_negative","defect_type":"blob"}`))
+	// 误报不能指定缺陷类型
+	assert.Equal(t, http.StatusBadRequest, post("PT001", `{"verdict":"false_positive","defect_type":"blob"}`))
+	assert.Equal(t, http.StatusBadRequest, post("PT001", `{"verdict":"maybe"}`))
+	assert.Equal(t, http.StatusNotFound, post("PT404", `{"verdict":"false_positive"}`))
+}
+
+// TestAPIAuth 测试API认证和按路由的权限
+func TestAPIAuth(t *testing.T) {
+	db := new(MockDBService)
+	ai := new(MockAIService)
+	log := new(MockLogService)
+	secret := []byte("test-jwt-secret")
+	router := setupAuthRouter(t, db, ai, log, config.AuthConfig{
+		Enabled: true,
+		APIKeys: []config.APIKeyConfig{
+			{Name: "dashboard", Key: "read-key-0123456789", Scope: "read"},
+		},
+		Exempt:       []string{"/api/v1/ai/health"},
+		LoopbackOnly: []string{"/api/v1/ai/callback"},
+	}, secret)
+	db.On("GetDefectPolicies").Return(models.DefaultDefectPolicies(), nil)
+
+	token := func(scope string, ttl time.Duration) string {
+		token, err := utils.GenerateAPIToken("phone", scope, string(secret), ttl)
+		assert.NoError(t, err)
+		return "Bearer " + token
+	}
+	request := func(method, path string, headers map[string]string, remoteAddr string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if remoteAddr != "" {
+			req.RemoteAddr = remoteAddr
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 豁免路由
+	assert.Equal(t, http.StatusOK, request("GET", "/api/v1/ai/health", nil, ""))
+
+	// 缺少或无效的凭证
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/v1/settings/defect-policies", nil, ""))
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/v1/settings/defect-policies",
+		map[string]string{"X-API-Key": "wrong-key-0123456789"}, ""))
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/v1/settings/defect-policies",
+		map[string]string{"Authorization": token("read", -time.Minute)}, ""))
+	forged, _ := utils.GenerateAPIToken("phone", "admin", "other-secret", time.Hour)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/v1/settings/defect-policies",
+		map[string]string{"Authorization": "Bearer " + forged}, ""))
+
+	// API Key和JWT
+	assert.Equal(t, http.StatusOK, request("GET", "/api/v1/settings/defect-policies",
+		map[string]string{"X-API-Key": "read-key-0123456789"}, ""))
+	assert.Equal(t, http.StatusOK, request("GET", "/api/v1/settings/defect-policies",
+		map[string]string{"Authorization": token("read", time.Hour)}, ""))
+
+	// 权限不足
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/v1/printer/pause",
+		map[string]string{"X-API-Key": "read-key-0123456789"}, ""))
+	assert.Equal(t, http.StatusForbidden, request("PUT", "/api/v1/settings/defect-policies",
+		map[string]string{"Authorization": token("control", time.Hour)}, ""))
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/v1/machine/register",
+		map[string]string{"Authorization": token("control", time.Hour)}, ""))
+	// admin包含control，请求体缺少machine_sn
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/api/v1/printer/pause",
+		map[string]string{"Authorization": token("admin", time.Hour)}, ""))
+
+	// 回调只允许本机访问，X-Forwarded-For不能绕过
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/v1/ai/callback", nil, "192.168.1.20:40000"))
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/v1/ai/callback",
+		map[string]string{"X-Forwarded-For": "127.0.0.1"}, "192.168.1.20:40000"))
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/api/v1/ai/callback", nil, "127.0.0.1:40000"))
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+	"mingda_ai_helper/utils"
+)
+
+// Scope API访问权限，高级权限包含低级权限
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"    // 查询状态、预测结果、报表
+	ScopeControl Scope = "control" // 暂停、恢复、取消打印，提交反馈
+	ScopeAdmin   Scope = "admin"   // 修改设置、设备注册、导出数据
+)
+
+var scopeLevels = map[Scope]int{ScopeRead: 1, ScopeControl: 2, ScopeAdmin: 3}
+
+// ParseScope 解析权限名称
+func ParseScope(name string) (Scope, error) {
+	scope := Scope(name)
+	if _, ok := scopeLevels[scope]; !ok {
+		return "", fmt.Errorf("未知的API权限: %s", name)
+	}
+	return scope, nil
+}
+
+// Includes 判断是否包含另一个权限
+func (s Scope) Includes(other Scope) bool {
+	return scopeLevels[s] >= scopeLevels[other] && scopeLevels[other] > 0
+}
+
+const (
+	// SubjectKey 认证通过后，使用者名称保存在gin上下文中的键
+	SubjectKey = "auth_subject"
+	// APIKeyHeader 静态API Key的请求头
+	APIKeyHeader = "X-API-Key"
+	// accessTokenQuery 浏览器的EventSource和WebSocket无法设置请求头，允许通过查询参数传递token
+	accessTokenQuery = "access_token"
+)
+
+type apiKey struct {
+	name  string
+	key   []byte
+	scope Scope
+}
+
+// Authenticator 校验JWT和静态API Key，并按路由要求的权限放行
+type Authenticator struct {
+	enabled      bool
+	secret       string
+	keys         []apiKey
+	exempt       map[string]bool
+	loopbackOnly map[string]bool
+	origins      map[string]bool
+	logService   services.LogInterface
+}
+
+// NewAuthenticator 创建认证中间件，secret为JWT签名密钥，未启用认证时可以为空
+func NewAuthenticator(cfg config.AuthConfig, secret []byte, logService services.LogInterface) (*Authenticator, error) {
+	a := &Authenticator{
+		enabled:      cfg.Enabled,
+		secret:       string(secret),
+		exempt:       make(map[string]bool),
+		loopbackOnly: make(map[string]bool),
+		origins:      make(map[string]bool),
+		logService:   logService,
+	}
+	if cfg.Enabled && len(secret) == 0 {
+		return nil, fmt.Errorf("启用API认证时JWT签名密钥不能为空")
+	}
+	for _, k := range cfg.APIKeys {
+		scope, err := ParseScope(k.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("API Key %s: %v", k.Name, err)
+		}
+		a.keys = append(a.keys, apiKey{name: k.Name, key: []byte(k.Key), scope: scope})
+	}
+	for _, path := range cfg.Exempt {
+		a.exempt[path] = true
+	}
+	for _, path := range cfg.LoopbackOnly {
+		a.loopbackOnly[path] = true
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		a.origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	return a, nil
+}
+
+// CheckOrigin 校验WebSocket握手的Origin，不论是否启用认证。
+// 没有Origin的非浏览器客户端、与本服务同一主机（端口可以不同）的页面和配置允许的来源可以连接
+func (a *Authenticator) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && u.Host != "" {
+		if a.origins[strings.ToLower(u.Scheme+"://"+u.Host)] {
+			return true
+		}
+		// Mainsail、Fluidd与本服务在同一主机的不同端口
+		host := (&url.URL{Host: r.Host}).Hostname()
+		if host != "" && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	a.logService.Info("拒绝跨域的WebSocket连接", zap.String("origin", origin), zap.String("host", r.Host))
+	return false
+}
+
+// Require 要求请求具备指定权限。豁免路由直接放行，仅本机路由只校验来源地址
+func (a *Authenticator) Require(scope Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.enabled || a.exempt[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if a.loopbackOnly[c.FullPath()] {
+			// 不使用ClientIP，X-Forwarded-For可以伪造
+			if ip := net.ParseIP(c.RemoteIP()); ip == nil || !ip.IsLoopback() {
+				a.logService.Info("拒绝非本机请求", zap.String("path", c.FullPath()), zap.String("remote_ip", c.RemoteIP()))
+				response.Forbidden(c, "该接口只允许本机访问")
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		subject, granted, ok := a.authenticate(c)
+		if !ok {
+			response.UnauthorizedError(c)
+			c.Abort()
+			return
+		}
+		if !granted.Includes(scope) {
+			a.logService.Info("API权限不足",
+				zap.String("path", c.FullPath()),
+				zap.String("subject", subject),
+				zap.String("scope", string(granted)),
+				zap.String("required", string(scope)))
+			response.Forbidden(c, fmt.Sprintf("需要%s权限", scope))
+			c.Abort()
+			return
+		}
+		c.Set(SubjectKey, subject)
+		c.Next()
+	}
+}
+
+// authenticate 依次尝试X-API-Key和Bearer token
+func (a *Authenticator) authenticate(c *gin.Context) (string, Scope, bool) {
+	if key := c.GetHeader(APIKeyHeader); key != "" {
+		for _, k := range a.keys {
+			if subtle.ConstantTimeCompare(k.key, []byte(key)) == 1 {
+				return k.name, k.scope, true
+			}
+		}
+		return "", "", false
+	}
+
+	token := c.Query(accessTokenQuery)
+	if header := c.GetHeader("Authorization"); header != "" {
+		const prefix = "Bearer "
+		if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+			return "", "", false
+		}
+		token = strings.TrimSpace(header[len(prefix):])
+	}
+	if token == "" {
+		return "", "", false
+	}
+	claims, err := utils.ValidateToken(token, a.secret)
+	if err != nil {
+		return "", "", false
+	}
+	scope, err := ParseScope(claims.Scope)
+	if err != nil {
+		return "", "", false
+	}
+	return claims.Subject, scope, true
+}
+
+// Subject 返回认证通过的使用者名称，未启用认证或豁免路由时为空
+func Subject(c *gin.Context) string {
+	return c.GetString(SubjectKey)
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// predictionQuery 解析预测历史的查询参数：since、until(RFC3339)、has_defect、defect_type、
+// backend、status(pending|processing|completed|failed|timeout)、session、sort(created_at|confidence)、order(asc|desc)、cursor、limit
+func predictionQuery(c *gin.Context) (services.PredictionQuery, error) {
+	q := services.PredictionQuery{
+		DefectType: models.DefectType(c.Query("defect_type")),
+		Backend:    c.Query("backend"),
+		Session:    c.Query("session"),
+		Sort:       services.PredictionSort(c.Query("sort")),
+		Cursor:     c.Query("cursor"),
+		Limit:      services.DefaultPredictionLimit,
+	}
+	var err error
+	if q.Since, err = queryTime(c, "since"); err != nil {
+		return q, err
+	}
+	if q.Until, err = queryTime(c, "until"); err != nil {
+		return q, err
+	}
+	if v := c.Query("has_defect"); v != "" {
+		hasDefect, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("无效的has_defect: %s", v)
+		}
+		q.HasDefect = &hasDefect
+	}
+	if v := c.Query("status"); v != "" {
+		status, err := models.ParsePredictionStatus(v)
+		if err != nil {
+			return q, err
+		}
+		q.Status = &status
+	}
+	switch order := c.DefaultQuery("order", "desc"); order {
+	case "asc":
+		q.Asc = true
+	case "desc":
+	default:
+		return q, fmt.Errorf("order必须为asc或desc")
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > services.MaxPredictionLimit {
+			return q, fmt.Errorf("limit必须在1-%d之间", services.MaxPredictionLimit)
+		}
+	}
+	return q, q.Validate()
+}
+
+// exportFormat 解析导出格式，默认为csv
+func exportFormat(c *gin.Context) (string, error) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		return "", fmt.Errorf("format必须为csv或json")
+	}
+	return format, nil
+}
+
+// ListPredictions 分页获取预测历史，next_cursor为空时没有下一页
+func ListPredictions(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, err := predictionQuery(c)
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+
+		results, next, err := db.ListPredictions(q)
+		if err != nil {
+			log.Error("获取预测历史失败", zap.Error(err))
+			response.ServerError(c, "获取预测历史失败")
+			return
+		}
+		if results == nil {
+			results = []models.PredictionResult{}
+		}
+
+		response.Success(c, gin.H{"predictions": results, "next_cursor": next})
+	}
+}
+
+// ExportPredictions 按与列表相同的条件导出预测历史，最多MaxPredictionExport条
+func ExportPredictions(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, err := exportFormat(c)
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		q, err := predictionQuery(c)
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		q.Limit = services.MaxPredictionExport
+
+		results, _, err := db.ListPredictions(q)
+		if err != nil {
+			log.Error("获取预测历史失败", zap.Error(err))
+			response.ServerError(c, "获取预测历史失败")
+			return
+		}
+
+		writePredictions(c, log, format, "predictions_"+time.Now().Format("20060102"), results)
+	}
+}
+
+// GetPrediction 获取单个预测任务的结果
+func GetPrediction(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, ok := findPrediction(c, db, log)
+		if !ok {
+			return
+		}
+		response.Success(c, gin.H{"prediction": result})
+	}
+}
+
+// ExportPrediction 导出单个预测任务的结果
+func ExportPrediction(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, err := exportFormat(c)
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		result, ok := findPrediction(c, db, log)
+		if !ok {
+			return
+		}
+		writePredictions(c, log, format, "prediction_"+result.TaskID, []models.PredictionResult{*result})
+	}
+}
+
+// PredictionDeliveries 预测结果推送到callback_url的状态及每次请求
+func PredictionDeliveries(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, ok := findPrediction(c, db, log)
+		if !ok {
+			return
+		}
+		deliveries, err := db.ListResultDeliveries(result.TaskID)
+		if err != nil {
+			log.Error("获取结果推送记录失败", zap.Error(err))
+			response.ServerError(c, "获取结果推送记录失败")
+			return
+		}
+		if deliveries == nil {
+			deliveries = []models.ResultDelivery{}
+		}
+		response.Success(c, gin.H{"task_id": result.TaskID, "deliveries": deliveries})
+	}
+}
+
+// findPrediction 按路径参数task_id获取预测结果，失败时已写入响应
+func findPrediction(c *gin.Context, db services.DBInterface, log services.LogInterface) (*models.PredictionResult, bool) {
+	result, err := db.GetPredictionResult(c.Param("task_id"))
+	if err != nil {
+		log.Error("获取预测结果失败", zap.Error(err))
+		response.ServerError(c, "获取预测结果失败")
+		return nil, false
+	}
+	if result == nil {
+		response.NotFound(c, "预测任务不存在")
+		return nil, false
+	}
+	return result, true
+}
+
+// writePredictions 以附件形式输出预测结果，置信度为0-1
+func writePredictions(c *gin.Context, log services.LogInterface, format, name string, results []models.PredictionResult) {
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	if format == "json" {
+		if results == nil {
+			results = []models.PredictionResult{}
+		}
+		c.JSON(http.StatusOK, results)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{
+		"time", "task_id", "status", "session", "backend", "prediction_model",
+		"has_defect", "defect_type", "confidence", "action", "shadow",
+		"feedback_verdict", "feedback_defect_type", "image_path",
+	})
+	for _, r := range results {
+		w.Write([]string{
+			r.CreatedAt.Format(time.RFC3339), r.TaskID, r.PredictionStatus.String(), r.Session, r.Backend, r.PredictionModel,
+			strconv.FormatBool(r.HasDefect), string(r.DefectType), strconv.FormatFloat(float64(r.Confidence), 'f', 4, 64),
+			string(r.Action), strconv.FormatBool(r.Shadow),
+			string(r.Feedback.Verdict), string(r.Feedback.DefectType), r.ImagePath,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Error("导出预测历史失败", zap.Error(err))
+	}
+}
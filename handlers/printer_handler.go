@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"mingda_ai_helper/handlers/middleware"
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// PrinterCommand 暂停、恢复或取消打印。machine_sn必须与本机一致；操作者记录为token的使用者，
+// 未启用认证时记录operator，operator为空时记录客户端IP。
+// 打印机进入预期状态后返回200，指令已发送但状态未变化时返回202，响应中的state为实际的print_stats.state
+func PrinterCommand(command models.PrinterCommand, control *services.PrinterControl, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			MachineSN string `json:"machine_sn" binding:"required"`
+			Operator  string `json:"operator"`
+			Reason    string `json:"reason"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			response.ValidationError(c, "无效的请求参数")
+			return
+		}
+		actor := middleware.Subject(c)
+		if actor == "" {
+			actor = req.Operator
+		}
+		if actor == "" {
+			actor = c.ClientIP()
+		}
+
+		action, err := control.Execute(req.MachineSN, command, actor, req.Reason)
+		switch {
+		case errors.Is(err, services.ErrMachineNotRegistered), errors.Is(err, services.ErrMachineMismatch):
+			response.Error(c, http.StatusForbidden, err.Error())
+			return
+		case errors.Is(err, services.ErrPrinterState):
+			response.Error(c, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			log.Error("执行打印控制指令失败", zap.String("command", string(command)), zap.Error(err))
+			response.Error(c, http.StatusBadGateway, "执行打印控制指令失败: "+err.Error())
+			return
+		}
+
+		data := gin.H{"command": command, "state": action.StateAfter, "confirmed": action.Confirmed}
+		if !action.Confirmed {
+			response.Accepted(c, "指令已发送，打印机状态尚未变化", data)
+			return
+		}
+		response.Success(c, data)
+	}
+}
+
+// PrinterActions 最近的打印控制记录，包括策略自动执行的暂停和取消
+func PrinterActions(db services.DBInterface, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 50
+		if v := c.Query("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > 500 {
+				response.ValidationError(c, "limit必须在1-500之间")
+				return
+			}
+		}
+
+		actions, err := db.ListPrinterActions(limit)
+		if err != nil {
+			log.Error("获取打印控制记录失败", zap.Error(err))
+			response.ServerError(c, "获取打印控制记录失败")
+			return
+		}
+		if actions == nil {
+			actions = []models.PrinterAction{}
+		}
+		response.Success(c, gin.H{"actions": actions})
+	}
+}
@@ -80,7 +80,13 @@ func (h *SettingsHandler) HandleAICallback(c *gin.Context) {
 		return
 	}
 
-	if callback.HasDefect && callback.Confidence >= float64(settings.ConfidenceThreshold)/100.0 {
+	confidence := models.Confidence(callback.Confidence)
+	if !confidence.Valid() {
+		response.ValidationError(c, "置信度必须在0-1之间")
+		return
+	}
+
+	if callback.HasDefect && confidence >= models.ConfidenceFromPercent(float64(settings.ConfidenceThreshold)) {
 		fmt.Printf("检测到打印缺陷，置信度: %.2f，阈值: %d\n", 
 			callback.Confidence, settings.ConfidenceThreshold)
 
@@ -102,8 +108,8 @@ func (h *SettingsHandler) HandleAICallback(c *gin.Context) {
 		TaskID:           callback.TaskID,
 		PredictionStatus: models.StatusCompleted,
 		HasDefect:        callback.HasDefect,
-		DefectType:       callback.DefectType,
-		Confidence:       callback.Confidence,
+		DefectType:       models.DefectType(callback.DefectType),
+		Confidence:       confidence,
 	}
 
 	if err := h.dbService.SavePredictionResult(result); err != nil {
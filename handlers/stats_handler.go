@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// statsCacheTTL 统计结果的缓存时间
+const statsCacheTTL = 5 * time.Minute
+
+// DefectStats 缺陷统计和趋势。查询参数：since、until(RFC3339)、group_by(day|week|printer|file)。
+// 时间范围默认为截至今天的最近30天，until取次日零点使同一天内的请求可以使用缓存
+func DefectStats(stats *services.StatsCache, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := services.StatsQuery{GroupBy: services.StatsGroupBy(c.Query("group_by"))}
+		if !q.GroupBy.Valid() {
+			response.ValidationError(c, "group_by必须为day、week、printer或file")
+			return
+		}
+		var err error
+		if q.Until, err = queryTime(c, "until"); err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		if q.Until.IsZero() {
+			y, m, d := time.Now().Date()
+			q.Until = time.Date(y, m, d+1, 0, 0, 0, 0, time.Local)
+		}
+		if q.Since, err = queryTime(c, "since"); err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		if q.Since.IsZero() {
+			q.Since = q.Until.AddDate(0, 0, -30)
+		}
+		if !q.Since.Before(q.Until) {
+			response.ValidationError(c, "since必须早于until")
+			return
+		}
+
+		total := q
+		total.GroupBy = services.GroupByNone
+		totals, err := stats.Get(total)
+		if err != nil {
+			log.Error("获取统计数据失败", zap.Error(err))
+			response.ServerError(c, "获取统计数据失败")
+			return
+		}
+		data := gin.H{"since": q.Since, "until": q.Until, "total": services.StatsGroup{Key: "total"}}
+		if len(totals) > 0 {
+			data["total"] = totals[0]
+		}
+
+		if q.GroupBy != services.GroupByNone {
+			groups, err := stats.Get(q)
+			if err != nil {
+				log.Error("获取统计数据失败", zap.Error(err))
+				response.ServerError(c, "获取统计数据失败")
+				return
+			}
+			data["group_by"], data["groups"] = q.GroupBy, groups
+		}
+		response.Success(c, data)
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/pkg/response"
+	"mingda_ai_helper/services"
+	"mingda_ai_helper/utils"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxUploadSize 上传图片的大小上限
+	maxUploadSize = 10 << 20
+	// maxUploadWait 同步等待结果的时间上限
+	maxUploadWait = 60 * time.Second
+)
+
+// uploadTaskIDPattern 客户端指定的task_id只允许字母、数字、下划线和短横线，最长64个字符
+var uploadTaskIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// uploadFormats 允许上传的图片格式及保存时的扩展名
+var uploadFormats = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+}
+
+// PredictUpload 上传图片进行预测。multipart表单字段：image（JPEG或PNG，必填）、task_id、backend、callback_url（可选）、
+// wait（同步等待结果的秒数，0-60，默认不等待）。图片保存到快照目录后加入预测队列，与接口请求的优先级相同
+func PredictUpload(jobs *services.PredictionQueue, snapshots *services.SnapshotStore, log services.LogInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 表单的其他字段很小，留出1MB余量
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize+1<<20)
+		header, err := c.FormFile("image")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				response.Error(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("图片不能超过%dMB", maxUploadSize>>20))
+				return
+			}
+			response.ValidationError(c, "缺少图片文件image")
+			return
+		}
+		if header.Size > maxUploadSize {
+			response.Error(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("图片不能超过%dMB", maxUploadSize>>20))
+			return
+		}
+
+		wait, err := uploadWait(c.PostForm("wait"))
+		if err != nil {
+			response.ValidationError(c, err.Error())
+			return
+		}
+		callbackURL := c.PostForm("callback_url")
+		if callbackURL != "" && !strings.HasPrefix(callbackURL, "http://") && !strings.HasPrefix(callbackURL, "https://") {
+			response.ValidationError(c, "callback_url必须为http或https地址")
+			return
+		}
+		taskID := c.PostForm("task_id")
+		if taskID != "" && !uploadTaskIDPattern.MatchString(taskID) {
+			response.ValidationError(c, "task_id只能包含字母、数字、下划线和短横线，且不超过64个字符")
+			return
+		}
+		if taskID == "" {
+			// 同一时刻的并发上传不能生成相同的ID
+			suffix, err := utils.GenerateRandomString(8)
+			if err != nil {
+				log.Error("生成任务ID失败", zap.Error(err))
+				response.ServerError(c, "生成任务ID失败")
+				return
+			}
+			taskID = "PU" + time.Now().Format("20060102150405") + "_" + suffix
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			response.ValidationError(c, "读取图片失败")
+			return
+		}
+		defer file.Close()
+
+		// 按文件内容判断格式，不信任文件名和Content-Type
+		_, format, err := image.DecodeConfig(file)
+		ext, ok := uploadFormats[format]
+		if err != nil || !ok {
+			response.Error(c, http.StatusUnsupportedMediaType, "只支持JPEG和PNG图片")
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			response.ServerError(c, "读取图片失败")
+			return
+		}
+		imagePath, err := snapshots.Save("upload", ext, file)
+		if err != nil {
+			log.Error("保存上传的图片失败", zap.Error(err))
+			response.ServerError(c, "保存图片失败")
+			return
+		}
+
+		job, err := jobs.Submit(services.PredictionRequest{
+			TaskID:      taskID,
+			Backend:     c.PostForm("backend"),
+			ImagePath:   imagePath,
+			Priority:    models.PriorityManual,
+			CallbackURL: callbackURL,
+		})
+		if err != nil {
+			os.Remove(imagePath)
+		}
+		switch {
+		case errors.Is(err, services.ErrTaskExists):
+			response.Error(c, http.StatusConflict, err.Error())
+			return
+		case errors.Is(err, services.ErrUnknownBackend):
+			response.ValidationError(c, err.Error())
+			return
+		case err != nil:
+			log.Error("保存预测任务失败", zap.Error(err))
+			response.ServerError(c, "保存预测任务失败")
+			return
+		}
+		log.Info("已提交上传图片的预测任务",
+			zap.String("task_id", job.TaskID),
+			zap.String("backend", job.Backend),
+			zap.String("image_path", imagePath))
+
+		if wait == 0 {
+			response.Success(c, gin.H{"task_id": job.TaskID, "backend": job.Backend})
+			return
+		}
+
+		// 客户端断开时停止等待，任务继续在队列中处理
+		ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+		defer cancel()
+		result, err := jobs.Wait(ctx, job.TaskID)
+		if err != nil {
+			log.Error("获取预测结果失败", zap.String("task_id", job.TaskID), zap.Error(err))
+			response.ServerError(c, "获取预测结果失败")
+			return
+		}
+		if result == nil || !result.PredictionStatus.Finished() {
+			// 后端使用自己生成的任务ID时，返回新的ID供查询
+			taskID, status := job.TaskID, models.StatusPending
+			if result != nil {
+				taskID, status = result.TaskID, result.PredictionStatus
+			}
+			response.Accepted(c, "预测尚未完成", gin.H{"task_id": taskID, "backend": job.Backend, "status": status.String()})
+			return
+		}
+		response.Success(c, gin.H{"task_id": result.TaskID, "backend": job.Backend, "prediction": result})
+	}
+}
+
+// uploadWait 解析wait参数（秒）
+func uploadWait(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxUploadWait {
+		return 0, fmt.Errorf("wait必须为0-%d的整数", int(maxUploadWait.Seconds()))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
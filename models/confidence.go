@@ -0,0 +1,50 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+)
+
+// Confidence 置信度，取值0-1。
+// 数据库中按百分比保存（confidence列有0-100的检查约束），读写时自动换算
+type Confidence float64
+
+// ConfidenceFromPercent 由百分比（如用户设置中的阈值）得到置信度
+func ConfidenceFromPercent(percent float64) Confidence {
+	return Confidence(percent / 100)
+}
+
+// Valid 是否在0-1之间
+func (c Confidence) Valid() bool {
+	f := float64(c)
+	return !math.IsNaN(f) && f >= 0 && f <= 1
+}
+
+// Percent 换算为百分比
+func (c Confidence) Percent() float64 {
+	return float64(c) * 100
+}
+
+// Value 实现driver.Valuer，以百分比写入数据库
+func (c Confidence) Value() (driver.Value, error) {
+	if !c.Valid() {
+		return nil, fmt.Errorf("置信度超出0-1范围: %v", float64(c))
+	}
+	return c.Percent(), nil
+}
+
+// Scan 实现sql.Scanner，把数据库中的百分比换算为0-1
+func (c *Confidence) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*c = 0
+	case float64:
+		*c = ConfidenceFromPercent(v)
+	case int64:
+		*c = ConfidenceFromPercent(float64(v))
+	default:
+		return fmt.Errorf("无法解析置信度: %T", value)
+	}
+	return nil
+}
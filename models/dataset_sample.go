@@ -0,0 +1,84 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SampleReason 帧被选为训练样本的原因
+type SampleReason string
+
+const (
+	SampleUncertain SampleReason = "uncertain" // 置信度落在不确定区间
+	SampleFlagged   SampleReason = "flagged"   // 用户标记
+)
+
+// SampleStatus 训练样本的上传状态
+type SampleStatus string
+
+const (
+	SamplePending  SampleStatus = "pending"
+	SampleUploaded SampleStatus = "uploaded"
+	SampleFailed   SampleStatus = "failed"  // 多次上传失败后放弃
+	SampleSkipped  SampleStatus = "skipped" // 快照文件已不存在
+)
+
+// SampleMetadata 随样本上传的打印信息
+type SampleMetadata struct {
+	MachineModel    string     `json:"machine_model,omitempty"`
+	Filename        string     `json:"filename,omitempty"`
+	Progress        float64    `json:"progress"`       // 0-1
+	PrintDuration   float64    `json:"print_duration"` // 秒
+	Backend         string     `json:"backend"`
+	PredictionModel string     `json:"prediction_model"`
+	HasDefect       bool       `json:"has_defect"`
+	DefectType      DefectType `json:"defect_type,omitempty"`
+	Confidence      Confidence `json:"confidence"`
+	CapturedAt      time.Time  `json:"captured_at"`
+}
+
+// Value 实现driver.Valuer，以JSON文本保存
+func (m SampleMetadata) Value() (driver.Value, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现sql.Scanner
+func (m *SampleMetadata) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = SampleMetadata{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	}
+	return fmt.Errorf("无法解析样本元数据: %T", value)
+}
+
+// DatasetSample 等待上传的训练样本，每个预测任务最多一条
+type DatasetSample struct {
+	gorm.Model
+	TaskID     string         `gorm:"column:task_id;type:varchar(64);uniqueIndex;not null" json:"task_id"`
+	ImagePath  string         `gorm:"column:image_path;type:varchar(255);not null" json:"image_path"`
+	Reason     SampleReason   `gorm:"column:reason;type:varchar(16);not null;index" json:"reason"`
+	Note       string         `gorm:"column:note;type:varchar(255)" json:"note"`
+	Metadata   SampleMetadata `gorm:"column:metadata;type:text" json:"metadata"`
+	Status     SampleStatus   `gorm:"column:status;type:varchar(16);not null;index" json:"status"`
+	Attempts   int            `gorm:"column:attempts;not null;default:0" json:"attempts"`
+	LastError  string         `gorm:"column:last_error;type:varchar(255)" json:"last_error"`
+	UploadedAt *time.Time     `gorm:"column:uploaded_at;index" json:"uploaded_at"`
+}
+
+// TableName 指定表名
+func (DatasetSample) TableName() string {
+	return "dataset_samples"
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// PolicyAction 检测到缺陷后执行的动作
+type PolicyAction string
+
+const (
+	ActionNone   PolicyAction = ""       // 不处理
+	ActionNotify PolicyAction = "notify" // 在打印机控制台提醒
+	ActionPause  PolicyAction = "pause"  // 暂停打印
+	ActionCancel PolicyAction = "cancel" // 取消打印
+)
+
+// Valid 是否为可配置的动作
+func (a PolicyAction) Valid() bool {
+	switch a {
+	case ActionNotify, ActionPause, ActionCancel:
+		return true
+	}
+	return false
+}
+
+// Severity 动作的严重程度，用于降级和比较
+func (a PolicyAction) Severity() int {
+	switch a {
+	case ActionNotify:
+		return 1
+	case ActionPause:
+		return 2
+	case ActionCancel:
+		return 3
+	}
+	return 0
+}
+
+// DefectPolicy 单个缺陷类型的处理策略
+type DefectPolicy struct {
+	gorm.Model
+	DefectType      DefectType   `gorm:"column:defect_type;type:varchar(64);uniqueIndex;not null" json:"defect_type"`
+	Enabled         bool         `gorm:"column:enabled;not null" json:"enabled"`
+	Threshold       int          `gorm:"column:threshold;not null;check:threshold BETWEEN 0 AND 100" json:"threshold"`
+	ConsecutiveHits int          `gorm:"column:consecutive_hits;not null;default:1" json:"consecutive_hits"`
+	Action          PolicyAction `gorm:"column:action;type:varchar(16);not null" json:"action"`
+}
+
+// TableName 指定表名
+func (DefectPolicy) TableName() string {
+	return "defect_policies"
+}
+
+// Validate 校验策略参数
+func (p *DefectPolicy) Validate() error {
+	if p.DefectType == "" {
+		return fmt.Errorf("缺陷类型不能为空")
+	}
+	if !p.DefectType.Valid() {
+		return fmt.Errorf("未知的缺陷类型: %s", p.DefectType)
+	}
+	if p.Threshold < 0 || p.Threshold > 100 {
+		return fmt.Errorf("%s的阈值必须在0-100之间", p.DefectType)
+	}
+	if p.ConsecutiveHits < 1 || p.ConsecutiveHits > 20 {
+		return fmt.Errorf("%s的连续命中次数必须在1-20之间", p.DefectType)
+	}
+	if !p.Action.Valid() {
+		return fmt.Errorf("%s的动作无效: %s", p.DefectType, p.Action)
+	}
+	return nil
+}
+
+// DefaultDefectPolicies 默认策略：外观类缺陷只提醒，会毁掉打印的缺陷暂停
+func DefaultDefectPolicies() []DefectPolicy {
+	return []DefectPolicy{
+		{DefectType: DefectSpaghetti, Enabled: true, Threshold: 60, ConsecutiveHits: 2, Action: ActionPause},
+		{DefectType: DefectDetachment, Enabled: true, Threshold: 60, ConsecutiveHits: 2, Action: ActionPause},
+		{DefectType: DefectLayerShift, Enabled: true, Threshold: 70, ConsecutiveHits: 2, Action: ActionPause},
+		{DefectType: DefectWarping, Enabled: true, Threshold: 75, ConsecutiveHits: 3, Action: ActionNotify},
+		{DefectType: DefectBlob, Enabled: true, Threshold: 80, ConsecutiveHits: 2, Action: ActionNotify},
+		{DefectType: DefectStringing, Enabled: true, Threshold: 80, ConsecutiveHits: 3, Action: ActionNotify},
+	}
+}
@@ -0,0 +1,66 @@
+package models
+
+import "strings"
+
+// DefectType 统一的缺陷类型。各后端返回的类别先映射为这里的类型再保存和判定
+type DefectType string
+
+const (
+	DefectSpaghetti  DefectType = "spaghetti"   // 炒面（打印失败、材料乱堆）
+	DefectStringing  DefectType = "stringing"   // 拉丝
+	DefectWarping    DefectType = "warping"     // 翘边
+	DefectLayerShift DefectType = "layer_shift" // 错层
+	DefectBlob       DefectType = "blob"        // 结块、喷嘴包料
+	DefectDetachment DefectType = "detachment"  // 模型脱落
+	DefectUnknown    DefectType = "unknown"     // 无法映射的类别
+)
+
+// DefectTypes 所有缺陷类型
+func DefectTypes() []DefectType {
+	return []DefectType{
+		DefectSpaghetti, DefectStringing, DefectWarping,
+		DefectLayerShift, DefectBlob, DefectDetachment, DefectUnknown,
+	}
+}
+
+// Valid 是否为统一的缺陷类型
+func (t DefectType) Valid() bool {
+	for _, v := range DefectTypes() {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultDefectAliases 常见模型使用的类别名称，后端配置的class_aliases优先
+var defaultDefectAliases = map[string]DefectType{
+	"string":            DefectStringing,
+	"strings":           DefectStringing,
+	"failure":           DefectSpaghetti, // Obico
+	"spaghetti_failure": DefectSpaghetti,
+	"warp":              DefectWarping,
+	"layershift":        DefectLayerShift,
+	"shift":             DefectLayerShift,
+	"blobs":             DefectBlob,
+	"zit":               DefectBlob,
+	"detached":          DefectDetachment,
+	"adhesion":          DefectDetachment,
+	"bed_adhesion":      DefectDetachment,
+}
+
+// NormalizeDefectLabel 统一类别名称的写法：小写，空格和连字符换为下划线
+func NormalizeDefectLabel(label string) string {
+	label = strings.ToLower(strings.TrimSpace(label))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(label)
+}
+
+// LookupDefectType 按统一类型名称和内置别名查找缺陷类型
+func LookupDefectType(label string) (DefectType, bool) {
+	label = NormalizeDefectLabel(label)
+	if t := DefectType(label); t.Valid() {
+		return t, true
+	}
+	t, ok := defaultDefectAliases[label]
+	return t, ok
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// FeedbackVerdict 用户对预测结果的判定
+type FeedbackVerdict string
+
+const (
+	FeedbackTruePositive  FeedbackVerdict = "true_positive"  // 确实有缺陷
+	FeedbackFalsePositive FeedbackVerdict = "false_positive" // 误报，不是缺陷
+	FeedbackTrueNegative  FeedbackVerdict = "true_negative"  // 确实没有缺陷
+	FeedbackFalseNegative FeedbackVerdict = "false_negative" // 漏报
+)
+
+// Valid 是否为有效的判定
+func (v FeedbackVerdict) Valid() bool {
+	switch v {
+	case FeedbackTruePositive, FeedbackFalsePositive, FeedbackTrueNegative, FeedbackFalseNegative:
+		return true
+	}
+	return false
+}
+
+// Defective 用户认为实际是否有缺陷
+func (v FeedbackVerdict) Defective() bool {
+	return v == FeedbackTruePositive || v == FeedbackFalseNegative
+}
+
+// PredictionFeedback 用户反馈，与预测结果保存在同一行
+type PredictionFeedback struct {
+	Verdict    FeedbackVerdict `gorm:"column:verdict;type:varchar(16);index" json:"verdict"`
+	DefectType DefectType      `gorm:"column:defect_type;type:varchar(64)" json:"defect_type,omitempty"` // 实际的缺陷类型
+	Note       string          `gorm:"column:note;type:varchar(255)" json:"note,omitempty"`
+	At         *time.Time      `gorm:"column:at" json:"at,omitempty"`
+}
+
+// Validate 校验反馈与预测结果是否一致：误报和确认有缺陷只能用于判定有缺陷的结果，反之亦然
+func (f *PredictionFeedback) Validate(result *PredictionResult) error {
+	if !f.Verdict.Valid() {
+		return fmt.Errorf("无效的反馈: %s", f.Verdict)
+	}
+	if result.PredictionStatus != StatusCompleted {
+		return fmt.Errorf("预测任务%s尚未完成", result.TaskID)
+	}
+	positive := f.Verdict == FeedbackTruePositive || f.Verdict == FeedbackFalsePositive
+	if positive != result.HasDefect {
+		return fmt.Errorf("反馈%s与预测结果不符（has_defect=%v）", f.Verdict, result.HasDefect)
+	}
+	if f.DefectType != "" {
+		if !f.Verdict.Defective() {
+			return fmt.Errorf("%s不能指定缺陷类型", f.Verdict)
+		}
+		if !f.DefectType.Valid() {
+			return fmt.Errorf("未知的缺陷类型: %s", f.DefectType)
+		}
+	}
+	if len(f.Note) > 255 {
+		return fmt.Errorf("备注不能超过255个字符")
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ type MachineInfo struct {
 	MachineSN    string `gorm:"column:machine_sn;type:varchar(64);uniqueIndex;not null"`
 	MachineModel string `gorm:"column:machine_model;type:varchar(64);not null"`
 	AuthToken    string `gorm:"column:auth_token;type:varchar(255);not null"`
+	DeviceSecret string `gorm:"column:device_secret;type:varchar(255)"`
 }
 
 // TableName 指定表名
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"math"
+
+	"gorm.io/gorm"
+)
+
+// DisagreementKind 两个模型分歧的类型
+type DisagreementKind string
+
+const (
+	DisagreeVerdict    DisagreementKind = "verdict"     // 一个判定有缺陷，另一个没有
+	DisagreeDefectType DisagreementKind = "defect_type" // 都有缺陷但类型不同
+	DisagreeConfidence DisagreementKind = "confidence"  // 类型相同但置信度相差过大
+)
+
+// ModelVerdict 单个后端对快照的判定
+type ModelVerdict struct {
+	Backend         string     `gorm:"column:backend;type:varchar(64)" json:"backend"`
+	PredictionModel string     `gorm:"column:prediction_model;type:varchar(64)" json:"prediction_model"`
+	HasDefect       bool       `gorm:"column:has_defect" json:"has_defect"`
+	DefectType      DefectType `gorm:"column:defect_type;type:varchar(64)" json:"defect_type"`
+	Confidence      Confidence `gorm:"column:confidence" json:"confidence"`
+}
+
+// VerdictOf 提取预测结果中的判定
+func VerdictOf(result *PredictionResult) ModelVerdict {
+	return ModelVerdict{
+		Backend:         result.Backend,
+		PredictionModel: result.PredictionModel,
+		HasDefect:       result.HasDefect,
+		DefectType:      result.DefectType,
+		Confidence:      result.Confidence,
+	}
+}
+
+// ModelComparison 同一张快照上两个后端的判定
+type ModelComparison struct {
+	gorm.Model
+	TaskID    string       `gorm:"column:task_id;type:varchar(64);index" json:"task_id"`
+	ImagePath string       `gorm:"column:image_path;type:varchar(255)" json:"image_path"`
+	Primary   ModelVerdict `gorm:"embedded;embeddedPrefix:primary_" json:"primary"`
+	Reference ModelVerdict `gorm:"embedded;embeddedPrefix:reference_" json:"reference"`
+	Agree     bool         `gorm:"column:agree;not null;index" json:"agree"`
+}
+
+// TableName 指定表名
+func (ModelComparison) TableName() string {
+	return "model_comparisons"
+}
+
+// Compare 比较两个判定，一致时返回空字符串
+func (c *ModelComparison) Compare(confidenceGap float64) DisagreementKind {
+	p, r := c.Primary, c.Reference
+	switch {
+	case p.HasDefect != r.HasDefect:
+		return DisagreeVerdict
+	case !p.HasDefect:
+		return ""
+	case p.DefectType != r.DefectType:
+		return DisagreeDefectType
+	case math.Abs(float64(p.Confidence-r.Confidence)) > confidenceGap:
+		return DisagreeConfidence
+	}
+	return ""
+}
+
+// ModelDisagreement 模型分歧记录，作为难例供模型训练使用
+type ModelDisagreement struct {
+	gorm.Model
+	ComparisonID uint             `gorm:"column:comparison_id;not null;uniqueIndex" json:"comparison_id"`
+	Comparison   ModelComparison  `gorm:"foreignKey:ComparisonID" json:"comparison"`
+	Kind         DisagreementKind `gorm:"column:kind;type:varchar(16);not null;index" json:"kind"`
+}
+
+// TableName 指定表名
+func (ModelDisagreement) TableName() string {
+	return "model_disagreements"
+}
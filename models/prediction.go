@@ -1,6 +1,10 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
 	"gorm.io/gorm"
 )
 
@@ -11,17 +15,114 @@ const (
 	StatusPending PredictionStatus = iota
 	StatusProcessing
 	StatusCompleted
+	StatusFailed  // 后端返回错误或结果无效
+	StatusTimeout // 请求超时或未在等待时间内收到回调
 )
 
+var predictionStatusNames = []string{"pending", "processing", "completed", "failed", "timeout"}
+
+// String 状态名称
+func (s PredictionStatus) String() string {
+	if s < 0 || int(s) >= len(predictionStatusNames) {
+		return fmt.Sprintf("PredictionStatus(%d)", int(s))
+	}
+	return predictionStatusNames[s]
+}
+
+// Finished 是否已有最终结果
+func (s PredictionStatus) Finished() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusTimeout
+}
+
+// MarshalText JSON中以名称表示状态
+func (s PredictionStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ParsePredictionStatus 按名称解析预测状态
+func ParsePredictionStatus(name string) (PredictionStatus, error) {
+	for i, n := range predictionStatusNames {
+		if n == name {
+			return PredictionStatus(i), nil
+		}
+	}
+	return 0, fmt.Errorf("未知的预测状态: %s", name)
+}
+
 // PredictionResult 预测结果模型
 type PredictionResult struct {
 	gorm.Model
-	TaskID           string          `gorm:"column:task_id;type:varchar(64);uniqueIndex;not null"`
-	PredictionStatus PredictionStatus `gorm:"column:prediction_status;not null;check:prediction_status IN (0, 1, 2)"`
-	PredictionModel  string          `gorm:"column:prediction_model;type:varchar(64);not null"`
-	HasDefect        bool            `gorm:"column:has_defect;not null"`
-	DefectType       string          `gorm:"column:defect_type;type:varchar(64)"`
-	Confidence       float64         `gorm:"column:confidence;check:confidence BETWEEN 0 AND 100"`
+	TaskID           string             `gorm:"column:task_id;type:varchar(64);uniqueIndex;not null" json:"task_id"`
+	PredictionStatus PredictionStatus   `gorm:"column:prediction_status;not null;check:prediction_status IN (0, 1, 2, 3, 4)" json:"status"`
+	PredictionModel  string             `gorm:"column:prediction_model;type:varchar(64);not null" json:"prediction_model"`
+	HasDefect        bool               `gorm:"column:has_defect;not null" json:"has_defect"`
+	DefectType       DefectType         `gorm:"column:defect_type;type:varchar(64)" json:"defect_type,omitempty"`
+	Confidence       Confidence         `gorm:"column:confidence;check:confidence BETWEEN 0 AND 100" json:"confidence"`
+	Detections       Detections         `gorm:"column:detections;type:text" json:"detections,omitempty"`
+	Backend          string             `gorm:"column:backend;type:varchar(64)" json:"backend,omitempty"`        // 产生结果的AI后端
+	Action           PolicyAction       `gorm:"column:action;type:varchar(16)" json:"action,omitempty"`          // 策略判定的动作
+	Shadow           bool               `gorm:"column:shadow;not null;default:false" json:"shadow"`              // 影子模式下动作只记录未执行
+	ImagePath        string             `gorm:"column:image_path;type:varchar(255)" json:"image_path,omitempty"` // 本地快照路径
+	Session          string             `gorm:"column:session;type:varchar(32);index" json:"session,omitempty"`  // 所属的打印任务
+	LatencyMs        int64              `gorm:"column:latency_ms;not null;default:0" json:"latency_ms"`          // 从发出请求到得到结果的耗时
+	Feedback         PredictionFeedback `gorm:"embedded;embeddedPrefix:feedback_" json:"feedback"`               // 用户反馈
+}
+
+// BeforeSave 保存前校验置信度，后端返回的异常值不会写入数据库
+func (r *PredictionResult) BeforeSave(tx *gorm.DB) error {
+	if !r.Confidence.Valid() {
+		return fmt.Errorf("任务%s的置信度超出0-1范围: %v", r.TaskID, float64(r.Confidence))
+	}
+	for _, d := range r.Detections {
+		if !d.Confidence.Valid() {
+			return fmt.Errorf("任务%s的检测框置信度超出0-1范围: %v", r.TaskID, float64(d.Confidence))
+		}
+	}
+	return nil
+}
+
+// Detection 单个检测框
+type Detection struct {
+	Label      string     `json:"label"`               // 统一的缺陷类型
+	RawLabel   string     `json:"raw_label,omitempty"` // 后端返回的原始类别，与Label不同时保留
+	Confidence Confidence `json:"confidence"`
+	BBox       []float64  `json:"bbox"` // [x1, y1, x2, y2]，像素坐标
+}
+
+// Detections 检测框列表，以JSON文本保存
+type Detections []Detection
+
+// Value 实现driver.Valuer
+func (d Detections) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现sql.Scanner
+func (d *Detections) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("无法解析检测框: %T", value)
+	}
+	if len(data) == 0 {
+		*d = nil
+		return nil
+	}
+	return json.Unmarshal(data, d)
 }
 
 // TableName 指定表名
@@ -46,4 +147,4 @@ func SavePredictionResult(db *gorm.DB, result *PredictionResult) error {
 // UpdatePredictionStatus 更新预测状态
 func UpdatePredictionStatus(db *gorm.DB, taskID string, status PredictionStatus) error {
 	return db.Model(&PredictionResult{}).Where("task_id = ?", taskID).Update("prediction_status", status).Error
-} 
\ No newline at end of file
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 预测任务的优先级，数值大的先处理
+const (
+	PriorityPeriodic = 0  // 监控定时检测
+	PriorityManual   = 10 // 接口请求或上传的图片
+)
+
+// PredictionJob 预测任务队列，状态与预测结果的prediction_status一致
+type PredictionJob struct {
+	gorm.Model
+	TaskID    string           `gorm:"column:task_id;type:varchar(64);uniqueIndex;not null" json:"task_id"`
+	// SubmittedID 提交时的任务ID，后端使用自己生成的ID时TaskID会被替换
+	SubmittedID string `gorm:"column:submitted_id;type:varchar(64);index" json:"submitted_id,omitempty"`
+	Backend   string           `gorm:"column:backend;type:varchar(64);not null;index" json:"backend"`
+	Status    PredictionStatus `gorm:"column:status;not null;index;check:status IN (0, 1, 2, 3, 4)" json:"status"`
+	Priority  int              `gorm:"column:priority;not null;default:0" json:"priority"`
+	ImageURL  string           `gorm:"column:image_url;type:varchar(512)" json:"image_url,omitempty"`
+	ImagePath string           `gorm:"column:image_path;type:varchar(255)" json:"image_path,omitempty"`
+	Session   string           `gorm:"column:session;type:varchar(32)" json:"session,omitempty"`
+	Attempts  int              `gorm:"column:attempts;not null;default:0" json:"attempts"` // 发送给后端的次数，重启后重新发送会增加
+	Error     string           `gorm:"column:error;type:varchar(255)" json:"error,omitempty"`
+	StartedAt *time.Time       `gorm:"column:started_at" json:"started_at,omitempty"`
+	// DispatchedAt 异步后端已受理请求，等待回调；为空时重启后重新发送
+	DispatchedAt *time.Time `gorm:"column:dispatched_at" json:"dispatched_at,omitempty"`
+	Deadline     *time.Time `gorm:"column:deadline;index" json:"deadline,omitempty"` // 等待回调的截止时间
+	FinishedAt   *time.Time `gorm:"column:finished_at" json:"finished_at,omitempty"`
+}
+
+// TableName 指定表名
+func (PredictionJob) TableName() string {
+	return "prediction_jobs"
+}
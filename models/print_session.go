@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PrintSessionState 打印任务的状态，与Moonraker的print_stats.state一致
+type PrintSessionState string
+
+const (
+	SessionPrinting  PrintSessionState = "printing"
+	SessionComplete  PrintSessionState = "complete"
+	SessionCancelled PrintSessionState = "cancelled"
+	SessionError     PrintSessionState = "error"
+)
+
+// Finished 是否为结束状态
+func (s PrintSessionState) Finished() bool {
+	return s == SessionComplete || s == SessionCancelled || s == SessionError
+}
+
+// PrintSession 一次打印任务，预测结果通过session关联
+type PrintSession struct {
+	gorm.Model
+	Session   string            `gorm:"column:session;type:varchar(32);uniqueIndex;not null" json:"session"`
+	Printer   string            `gorm:"column:printer;type:varchar(64);index" json:"printer"` // 机器序列号
+	Filename  string            `gorm:"column:filename;type:varchar(255);index" json:"filename"`
+	State     PrintSessionState `gorm:"column:state;type:varchar(16);not null" json:"state"`
+	StartedAt time.Time         `gorm:"column:started_at;index" json:"started_at"`
+	EndedAt   *time.Time        `gorm:"column:ended_at" json:"ended_at,omitempty"`
+}
+
+// TableName 指定表名
+func (PrintSession) TableName() string {
+	return "print_sessions"
+}
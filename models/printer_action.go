@@ -0,0 +1,65 @@
+package models
+
+import "gorm.io/gorm"
+
+// PrinterCommand 打印控制指令，与Moonraker的/printer/print/{command}一致
+type PrinterCommand string
+
+const (
+	CommandPause  PrinterCommand = "pause"
+	CommandResume PrinterCommand = "resume"
+	CommandCancel PrinterCommand = "cancel"
+)
+
+// AllowedFrom 打印机处于state(print_stats.state)时能否执行该指令
+func (c PrinterCommand) AllowedFrom(state string) bool {
+	switch c {
+	case CommandPause:
+		return state == "printing"
+	case CommandResume:
+		return state == "paused"
+	case CommandCancel:
+		return state == "printing" || state == "paused"
+	}
+	return false
+}
+
+// Reached 指令执行后打印机是否已进入预期状态
+func (c PrinterCommand) Reached(state string) bool {
+	switch c {
+	case CommandPause:
+		return state == "paused"
+	case CommandResume:
+		return state == "printing"
+	case CommandCancel:
+		return state == "cancelled" || state == "standby"
+	}
+	return false
+}
+
+// ActionSource 打印控制指令的来源
+type ActionSource string
+
+const (
+	SourceAPI    ActionSource = "api"    // 用户通过接口操作
+	SourcePolicy ActionSource = "policy" // 缺陷处理策略自动执行
+)
+
+// PrinterAction 一次打印控制操作的记录
+type PrinterAction struct {
+	gorm.Model
+	Command     PrinterCommand `gorm:"column:command;type:varchar(16);not null" json:"command"`
+	Source      ActionSource   `gorm:"column:source;type:varchar(16);not null;index" json:"source"`
+	Actor       string         `gorm:"column:actor;type:varchar(64)" json:"actor"` // 接口请求的operator或客户端IP，策略动作为AI后端
+	Reason      string         `gorm:"column:reason;type:varchar(255)" json:"reason,omitempty"`
+	TaskID      string         `gorm:"column:task_id;type:varchar(64)" json:"task_id,omitempty"` // 策略动作对应的预测任务
+	StateBefore string         `gorm:"column:state_before;type:varchar(16)" json:"state_before"`
+	StateAfter  string         `gorm:"column:state_after;type:varchar(16)" json:"state_after,omitempty"` // 为空时未检查
+	Confirmed   bool           `gorm:"column:confirmed;not null;default:false" json:"confirmed"`         // 打印机已进入预期状态
+	Error       string         `gorm:"column:error;type:varchar(255)" json:"error,omitempty"`
+}
+
+// TableName 指定表名
+func (PrinterAction) TableName() string {
+	return "printer_actions"
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeliveryStatus 预测结果推送状态
+type DeliveryStatus string
+
+const (
+	DeliveryWaiting   DeliveryStatus = "waiting"   // 预测尚未完成
+	DeliveryPending   DeliveryStatus = "pending"   // 等待发送或重试
+	DeliveryDelivered DeliveryStatus = "delivered" // 对方返回2xx
+	DeliveryFailed    DeliveryStatus = "failed"    // 重试次数用完或对方拒绝
+)
+
+// ResultDelivery 把预测结果推送到/predict请求中的callback_url
+type ResultDelivery struct {
+	gorm.Model
+	TaskID         string            `gorm:"column:task_id;type:varchar(64);not null;index" json:"task_id"`
+	URL            string            `gorm:"column:url;type:varchar(512);not null" json:"url"`
+	Status         DeliveryStatus    `gorm:"column:status;type:varchar(16);not null;index" json:"status"`
+	Attempts       int               `gorm:"column:attempts;not null;default:0" json:"attempts"`
+	NextAttemptAt  *time.Time        `gorm:"column:next_attempt_at;index" json:"next_attempt_at,omitempty"`
+	LastStatusCode int               `gorm:"column:last_status_code" json:"last_status_code,omitempty"`
+	LastError      string            `gorm:"column:last_error;type:varchar(255)" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time        `gorm:"column:delivered_at" json:"delivered_at,omitempty"`
+	History        []DeliveryAttempt `gorm:"foreignKey:DeliveryID" json:"history"`
+}
+
+// TableName 指定表名
+func (ResultDelivery) TableName() string {
+	return "result_deliveries"
+}
+
+// DeliveryAttempt 一次推送请求
+type DeliveryAttempt struct {
+	ID         uint      `gorm:"primarykey" json:"-"`
+	DeliveryID uint      `gorm:"column:delivery_id;not null;index" json:"-"`
+	Attempt    int       `gorm:"column:attempt;not null" json:"attempt"`
+	StatusCode int       `gorm:"column:status_code" json:"status_code,omitempty"` // 0表示请求未得到响应
+	Error      string    `gorm:"column:error;type:varchar(255)" json:"error,omitempty"`
+	DurationMs int64     `gorm:"column:duration_ms;not null;default:0" json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TableName 指定表名
+func (DeliveryAttempt) TableName() string {
+	return "delivery_attempts"
+}
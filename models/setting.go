@@ -11,6 +11,10 @@ type UserSettings struct {
 	EnableCloudAI        bool `gorm:"column:enable_cloud_ai;not null" json:"enable_cloud_ai"`
 	ConfidenceThreshold  int  `gorm:"column:confidence_threshold;not null;check:confidence_threshold BETWEEN 0 AND 100" json:"confidence_threshold"`
 	PauseOnThreshold    bool `gorm:"column:pause_on_threshold;not null" json:"pause_on_threshold"`
+	// ShadowMode 影子模式：照常预测和保存结果，策略动作只记录不执行，用于上线自动暂停前评估阈值
+	ShadowMode bool `gorm:"column:shadow_mode;not null;default:false" json:"shadow_mode"`
+	// ShareTrainingData 允许上传快照作为训练样本，默认关闭
+	ShareTrainingData bool `gorm:"column:share_training_data;not null;default:false" json:"share_training_data"`
 }
 
 // TableName 指定表名
@@ -42,4 +42,22 @@ func ServerError(c *gin.Context, message string) {
 // UnauthorizedError 返回未授权错误
 func UnauthorizedError(c *gin.Context) {
 	Error(c, http.StatusUnauthorized, "unauthorized")
-} 
\ No newline at end of file
+} 
+// NotFound 返回资源不存在错误
+func NotFound(c *gin.Context, message string) {
+	Error(c, http.StatusNotFound, message)
+}
+
+// Accepted 请求已受理但结果尚未确认
+func Accepted(c *gin.Context, message string, data interface{}) {
+	c.JSON(http.StatusAccepted, Response{
+		Code:    http.StatusAccepted,
+		Message: message,
+		Data:    data,
+	})
+}
+
+// Forbidden 已认证但权限不足
+func Forbidden(c *gin.Context, message string) {
+	Error(c, http.StatusForbidden, message)
+}
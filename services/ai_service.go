@@ -10,15 +10,26 @@ import (
 	"io"
 	"mime/multipart"
 	"mingda_ai_helper/models"
+	"mingda_ai_helper/utils"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type AIService interface {
 	Predict(ctx context.Context, imageURL string, taskID string) (*models.PredictionResult, error)
-	PredictWithFile(ctx context.Context, imagePath string) (*models.PredictionResult, error)
+	// PredictWithFile 按本地文件预测，taskID为空时由服务生成
+	PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error)
+}
+
+// fileTaskID 返回调用方指定的任务ID，未指定时按当前时间生成
+func fileTaskID(taskID string) string {
+	if taskID != "" {
+		return taskID
+	}
+	return fmt.Sprintf("PT%s", time.Now().Format("20060102150405"))
 }
 
 // PredictRequest AI预测请求结构体
@@ -51,12 +62,16 @@ type LocalAIService struct {
 	callbackURL string
 	httpClient  *http.Client
 	dbService   *DBService
+	// 可选的认证请求头
+	authHeader string
+	authValue  string
 }
 
 type CloudAIService struct {
-	baseURL    string
-	dbService  *DBService
-	httpClient *http.Client
+	baseURL     string
+	dbService   *DBService
+	httpClient  *http.Client
+	credentials *CredentialManager
 }
 
 func NewLocalAIService(localURL, callbackURL string, dbService *DBService) *LocalAIService {
@@ -80,6 +95,20 @@ func NewCloudAIService(cloudURL string, dbService *DBService) *CloudAIService {
 	}
 }
 
+// SetCredentialManager 设置凭证管理器，云端请求的token都从这里获取
+func (s *CloudAIService) SetCredentialManager(credentials *CredentialManager) {
+	s.credentials = credentials
+}
+
+// do 发送请求，并用响应的Date头校正时钟偏差
+func (s *CloudAIService) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.httpClient.Do(req)
+	if err == nil && s.credentials != nil {
+		s.credentials.ObserveResponse(resp)
+	}
+	return resp, err
+}
+
 func (s *LocalAIService) Predict(ctx context.Context, imageURL string, taskID string) (*models.PredictionResult, error) {
 	// 创建预测请求
 	reqBody := PredictRequest{
@@ -100,6 +129,9 @@ func (s *LocalAIService) Predict(ctx context.Context, imageURL string, taskID st
 		return nil, fmt.Errorf("create request failed: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if s.authHeader != "" {
+		req.Header.Set(s.authHeader, s.authValue)
+	}
 
 	// 打印请求信息（调试用）
 	fmt.Printf("\n请求URL: %s\n", req.URL.String())
@@ -153,6 +185,17 @@ func (s *LocalAIService) Predict(ctx context.Context, imageURL string, taskID st
 		PredictionModel:  aiResp.PredictModel,
 		HasDefect:        aiResp.HasDefect,
 	}
+	// 结果的置信度取最高的检测框，刻度由后端的confidence_scale决定
+	for _, d := range aiResp.Detections {
+		result.Detections = append(result.Detections, models.Detection{
+			Label:      d.Class,
+			Confidence: models.Confidence(d.Confidence),
+			BBox:       d.Bbox,
+		})
+		if models.Confidence(d.Confidence) > result.Confidence {
+			result.Confidence = models.Confidence(d.Confidence)
+		}
+	}
 
 	// // 保存预测结果到数据库
 	// if err := s.dbService.SavePredictionResult(result); err != nil {
@@ -162,14 +205,14 @@ func (s *LocalAIService) Predict(ctx context.Context, imageURL string, taskID st
 	return result, nil
 }
 
-func (s *LocalAIService) PredictWithFile(ctx context.Context, imagePath string) (*models.PredictionResult, error) {
+func (s *LocalAIService) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
 	// 检查文件是否存在
 	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
 		return nil, fmt.Errorf("image file not found: %s", imagePath)
 	}
 
-	// 生成任务ID
-	taskID := fmt.Sprintf("PT%s", time.Now().Format("20060102150405"))
+	// 未指定时生成任务ID
+	taskID = fileTaskID(taskID)
 
 	// 创建预测请求
 	reqBody := PredictRequest{
@@ -190,6 +233,9 @@ func (s *LocalAIService) PredictWithFile(ctx context.Context, imagePath string)
 		return nil, fmt.Errorf("create request failed: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if s.authHeader != "" {
+		req.Header.Set(s.authHeader, s.authValue)
+	}
 
 	// 发送请求
 	resp, err := s.httpClient.Do(req)
@@ -223,156 +269,17 @@ func (s *CloudAIService) Predict(ctx context.Context, imageURL string, taskID st
 	return nil, fmt.Errorf("not implemented for URL-based prediction")
 }
 
-func (s *CloudAIService) PredictWithFile(ctx context.Context, imagePath string) (*models.PredictionResult, error) {
-	// 获取机器信息和认证令牌
-	machineInfo, err := s.dbService.GetMachineInfo()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get machine info: %v", err)
-	}
-	if machineInfo == nil {
-		return nil, fmt.Errorf("machine info not found")
-	}
-
-	fmt.Printf("当前使用的Token: %s\n", machineInfo.AuthToken)
-
+func (s *CloudAIService) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
 	// 生成任务ID
 	if imagePath == "" {
 		return nil, fmt.Errorf("image path is required")
 	}
 
-	taskID := fmt.Sprintf("PT%s", time.Now().Format("20060102150405"))
-
-	// 检查文件是否存在
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("image file not found: %s", imagePath)
-	}
-
-	// 定义发送请求的函数
-	sendRequest := func(token string) (*http.Response, error) {
-		// 打开文件
-		file, err := os.Open(imagePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open image file: %v", err)
-		}
-		defer file.Close()
-
-		// 准备multipart表单
-		body := &bytes.Buffer{}
-		writer := multipart.NewWriter(body)
-
-		// 添加文件
-		part, err := writer.CreateFormFile("file", filepath.Base(imagePath))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create form file: %v", err)
-		}
-		if _, err = io.Copy(part, file); err != nil {
-			return nil, fmt.Errorf("failed to copy file content: %v", err)
-		}
-
-		// 添加task_id
-		if err = writer.WriteField("task_id", taskID); err != nil {
-			return nil, fmt.Errorf("failed to add task_id field: %v", err)
-		}
+	taskID = fileTaskID(taskID)
 
-		if err = writer.Close(); err != nil {
-			return nil, fmt.Errorf("failed to close writer: %v", err)
-		}
-
-		// 创建上传请求
-		req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/device/print/image", body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %v", err)
-		}
-
-		req.Header.Set("Content-Type", writer.FormDataContentType())
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		// 打印请求信息
-		fmt.Printf("\n请求URL: %s\n", req.URL.String())
-		fmt.Printf("请求方法: %s\n", req.Method)
-		fmt.Printf("Content-Type: %s\n", req.Header.Get("Content-Type"))
-		fmt.Printf("Authorization: Bearer %s...\n", token[:30])
-		fmt.Printf("TaskID: %s\n", taskID)
-		fmt.Printf("图片文件: %s\n\n", imagePath)
-
-		return s.httpClient.Do(req)
-	}
-
-	// 首次尝试发送请求
-	resp, err := sendRequest(machineInfo.AuthToken)
+	resp, respBody, err := s.postMultipart(ctx, s.baseURL+"/device/print/image", imagePath, map[string]string{"task_id": taskID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// 读取响应内容
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	// 打印响应信息
-	fmt.Printf("响应状态码: %d\n", resp.StatusCode)
-	fmt.Printf("响应内容: %s\n\n", string(respBody))
-
-	// 如果是401错误，尝试刷新token并重试
-	if resp.StatusCode == http.StatusUnauthorized {
-		var errorResp struct {
-			Code    int    `json:"code"`
-			Message string `json:"message"`
-		}
-		if err := json.Unmarshal(respBody, &errorResp); err != nil {
-			return nil, fmt.Errorf("failed to parse error response: %v", err)
-		}
-
-		// 如果是token过期错误
-		if errorResp.Code == 1003 {
-			fmt.Println("Token已过期，正在刷新...")
-			fmt.Printf("过期的Token: %s\n", machineInfo.AuthToken)
-			
-			// 刷新token
-			newToken, err := s.RefreshToken(ctx, machineInfo.AuthToken)
-			if err != nil {
-				return nil, fmt.Errorf("failed to refresh token: %v", err)
-			}
-			fmt.Printf("获取到新Token: %s\n", newToken)
-
-			// 更新数据库中的token
-			if err := s.dbService.SaveMachineInfo(&models.MachineInfo{
-				MachineSN:    machineInfo.MachineSN,
-				MachineModel: machineInfo.MachineModel,
-				AuthToken:    newToken,
-			}); err != nil {
-				return nil, fmt.Errorf("failed to update token in database: %v", err)
-			}
-			fmt.Println("新Token已保存到数据库")
-
-			// 验证数据库更新
-			updatedInfo, err := s.dbService.GetMachineInfo()
-			if err != nil {
-				return nil, fmt.Errorf("failed to verify token update: %v", err)
-			}
-			fmt.Printf("数据库中的Token: %s\n", updatedInfo.AuthToken)
-
-			fmt.Println("Token刷新成功，重试请求...")
-
-			// 使用新token重试请求
-			resp, err = sendRequest(newToken)
-			if err != nil {
-				return nil, fmt.Errorf("failed to retry request: %v", err)
-			}
-			defer resp.Body.Close()
-
-			// 读取重试响应
-			respBody, err = io.ReadAll(resp.Body)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read retry response body: %v", err)
-			}
-
-			// 打印重试响应信息
-			fmt.Printf("重试响应状态码: %d\n", resp.StatusCode)
-			fmt.Printf("重试响应内容: %s\n\n", string(respBody))
-		}
+		return nil, err
 	}
 
 	// 检查最终响应状态码
@@ -405,6 +312,12 @@ func (s *CloudAIService) PredictWithFile(ctx context.Context, imagePath string)
 	// 休眠3秒
 	time.Sleep(3 * time.Second)
 
+	// 上传时可能刷新过token，重新获取
+	token, err := s.credentials.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth token: %v", err)
+	}
+
 	// 创建查询请求
 	queryURL := fmt.Sprintf("%s/device/print/images?task_id=%s", s.baseURL, taskID)
 	queryReq, err := http.NewRequestWithContext(ctx, "GET", queryURL, nil)
@@ -412,17 +325,17 @@ func (s *CloudAIService) PredictWithFile(ctx context.Context, imagePath string)
 		return nil, fmt.Errorf("failed to create query request: %v", err)
 	}
 
-	queryReq.Header.Set("Authorization", "Bearer "+machineInfo.AuthToken)
+	queryReq.Header.Set("Authorization", "Bearer "+token)
 
 	// 打印查询请求信息
 	fmt.Printf("\n发送查询请求:\n")
 	fmt.Printf("请求URL: %s\n", queryReq.URL.String())
 	fmt.Printf("请求方法: %s\n", queryReq.Method)
-	fmt.Printf("Authorization: Bearer %s\n", machineInfo.AuthToken)
+	fmt.Printf("Authorization: Bearer %s\n", utils.MaskSecret(token))
 	fmt.Printf("TaskID: %s\n\n", taskID)
 
 	// 发送查询请求
-	queryResp, err := s.httpClient.Do(queryReq)
+	queryResp, err := s.do(queryReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send query request: %v", err)
 	}
@@ -479,7 +392,7 @@ func (s *CloudAIService) PredictWithFile(ctx context.Context, imagePath string)
 		time.Sleep(3 * time.Second)
 		
 		// 重新发送查询请求
-		queryResp, err = s.httpClient.Do(queryReq)
+		queryResp, err = s.do(queryReq)
 		if err != nil {
 			return nil, fmt.Errorf("failed to retry query request: %v", err)
 		}
@@ -501,77 +414,166 @@ func (s *CloudAIService) PredictWithFile(ctx context.Context, imagePath string)
 		}
 	}
 
-	// 构造回调请求体
-	callbackBody := struct {
-		TaskID string `json:"task_id"`
-		Status string `json:"status"`
-		Result struct {
-			PredictModel string  `json:"predict_model"`
-			HasDefect    bool    `json:"has_defect"`
-			DefectType   string  `json:"defect_type"`
-			Confidence   float64 `json:"confidence"`
-		} `json:"result"`
-	}{
-		TaskID: taskID,
-		Status: "2", // 完成状态
-		Result: struct {
-			PredictModel string  `json:"predict_model"`
-			HasDefect    bool    `json:"has_defect"`
-			DefectType   string  `json:"defect_type"`
-			Confidence   float64 `json:"confidence"`
-		}{
-			PredictModel: queryResult.Data.Result.PredictModel,
-			HasDefect:    queryResult.Data.Result.HasDefect,
-			DefectType:   queryResult.Data.Result.DefectType,
-			Confidence:   queryResult.Data.Result.Confidence,
-		},
+	// 直接返回结果，由调用方统一保存并执行缺陷处理策略
+	return &models.PredictionResult{
+		TaskID:           taskID,
+		PredictionStatus: models.StatusCompleted,
+		PredictionModel:  queryResult.Data.Result.PredictModel,
+		HasDefect:        queryResult.Data.Result.HasDefect,
+		DefectType:       models.DefectType(queryResult.Data.Result.DefectType),
+		Confidence:       models.Confidence(queryResult.Data.Result.Confidence),
+	}, nil
+}
+
+// postMultipart 以multipart表单上传图片（file字段）和附加字段，使用设备token认证，
+// token过期(1003)时刷新后重试一次
+func (s *CloudAIService) postMultipart(ctx context.Context, url, imagePath string, fields map[string]string) (*http.Response, []byte, error) {
+	if s.credentials == nil {
+		return nil, nil, fmt.Errorf("credential manager not configured")
 	}
 
-	// 将回调请求体转换为JSON
-	callbackJSON, err := json.Marshal(callbackBody)
+	// 从凭证管理器获取认证令牌
+	token, err := s.credentials.Token(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal callback body: %v", err)
+		return nil, nil, fmt.Errorf("failed to get auth token: %v", err)
+	}
+
+	// 检查文件是否存在
+	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("image file not found: %s", imagePath)
 	}
 
-	// 打印回调请求体
-	fmt.Printf("\n回调请求体:\n%s\n\n", string(callbackJSON))
+	// 定义发送请求的函数
+	sendRequest := func(token string) (*http.Response, []byte, error) {
+		// 打开文件
+		file, err := os.Open(imagePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open image file: %v", err)
+		}
+		defer file.Close()
 
-	// 创建回调请求
-	callbackReq, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:8584/api/v1/ai/callback", bytes.NewBuffer(callbackJSON))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create callback request: %v", err)
+		// 准备multipart表单
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+
+		// 添加文件
+		part, err := writer.CreateFormFile("file", filepath.Base(imagePath))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create form file: %v", err)
+		}
+		if _, err = io.Copy(part, file); err != nil {
+			return nil, nil, fmt.Errorf("failed to copy file content: %v", err)
+		}
+
+		// 添加附加字段
+		for name, value := range fields {
+			if err = writer.WriteField(name, value); err != nil {
+				return nil, nil, fmt.Errorf("failed to add %s field: %v", name, err)
+			}
+		}
+
+		if err = writer.Close(); err != nil {
+			return nil, nil, fmt.Errorf("failed to close writer: %v", err)
+		}
+
+		// 创建上传请求
+		req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create request: %v", err)
+		}
+
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		// 打印请求信息
+		fmt.Printf("\n请求URL: %s\n", req.URL.String())
+		fmt.Printf("请求方法: %s\n", req.Method)
+		fmt.Printf("Content-Type: %s\n", req.Header.Get("Content-Type"))
+		fmt.Printf("Authorization: Bearer %s\n", utils.MaskSecret(token))
+		fmt.Printf("图片文件: %s\n\n", imagePath)
+
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		// 读取响应内容
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read response body: %v", err)
+		}
+
+		// 打印响应信息
+		fmt.Printf("响应状态码: %d\n", resp.StatusCode)
+		fmt.Printf("响应内容: %s\n\n", string(respBody))
+		return resp, respBody, nil
 	}
-	callbackReq.Header.Set("Content-Type", "application/json")
 
-	// 发送回调请求
-	callbackResp, err := s.httpClient.Do(callbackReq)
+	// 首次尝试发送请求
+	resp, respBody, err := sendRequest(token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send callback request: %v", err)
+		return nil, nil, err
 	}
-	defer callbackResp.Body.Close()
 
-	// 检查回调响应状态
-	if callbackResp.StatusCode != http.StatusOK {
-		callbackRespBody, _ := io.ReadAll(callbackResp.Body)
-		return nil, fmt.Errorf("callback request failed with status %d: %s", callbackResp.StatusCode, string(callbackRespBody))
+	// 如果是401错误，尝试刷新token并重试
+	if resp.StatusCode == http.StatusUnauthorized {
+		var errorResp struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(respBody, &errorResp); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse error response: %v", err)
+		}
+
+		// 如果是token过期错误
+		if errorResp.Code == 1003 {
+			fmt.Println("Token已过期，正在刷新...")
+
+			// 由凭证管理器刷新token（刷新失败时自动重新认证）
+			newToken, err := s.credentials.ForceRefresh(ctx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to refresh token: %v", err)
+			}
+
+			fmt.Println("Token刷新成功，重试请求...")
+
+			// 使用新token重试请求
+			resp, respBody, err = sendRequest(newToken)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to retry request: %v", err)
+			}
+		}
 	}
+	return resp, respBody, nil
+}
 
-	// // 创建预测结果
-	// predictionResult := &models.PredictionResult{
-	// 	TaskID:           taskID,
-	// 	PredictionStatus: models.StatusCompleted,
-	// 	PredictionModel:  queryResult.Data.PredictModel,
-	// 	HasDefect:        queryResult.Data.HasDefect,
-	// 	DefectType:       queryResult.Data.DefectType,
-	// 	Confidence:       queryResult.Data.Confidence,
-	// }
+// UploadDatasetSample 上传训练样本图片及元数据，endpoint以/开头时相对于云端API地址
+func (s *CloudAIService) UploadDatasetSample(ctx context.Context, endpoint, imagePath string, fields map[string]string) error {
+	url := endpoint
+	if strings.HasPrefix(endpoint, "/") {
+		url = s.baseURL + endpoint
+	}
 
-	// // 保存预测结果到数据库
-	// if err := s.dbService.SavePredictionResult(predictionResult); err != nil {
-	// 	return nil, fmt.Errorf("failed to save prediction result: %v", err)
-	// }
+	resp, respBody, err := s.postMultipart(ctx, url, imagePath, fields)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned non-200 status code: %d, body: %s", resp.StatusCode, truncate(string(respBody), 200))
+	}
 
-	return nil, nil
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	if result.Code != 200 {
+		return fmt.Errorf("upload failed: %s", result.Msg)
+	}
+	return nil
 }
 
 // RegisterDevice 注册设备
@@ -596,7 +598,7 @@ func (s *CloudAIService) RegisterDevice(ctx context.Context, sn, model string) (
 	req.Header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return "", fmt.Errorf("send request failed: %v", err)
 	}
@@ -653,7 +655,7 @@ func (s *CloudAIService) AuthDevice(ctx context.Context, sn, secret string) (str
 	req.Header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return "", fmt.Errorf("send request failed: %v", err)
 	}
@@ -680,7 +682,7 @@ func (s *CloudAIService) AuthDevice(ctx context.Context, sn, secret string) (str
 
 // RefreshToken 刷新token
 func (s *CloudAIService) RefreshToken(ctx context.Context, oldToken string) (string, error) {
-	fmt.Printf("开始刷新Token，旧Token: %s\n", oldToken)
+	fmt.Printf("开始刷新Token，旧Token: %s\n", utils.MaskSecret(oldToken))
 
 	// 创建请求
 	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/devices/refresh", nil)
@@ -690,10 +692,9 @@ func (s *CloudAIService) RefreshToken(ctx context.Context, oldToken string) (str
 
 	req.Header.Set("Authorization", "Bearer "+oldToken)
 	fmt.Printf("刷新Token请求URL: %s\n", req.URL.String())
-	fmt.Printf("刷新Token请求头: %s\n", req.Header.Get("Authorization"))
 
 	// 发送请求
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return "", fmt.Errorf("send request failed: %v", err)
 	}
@@ -706,7 +707,6 @@ func (s *CloudAIService) RefreshToken(ctx context.Context, oldToken string) (str
 	}
 
 	fmt.Printf("刷新Token响应状态码: %d\n", resp.StatusCode)
-	fmt.Printf("刷新Token响应内容: %s\n", string(respBody))
 
 	// 解析响应
 	var refreshResp DeviceAuthResponse
@@ -718,6 +718,6 @@ func (s *CloudAIService) RefreshToken(ctx context.Context, oldToken string) (str
 		return "", fmt.Errorf("refresh token failed: %s", refreshResp.Message)
 	}
 
-	fmt.Printf("成功获取新Token: %s\n", refreshResp.Data.Token)
+	fmt.Printf("成功获取新Token: %s\n", utils.MaskSecret(refreshResp.Data.Token))
 	return refreshResp.Data.Token, nil
 } 
\ No newline at end of file
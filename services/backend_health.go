@@ -0,0 +1,465 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mingda_ai_helper/models"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// BreakerState 熔断器状态
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// ErrBackendUnavailable 后端熔断中，请求未发出
+var ErrBackendUnavailable = errors.New("AI后端不可用（熔断中）")
+
+// BackendHealthStatus 后端健康状态快照
+type BackendHealthStatus struct {
+	Name         string       `json:"name"`
+	State        BreakerState `json:"state"`
+	Samples      int          `json:"samples"`
+	ErrorRate    float64      `json:"error_rate"`
+	LatencyP50Ms int64        `json:"latency_p50_ms"`
+	LatencyP95Ms int64        `json:"latency_p95_ms"`
+	LatencyP99Ms int64        `json:"latency_p99_ms"`
+	LastError    string       `json:"last_error,omitempty"`
+	LastProbeAt  *time.Time   `json:"last_probe_at,omitempty"`
+	ProbeHealthy bool         `json:"probe_healthy"`
+	OpenedAt     *time.Time   `json:"opened_at,omitempty"`
+}
+
+type callSample struct {
+	latency time.Duration
+	failed  bool
+}
+
+// BackendHealth 单个AI后端的健康跟踪：滚动错误率、延迟分位数和熔断器
+type BackendHealth struct {
+	name     string
+	probeURL string
+
+	mu      sync.Mutex
+	samples []callSample // 环形缓冲
+	next    int
+	count   int
+
+	state               BreakerState
+	openedAt            time.Time
+	consecutiveFailures int
+	trialInFlight       bool
+	lastError           string
+	lastProbeAt         time.Time
+	probeHealthy        bool
+
+	// 熔断参数
+	minSamples       int
+	errorRateLimit   float64
+	failureThreshold int
+	openDuration     time.Duration
+
+	onStateChange func(name string, from, to BreakerState)
+}
+
+// NewBackendHealth 创建后端健康跟踪器
+func NewBackendHealth(name, probeURL string) *BackendHealth {
+	return &BackendHealth{
+		name:             name,
+		probeURL:         probeURL,
+		samples:          make([]callSample, 50),
+		state:            BreakerClosed,
+		probeHealthy:     true,
+		minSamples:       10,
+		errorRateLimit:   0.5,
+		failureThreshold: 3,
+		openDuration:     time.Minute,
+	}
+}
+
+// Name 后端名称
+func (h *BackendHealth) Name() string {
+	return h.name
+}
+
+// Allow 判断是否允许发出请求；熔断打开超过openDuration后放行一次试探请求
+func (h *BackendHealth) Allow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch h.state {
+	case BreakerOpen:
+		if time.Since(h.openedAt) < h.openDuration {
+			return false
+		}
+		h.setStateLocked(BreakerHalfOpen)
+		h.trialInFlight = true
+		return true
+	case BreakerHalfOpen:
+		if h.trialInFlight {
+			return false
+		}
+		h.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Available 判断后端当前是否可用（不占用试探名额）
+func (h *BackendHealth) Available() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch h.state {
+	case BreakerOpen:
+		return time.Since(h.openedAt) >= h.openDuration
+	case BreakerHalfOpen:
+		return !h.trialInFlight
+	default:
+		return true
+	}
+}
+
+// Record 记录一次请求结果
+func (h *BackendHealth) Record(latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples[h.next] = callSample{latency: latency, failed: err != nil}
+	h.next = (h.next + 1) % len(h.samples)
+	if h.count < len(h.samples) {
+		h.count++
+	}
+	h.trialInFlight = false
+
+	if err == nil {
+		h.consecutiveFailures = 0
+		if h.state != BreakerClosed {
+			h.setStateLocked(BreakerClosed)
+		}
+		return
+	}
+
+	h.lastError = err.Error()
+	h.consecutiveFailures++
+	switch h.state {
+	case BreakerHalfOpen:
+		h.openLocked()
+	case BreakerClosed:
+		if h.consecutiveFailures >= h.failureThreshold ||
+			(h.count >= h.minSamples && h.errorRateLocked() >= h.errorRateLimit) {
+			h.openLocked()
+		}
+	}
+}
+
+// recordProbe 记录健康探测结果。探测失败视同一次请求失败，探测成功则让熔断器提前进入半开状态
+func (h *BackendHealth) recordProbe(err error) {
+	h.mu.Lock()
+	h.lastProbeAt = time.Now()
+	h.probeHealthy = err == nil
+	if err == nil {
+		if h.state == BreakerOpen {
+			h.setStateLocked(BreakerHalfOpen)
+			h.trialInFlight = false
+		}
+		h.mu.Unlock()
+		return
+	}
+	h.mu.Unlock()
+
+	if h.State() != BreakerOpen {
+		h.Record(0, fmt.Errorf("健康探测失败: %v", err))
+	}
+}
+
+// State 当前熔断器状态
+func (h *BackendHealth) State() BreakerState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// Status 返回健康状态快照
+func (h *BackendHealth) Status() BackendHealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	latencies := make([]time.Duration, 0, h.count)
+	for i := 0; i < h.count; i++ {
+		if s := h.samples[i]; !s.failed {
+			latencies = append(latencies, s.latency)
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	status := BackendHealthStatus{
+		Name:         h.name,
+		State:        h.state,
+		Samples:      h.count,
+		ErrorRate:    h.errorRateLocked(),
+		LatencyP50Ms: percentile(latencies, 0.50).Milliseconds(),
+		LatencyP95Ms: percentile(latencies, 0.95).Milliseconds(),
+		LatencyP99Ms: percentile(latencies, 0.99).Milliseconds(),
+		LastError:    h.lastError,
+		ProbeHealthy: h.probeHealthy,
+	}
+	if !h.lastProbeAt.IsZero() {
+		t := h.lastProbeAt
+		status.LastProbeAt = &t
+	}
+	if h.state != BreakerClosed {
+		t := h.openedAt
+		status.OpenedAt = &t
+	}
+	return status
+}
+
+func (h *BackendHealth) errorRateLocked() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	failed := 0
+	for i := 0; i < h.count; i++ {
+		if h.samples[i].failed {
+			failed++
+		}
+	}
+	return float64(failed) / float64(h.count)
+}
+
+func (h *BackendHealth) openLocked() {
+	h.openedAt = time.Now()
+	h.setStateLocked(BreakerOpen)
+}
+
+func (h *BackendHealth) setStateLocked(state BreakerState) {
+	if h.state == state {
+		return
+	}
+	from := h.state
+	h.state = state
+	if h.onStateChange != nil {
+		go h.onStateChange(h.name, from, state)
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+// GuardedAIService 带熔断保护的AI服务，熔断打开时直接返回错误而不等待超时
+type GuardedAIService struct {
+	inner  AIService
+	health *BackendHealth
+}
+
+// NewGuardedAIService 用健康跟踪器包装AI服务
+func NewGuardedAIService(inner AIService, health *BackendHealth) *GuardedAIService {
+	return &GuardedAIService{inner: inner, health: health}
+}
+
+// Name 后端名称
+func (s *GuardedAIService) Name() string {
+	return s.health.Name()
+}
+
+// Available 后端当前是否可用
+func (s *GuardedAIService) Available() bool {
+	return s.health.Available()
+}
+
+func (s *GuardedAIService) Predict(ctx context.Context, imageURL string, taskID string) (*models.PredictionResult, error) {
+	if !s.health.Allow() {
+		return nil, ErrBackendUnavailable
+	}
+	start := time.Now()
+	result, err := s.inner.Predict(ctx, imageURL, taskID)
+	s.health.Record(time.Since(start), err)
+	s.tag(result, time.Since(start))
+	return result, err
+}
+
+func (s *GuardedAIService) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
+	if !s.health.Allow() {
+		return nil, ErrBackendUnavailable
+	}
+	start := time.Now()
+	result, err := s.inner.PredictWithFile(ctx, imagePath, taskID)
+	s.health.Record(time.Since(start), err)
+	s.tag(result, time.Since(start))
+	return result, err
+}
+
+// tag 标记结果来自哪个后端，用于置信度换算和统计。同步返回的结果同时记录耗时
+func (s *GuardedAIService) tag(result *models.PredictionResult, elapsed time.Duration) {
+	if result == nil {
+		return
+	}
+	if result.Backend == "" {
+		result.Backend = s.Name()
+	}
+	if result.PredictionStatus == models.StatusCompleted && result.LatencyMs == 0 {
+		result.LatencyMs = elapsed.Milliseconds()
+	}
+}
+
+// HealthAware 可报告可用性的AI服务
+type HealthAware interface {
+	Name() string
+	Available() bool
+}
+
+// backendAvailable 判断AI服务是否可用，未接入健康跟踪的服务视为可用
+func backendAvailable(svc AIService) bool {
+	if h, ok := svc.(HealthAware); ok {
+		return h.Available()
+	}
+	return true
+}
+
+// HealthMonitor 管理所有后端的健康跟踪器并定期探测健康接口
+type HealthMonitor struct {
+	logService    LogInterface
+	events        *EventBus
+	httpClient    *http.Client
+	probeInterval time.Duration
+
+	mu       sync.RWMutex
+	backends []*BackendHealth
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewHealthMonitor 创建健康监控
+func NewHealthMonitor(logService LogInterface) *HealthMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HealthMonitor{
+		logService:    logService,
+		httpClient:    &http.Client{Timeout: 5 * time.Second},
+		probeInterval: 30 * time.Second,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// SetEventBus 设置事件总线，推送熔断状态变化
+func (m *HealthMonitor) SetEventBus(events *EventBus) {
+	m.events = events
+}
+
+// AddBackend 注册一个后端，probeURL为空时不做主动探测
+func (m *HealthMonitor) AddBackend(name, probeURL string) *BackendHealth {
+	health := NewBackendHealth(name, probeURL)
+	health.onStateChange = m.stateChanged
+
+	m.mu.Lock()
+	m.backends = append(m.backends, health)
+	m.mu.Unlock()
+	return health
+}
+
+// Get 按名称获取后端健康跟踪器
+func (m *HealthMonitor) Get(name string) (*BackendHealth, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, h := range m.backends {
+		if h.name == name {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+// Statuses 返回所有后端的健康状态
+func (m *HealthMonitor) Statuses() []BackendHealthStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make([]BackendHealthStatus, 0, len(m.backends))
+	for _, h := range m.backends {
+		statuses = append(statuses, h.Status())
+	}
+	return statuses
+}
+
+// Start 启动定期探测
+func (m *HealthMonitor) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.probeInterval)
+		defer ticker.Stop()
+
+		m.probeAll()
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				m.probeAll()
+			}
+		}
+	}()
+}
+
+// Stop 停止探测
+func (m *HealthMonitor) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *HealthMonitor) probeAll() {
+	m.mu.RLock()
+	backends := append([]*BackendHealth(nil), m.backends...)
+	m.mu.RUnlock()
+
+	for _, h := range backends {
+		if h.probeURL == "" {
+			continue
+		}
+		h.recordProbe(m.probe(h.probeURL))
+	}
+}
+
+// probe 请求健康接口，能连通且非5xx即认为健康
+func (m *HealthMonitor) probe(url string) error {
+	req, err := http.NewRequestWithContext(m.ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("状态码: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (m *HealthMonitor) stateChanged(name string, from, to BreakerState) {
+	m.logService.Info("AI后端熔断状态变化",
+		zap.String("backend", name),
+		zap.String("from", string(from)),
+		zap.String("to", string(to)))
+	m.events.Publish(EventBackendHealth, map[string]interface{}{"backend": name, "from": from, "to": to})
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBreakerOpensAfterConsecutiveFailures 测试连续失败后熔断，冷却后放行一次试探请求
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	health := NewBackendHealth("local", "")
+	health.openDuration = 20 * time.Millisecond
+
+	for i := 0; i < health.failureThreshold; i++ {
+		assert.True(t, health.Allow())
+		health.Record(time.Millisecond, errors.New("connection refused"))
+	}
+	assert.Equal(t, BreakerOpen, health.State())
+	assert.False(t, health.Allow())
+	assert.False(t, health.Available())
+
+	time.Sleep(25 * time.Millisecond)
+	assert.True(t, health.Allow())
+	assert.Equal(t, BreakerHalfOpen, health.State())
+	// 半开状态只允许一个试探请求
+	assert.False(t, health.Allow())
+
+	health.Record(5*time.Millisecond, nil)
+	assert.Equal(t, BreakerClosed, health.State())
+	assert.True(t, health.Allow())
+}
+
+// TestBreakerReopensOnFailedTrial 测试试探请求失败后重新熔断
+func TestBreakerReopensOnFailedTrial(t *testing.T) {
+	health := NewBackendHealth("cloud", "")
+	health.openDuration = 10 * time.Millisecond
+
+	for i := 0; i < health.failureThreshold; i++ {
+		health.Record(0, errors.New("timeout"))
+	}
+	time.Sleep(15 * time.Millisecond)
+	assert.True(t, health.Allow())
+
+	health.Record(0, errors.New("timeout"))
+	assert.Equal(t, BreakerOpen, health.State())
+	assert.False(t, health.Allow())
+}
+
+// TestBackendHealthStatus 测试错误率和延迟分位数
+func TestBackendHealthStatus(t *testing.T) {
+	health := NewBackendHealth("local", "")
+	for i := 1; i <= 10; i++ {
+		health.Record(time.Duration(i*100)*time.Millisecond, nil)
+	}
+	health.Record(0, errors.New("bad gateway"))
+
+	status := health.Status()
+	assert.Equal(t, BreakerClosed, status.State)
+	assert.Equal(t, 11, status.Samples)
+	assert.InDelta(t, 1.0/11, status.ErrorRate, 0.001)
+	assert.Equal(t, int64(500), status.LatencyP50Ms)
+	assert.Equal(t, int64(900), status.LatencyP95Ms)
+	assert.Equal(t, "bad gateway", status.LastError)
+}
@@ -0,0 +1,366 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrUnknownBackend 请求的AI后端没有配置
+var ErrUnknownBackend = errors.New("AI后端不存在")
+
+// BackendInput 后端接收图片的方式
+type BackendInput string
+
+const (
+	InputURL  BackendInput = "url"  // 传图片URL，由后端自行下载
+	InputFile BackendInput = "file" // 传本地图片文件
+)
+
+// Snapshot 一次检测使用的图片
+type Snapshot struct {
+	TaskID    string
+	ImageURL  string
+	ImagePath string
+}
+
+// BackendDeps 创建后端时可用的依赖
+type BackendDeps struct {
+	DBService   *DBService
+	LogService  *LogService
+	Credentials *CredentialManager
+	CallbackURL string
+}
+
+// BackendFactory 根据配置创建AI服务
+type BackendFactory func(cfg config.BackendConfig, deps BackendDeps) (AIService, error)
+
+type backendType struct {
+	input   BackendInput
+	factory BackendFactory
+	// async 结果通过回调返回，需要配置回调签名密钥
+	async bool
+}
+
+// Backend 已注册的AI后端
+type Backend struct {
+	Name    string
+	Type    string
+	Input   BackendInput
+	Classes []string
+	Service AIService
+	Health  *BackendHealth
+	// Confidence 置信度换算和校准，为nil时只校验0-1范围
+	Confidence *ConfidenceNormalizer
+	// Defects 类别到统一缺陷类型的映射，为nil时只使用内置别名
+	Defects *DefectMapper
+	// CallbackSecret 回调签名密钥，未配置时不接受该后端的回调
+	CallbackSecret []byte
+	// Async 结果通过回调返回
+	Async bool
+	// Workers 预测队列中同时处理该后端任务的数量，0按1处理
+	Workers int
+}
+
+// PredictSnapshot 按后端支持的输入方式发起预测
+func (b *Backend) PredictSnapshot(ctx context.Context, snap Snapshot) (*models.PredictionResult, error) {
+	if (b.Input == InputFile && snap.ImagePath != "") || snap.ImageURL == "" {
+		return b.Service.PredictWithFile(ctx, snap.ImagePath, snap.TaskID)
+	}
+	return b.Service.Predict(ctx, snap.ImageURL, snap.TaskID)
+}
+
+// Available 后端当前是否可用
+func (b *Backend) Available() bool {
+	return backendAvailable(b.Service)
+}
+
+// ConfigWarning 返回后端的配置问题，没有问题时返回空字符串
+func (b *Backend) ConfigWarning() string {
+	if b.Async && len(b.CallbackSecret) == 0 {
+		return "未配置callback_secret，该后端的回调都会被拒绝，预测任务将超时"
+	}
+	return ""
+}
+
+// BackendRegistry 按配置创建并管理AI后端，调用方按名称获取后端
+type BackendRegistry struct {
+	health *HealthMonitor
+
+	mu          sync.RWMutex
+	types       map[string]backendType
+	backends    map[string]*Backend
+	order       []string
+	defaultName string
+}
+
+// NewBackendRegistry 创建后端注册表，并注册内置的后端类型
+func NewBackendRegistry(health *HealthMonitor) *BackendRegistry {
+	r := &BackendRegistry{
+		health:   health,
+		types:    make(map[string]backendType),
+		backends: make(map[string]*Backend),
+	}
+	r.RegisterAsyncType("http-json", InputURL, newHTTPJSONBackend)
+	r.RegisterType("mingda-cloud", InputFile, newMingdaCloudBackend)
+	r.RegisterType("exec", InputFile, newExecBackend)
+	r.RegisterType("obico", InputURL, newObicoBackend)
+	r.RegisterType("heuristic", InputFile, newHeuristicBackend)
+	r.RegisterType("mock", InputURL, newMockBackend)
+	return r
+}
+
+// RegisterType 注册后端类型
+func (r *BackendRegistry) RegisterType(typ string, input BackendInput, factory BackendFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[typ] = backendType{input: input, factory: factory}
+}
+
+// RegisterAsyncType 注册通过回调返回结果的后端类型
+func (r *BackendRegistry) RegisterAsyncType(typ string, input BackendInput, factory BackendFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[typ] = backendType{input: input, factory: factory, async: true}
+}
+
+// Build 按配置创建所有后端
+func (r *BackendRegistry) Build(cfgs []config.BackendConfig, deps BackendDeps) error {
+	for _, cfg := range cfgs {
+		r.mu.RLock()
+		typ, ok := r.types[cfg.Type]
+		r.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("未知的AI后端类型: %s (%s)", cfg.Type, cfg.Name)
+		}
+
+		svc, err := typ.factory(cfg, deps)
+		if err != nil {
+			return fmt.Errorf("创建AI后端%s失败: %v", cfg.Name, err)
+		}
+		normalizer, err := NewConfidenceNormalizer(cfg)
+		if err != nil {
+			return fmt.Errorf("AI后端%s的置信度配置无效: %v", cfg.Name, err)
+		}
+		defects, err := NewDefectMapper(cfg.ClassAliases)
+		if err != nil {
+			return fmt.Errorf("AI后端%s的class_aliases无效: %v", cfg.Name, err)
+		}
+		// 声明的类别必须能映射到统一的缺陷类型，否则该类别的结果都会记为unknown
+		for _, class := range cfg.Classes {
+			if defects.Map(class) == models.DefectUnknown && models.NormalizeDefectLabel(class) != string(models.DefectUnknown) {
+				return fmt.Errorf("AI后端%s的类别%s无法映射到统一的缺陷类型，请在class_aliases中配置", cfg.Name, class)
+			}
+		}
+		backend := r.Add(cfg.Name, cfg.Type, typ.input, cfg.Classes, svc, cfg.HealthURL)
+		backend.Confidence = normalizer
+		backend.Defects = defects
+		backend.Workers = cfg.Workers
+		backend.Async = typ.async
+		if cfg.CallbackSecret != "" {
+			backend.CallbackSecret = []byte(cfg.CallbackSecret)
+		}
+		// 未配置密钥时回调全部被拒绝，任务只能等到超时
+		if warning := backend.ConfigWarning(); warning != "" && r.health.logService != nil {
+			r.health.logService.Error("AI后端配置错误",
+				zap.String("backend", cfg.Name),
+				zap.String("error", warning))
+		}
+	}
+	return nil
+}
+
+// Add 注册一个已创建的AI服务，自动接入健康跟踪和熔断
+func (r *BackendRegistry) Add(name, typ string, input BackendInput, classes []string, svc AIService, healthURL string) *Backend {
+	health := r.health.AddBackend(name, healthURL)
+	backend := &Backend{
+		Name:    name,
+		Type:    typ,
+		Input:   input,
+		Classes: classes,
+		Service: NewGuardedAIService(svc, health),
+		Health:  health,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.backends[name]; !exists {
+		r.order = append(r.order, name)
+	}
+	r.backends[name] = backend
+	return backend
+}
+
+// Get 按名称获取后端
+func (r *BackendRegistry) Get(name string) (*Backend, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	backend, ok := r.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, name)
+	}
+	return backend, nil
+}
+
+// SetDefault 设置未指定后端时使用的默认后端
+func (r *BackendRegistry) SetDefault(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultName = name
+}
+
+// Resolve 按名称获取后端，名称为空时返回默认后端（未设置时为第一个注册的后端）
+func (r *BackendRegistry) Resolve(name string) (*Backend, error) {
+	if name == "" {
+		r.mu.RLock()
+		name = r.defaultName
+		if name == "" && len(r.order) > 0 {
+			name = r.order[0]
+		}
+		r.mu.RUnlock()
+	}
+	return r.Get(name)
+}
+
+// Names 返回所有后端名称（按注册顺序）
+func (r *BackendRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.order...)
+}
+
+// DefectMappers 返回各后端的类别映射，用于迁移历史结果
+func (r *BackendRegistry) DefectMappers() map[string]*DefectMapper {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mappers := make(map[string]*DefectMapper, len(r.backends))
+	for name, b := range r.backends {
+		mappers[name] = b.Defects
+	}
+	return mappers
+}
+
+// Health 返回健康监控
+func (r *BackendRegistry) Health() *HealthMonitor {
+	return r.health
+}
+
+// BackendInfo 后端信息，用于API展示
+type BackendInfo struct {
+	Name    string              `json:"name"`
+	Type    string              `json:"type"`
+	Input   BackendInput        `json:"input"`
+	Classes []string            `json:"classes"`
+	Health  BackendHealthStatus `json:"health"`
+	// Warning 配置问题，如异步后端缺少回调密钥
+	Warning string `json:"warning,omitempty"`
+}
+
+// Infos 返回所有后端信息
+func (r *BackendRegistry) Infos() []BackendInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	infos := make([]BackendInfo, 0, len(r.order))
+	for _, name := range r.order {
+		b := r.backends[name]
+		infos = append(infos, BackendInfo{
+			Name:    b.Name,
+			Type:    b.Type,
+			Input:   b.Input,
+			Classes: b.Classes,
+			Health:  b.Health.Status(),
+			Warning: b.ConfigWarning(),
+		})
+	}
+	return infos
+}
+
+// newHTTPJSONBackend 本地HTTP推理服务（POST /api/v1/predict）
+func newHTTPJSONBackend(cfg config.BackendConfig, deps BackendDeps) (AIService, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("缺少url")
+	}
+	svc := NewLocalAIService(cfg.URL, deps.CallbackURL, deps.DBService)
+	svc.httpClient.Timeout = time.Duration(cfg.Timeout) * time.Second
+
+	switch cfg.Auth.Type {
+	case "", "none":
+	case "bearer":
+		svc.authHeader, svc.authValue = "Authorization", "Bearer "+cfg.Auth.Token
+	case "header":
+		if cfg.Auth.Header == "" {
+			return nil, fmt.Errorf("auth.type为header时必须配置auth.header")
+		}
+		svc.authHeader, svc.authValue = cfg.Auth.Header, cfg.Auth.Token
+	default:
+		return nil, fmt.Errorf("http-json后端不支持认证方式: %s", cfg.Auth.Type)
+	}
+	return svc, nil
+}
+
+// newMingdaCloudBackend 明达云端推理服务，使用设备token认证
+func newMingdaCloudBackend(cfg config.BackendConfig, deps BackendDeps) (AIService, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("缺少url")
+	}
+	if cfg.Auth.Type != "" && cfg.Auth.Type != "device" {
+		return nil, fmt.Errorf("mingda-cloud后端只支持device认证")
+	}
+	svc := NewCloudAIService(cfg.URL, deps.DBService)
+	svc.httpClient.Timeout = time.Duration(cfg.Timeout) * time.Second
+	svc.SetCredentialManager(deps.Credentials)
+	return svc, nil
+}
+
+// MockAIService 返回固定结果的后端，用于联调和演示
+type MockAIService struct {
+	name       string
+	hasDefect  bool
+	defectType string
+	confidence models.Confidence
+}
+
+// newMockBackend 根据options中的has_defect、defect_type、confidence(0-1)返回固定结果
+func newMockBackend(cfg config.BackendConfig, deps BackendDeps) (AIService, error) {
+	svc := &MockAIService{name: cfg.Name, defectType: cfg.Options["defect_type"]}
+	if v, ok := cfg.Options["has_defect"]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("无效的has_defect: %s", v)
+		}
+		svc.hasDefect = b
+	}
+	if v, ok := cfg.Options["confidence"]; ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的confidence: %s", v)
+		}
+		svc.confidence = models.Confidence(f)
+	}
+	return svc, nil
+}
+
+func (s *MockAIService) Predict(ctx context.Context, imageURL string, taskID string) (*models.PredictionResult, error) {
+	return &models.PredictionResult{
+		TaskID:           taskID,
+		PredictionStatus: models.StatusCompleted,
+		PredictionModel:  "mock_" + s.name,
+		HasDefect:        s.hasDefect,
+		DefectType:       models.DefectType(s.defectType),
+		Confidence:       s.confidence,
+	}, nil
+}
+
+func (s *MockAIService) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
+	return s.Predict(ctx, "file://"+imagePath, fileTaskID(taskID))
+}
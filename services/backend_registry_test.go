@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRegistry 创建使用临时日志的后端注册表
+func newTestRegistry(t *testing.T) *BackendRegistry {
+	logService, err := NewLogService("error", filepath.Join(t.TempDir(), "test.log"))
+	require.NoError(t, err)
+	return NewBackendRegistry(NewHealthMonitor(logService))
+}
+
+// TestBackendRegistryBuild 测试按配置创建后端，保留配置顺序和各项参数
+func TestBackendRegistryBuild(t *testing.T) {
+	registry := newTestRegistry(t)
+	err := registry.Build([]config.BackendConfig{
+		{Name: "local", Type: "http-json", URL: "http://127.0.0.1:8000", Classes: []string{"spaghetti", "string"}, Workers: 2, CallbackSecret: "s3cret"},
+		{Name: "demo", Type: "mock", Classes: []string{"Layer Shift"}, Options: map[string]string{"has_defect": "true", "defect_type": "spaghetti", "confidence": "0.9"}},
+	}, BackendDeps{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"local", "demo"}, registry.Names())
+
+	local, err := registry.Get("local")
+	require.NoError(t, err)
+	assert.Equal(t, "http-json", local.Type)
+	assert.Equal(t, InputURL, local.Input)
+	assert.Equal(t, 2, local.Workers)
+	assert.Equal(t, []byte("s3cret"), local.CallbackSecret)
+	assert.NotNil(t, local.Health)
+	assert.NotNil(t, local.Defects)
+
+	demo, err := registry.Get("demo")
+	require.NoError(t, err)
+	assert.Nil(t, demo.CallbackSecret)
+	result, err := demo.PredictSnapshot(context.Background(), Snapshot{TaskID: "T001", ImageURL: "http://example.com/a.jpg"})
+	require.NoError(t, err)
+	assert.Equal(t, "T001", result.TaskID)
+	assert.True(t, result.HasDefect)
+	assert.Equal(t, models.Confidence(0.9), result.Confidence)
+
+	infos := registry.Infos()
+	require.Len(t, infos, 2)
+	assert.Equal(t, []string{"spaghetti", "string"}, infos[0].Classes)
+	assert.Equal(t, InputURL, infos[1].Input)
+	assert.Empty(t, infos[0].Warning)
+	assert.Empty(t, infos[1].Warning)
+	assert.True(t, local.Async)
+	assert.False(t, demo.Async)
+}
+
+// TestBackendRegistryMissingCallbackSecret 测试异步后端缺少回调密钥时在后端信息中提示
+func TestBackendRegistryMissingCallbackSecret(t *testing.T) {
+	registry := newTestRegistry(t)
+	require.NoError(t, registry.Build([]config.BackendConfig{
+		{Name: "local", Type: "http-json", URL: "http://127.0.0.1:8000"},
+	}, BackendDeps{}))
+
+	infos := registry.Infos()
+	require.Len(t, infos, 1)
+	assert.Contains(t, infos[0].Warning, "callback_secret")
+}
+
+// TestBackendRegistryBuildRejectsInvalidConfig 测试未知类型和无效配置在启动时报错
+func TestBackendRegistryBuildRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.BackendConfig
+	}{
+		{"未知类型", config.BackendConfig{Name: "x", Type: "tensorflow"}},
+		{"缺少url", config.BackendConfig{Name: "x", Type: "http-json"}},
+		{"不支持的认证方式", config.BackendConfig{Name: "x", Type: "http-json", URL: "http://127.0.0.1", Auth: config.BackendAuthConfig{Type: "oauth"}}},
+		{"无效的别名", config.BackendConfig{Name: "x", Type: "mock", ClassAliases: map[string]string{"string": "strings"}}},
+		{"无法映射的类别", config.BackendConfig{Name: "x", Type: "mock", Classes: []string{"spaghetti", "nozzle_clog"}}},
+		{"无效的mock参数", config.BackendConfig{Name: "x", Type: "mock", Options: map[string]string{"confidence": "high"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newTestRegistry(t)
+			assert.Error(t, registry.Build([]config.BackendConfig{tt.cfg}, BackendDeps{}))
+			assert.Empty(t, registry.Names())
+		})
+	}
+
+	// 配置了别名后可以使用自定义类别
+	registry := newTestRegistry(t)
+	require.NoError(t, registry.Build([]config.BackendConfig{
+		{Name: "x", Type: "mock", Classes: []string{"nozzle_clog"}, ClassAliases: map[string]string{"nozzle_clog": "blob"}},
+	}, BackendDeps{}))
+}
+
+// TestBackendRegistryResolve 测试按名称和默认后端获取
+func TestBackendRegistryResolve(t *testing.T) {
+	registry := newTestRegistry(t)
+	_, err := registry.Resolve("")
+	assert.True(t, errors.Is(err, ErrUnknownBackend))
+
+	require.NoError(t, registry.Build([]config.BackendConfig{
+		{Name: "first", Type: "mock"},
+		{Name: "second", Type: "mock"},
+	}, BackendDeps{}))
+
+	// 未设置默认后端时使用第一个
+	backend, err := registry.Resolve("")
+	require.NoError(t, err)
+	assert.Equal(t, "first", backend.Name)
+
+	registry.SetDefault("second")
+	backend, err = registry.Resolve("")
+	require.NoError(t, err)
+	assert.Equal(t, "second", backend.Name)
+
+	backend, err = registry.Resolve("first")
+	require.NoError(t, err)
+	assert.Equal(t, "first", backend.Name)
+
+	_, err = registry.Resolve("missing")
+	assert.True(t, errors.Is(err, ErrUnknownBackend))
+	assert.Contains(t, err.Error(), "missing")
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mingda_ai_helper/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 回调签名相关的请求头，签名为 sha256=hex(HMAC-SHA256(callback_secret, timestamp + "." + nonce + "." + body))
+	CallbackSignatureHeader = "X-Callback-Signature"
+	CallbackTimestampHeader = "X-Callback-Timestamp" // Unix秒
+	CallbackNonceHeader     = "X-Callback-Nonce"
+
+	callbackSignaturePrefix = "sha256="
+	// callbackWindow 时间戳允许的偏差，nonce在该时间内不能重复使用
+	callbackWindow = 5 * time.Minute
+	// nonce长度限制，避免缓存被超长值占用
+	minNonceLength = 8
+	maxNonceLength = 128
+)
+
+var (
+	// ErrCallbackSignature 缺少签名、签名错误或后端未配置callback_secret
+	ErrCallbackSignature = errors.New("回调签名无效")
+	// ErrCallbackReplay 时间戳超出允许范围或nonce已使用
+	ErrCallbackReplay = errors.New("回调已过期或重复")
+	// ErrCallbackUnknownTask 回调的任务不是本机创建的
+	ErrCallbackUnknownTask = errors.New("回调的任务不存在")
+	// ErrCallbackTaskDone 回调的任务已有结果，或已失败、超时
+	ErrCallbackTaskDone = errors.New("回调的任务已完成")
+)
+
+// SignCallback 计算回调签名，AI服务按同样的方式签名
+func SignCallback(secret []byte, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return callbackSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CallbackVerifier 校验AI回调：任务必须是本机创建且仍在等待结果，签名使用该任务所属后端的密钥，
+// nonce在时间窗口内只能使用一次
+type CallbackVerifier struct {
+	backends  *BackendRegistry
+	dbService DBInterface
+	now       func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewCallbackVerifier 创建回调校验器
+func NewCallbackVerifier(backends *BackendRegistry, dbService DBInterface) *CallbackVerifier {
+	return &CallbackVerifier{
+		backends:  backends,
+		dbService: dbService,
+		now:       time.Now,
+		nonces:    make(map[string]time.Time),
+	}
+}
+
+// Verify 校验回调并返回等待中的任务
+func (v *CallbackVerifier) Verify(taskID, timestamp, nonce, signature string, body []byte) (*models.PredictionResult, error) {
+	if signature == "" || timestamp == "" || len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+		return nil, ErrCallbackSignature
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrCallbackSignature
+	}
+	now := v.now()
+	if skew := now.Sub(time.Unix(sec, 0)); skew > callbackWindow || skew < -callbackWindow {
+		return nil, ErrCallbackReplay
+	}
+
+	task, err := v.dbService.GetPredictionResult(taskID)
+	if err != nil {
+		return nil, fmt.Errorf("获取预测任务失败: %v", err)
+	}
+	if task == nil {
+		return nil, ErrCallbackUnknownTask
+	}
+	if task.PredictionStatus.Finished() {
+		return nil, ErrCallbackTaskDone
+	}
+
+	backend, err := v.backends.Get(task.Backend)
+	if err != nil || len(backend.CallbackSecret) == 0 {
+		return nil, ErrCallbackSignature
+	}
+	expected := SignCallback(backend.CallbackSecret, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return nil, ErrCallbackSignature
+	}
+
+	// 签名通过后才记录nonce，伪造的请求不会占用缓存
+	if !v.useNonce(task.Backend+":"+nonce, now) {
+		return nil, ErrCallbackReplay
+	}
+	return task, nil
+}
+
+// useNonce 记录nonce，已使用过时返回false
+func (v *CallbackVerifier) useNonce(key string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for k, seen := range v.nonces {
+		if now.Sub(seen) > 2*callbackWindow {
+			delete(v.nonces, k)
+		}
+	}
+	if _, used := v.nonces[key]; used {
+		return false
+	}
+	v.nonces[key] = now
+	return true
+}
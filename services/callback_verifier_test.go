@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCallbackVerifier 测试回调的签名、时间窗口、nonce重放和任务状态校验
+func TestCallbackVerifier(t *testing.T) {
+	processor, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	backends := NewBackendRegistry(NewHealthMonitor(processor.logService))
+	backends.Add("local", "mock", InputURL, nil, nil, "").CallbackSecret = []byte("local-secret")
+	backends.Add("nosecret", "mock", InputURL, nil, nil, "")
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{TaskID: "T1", PredictionStatus: models.StatusProcessing, Backend: "local"}))
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{TaskID: "T2", PredictionStatus: models.StatusCompleted, Backend: "local"}))
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{TaskID: "T3", PredictionStatus: models.StatusPending, Backend: "nosecret"}))
+
+	now := time.Unix(1760000000, 0)
+	verifier := NewCallbackVerifier(backends, db)
+	verifier.now = func() time.Time { return now }
+
+	body := []byte(`{"task_id":"T1"}`)
+	verify := func(taskID string, at time.Time, nonce string, secret string) error {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		_, err := verifier.Verify(taskID, ts, nonce, SignCallback([]byte(secret), ts, nonce, body), body)
+		return err
+	}
+
+	assert.True(t, errors.Is(verify("T1", now, "nonce-001", "wrong-secret"), ErrCallbackSignature))
+	assert.True(t, errors.Is(verify("T1", now, "short", "local-secret"), ErrCallbackSignature))
+	assert.True(t, errors.Is(verify("T1", now.Add(-6*time.Minute), "nonce-001", "local-secret"), ErrCallbackReplay))
+	assert.True(t, errors.Is(verify("T404", now, "nonce-001", "local-secret"), ErrCallbackUnknownTask))
+	assert.True(t, errors.Is(verify("T2", now, "nonce-001", "local-secret"), ErrCallbackTaskDone))
+	// 未配置密钥的后端不接受回调
+	assert.True(t, errors.Is(verify("T3", now, "nonce-001", ""), ErrCallbackSignature))
+
+	// 签名错误的请求不占用nonce
+	assert.NoError(t, verify("T1", now.Add(-time.Minute), "nonce-001", "local-secret"))
+	assert.True(t, errors.Is(verify("T1", now, "nonce-001", "local-secret"), ErrCallbackReplay))
+
+	// 时间窗口过后nonce被清理，但旧时间戳也已过期
+	now = now.Add(11 * time.Minute)
+	assert.True(t, errors.Is(verify("T1", now.Add(-11*time.Minute), "nonce-001", "local-secret"), ErrCallbackReplay))
+	assert.NoError(t, verify("T1", now, "nonce-002", "local-secret"))
+}
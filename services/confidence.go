@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+)
+
+// ErrInvalidConfidence 后端返回的置信度无法换算到0-1
+var ErrInvalidConfidence = errors.New("无效的置信度")
+
+// Calibrator 把后端的原始置信度（0-1）映射为校准后的置信度（0-1）
+type Calibrator interface {
+	Calibrate(x float64) float64
+}
+
+// PlattCalibrator Platt缩放：p = 1 / (1 + exp(A*x + B))
+type PlattCalibrator struct {
+	A, B float64
+}
+
+func (c PlattCalibrator) Calibrate(x float64) float64 {
+	return 1 / (1 + math.Exp(c.A*x+c.B))
+}
+
+// IsotonicCalibrator 保序回归的分段线性映射
+type IsotonicCalibrator struct {
+	xs, ys []float64
+}
+
+func (c IsotonicCalibrator) Calibrate(x float64) float64 {
+	n := len(c.xs)
+	if x <= c.xs[0] {
+		return c.ys[0]
+	}
+	if x >= c.xs[n-1] {
+		return c.ys[n-1]
+	}
+	for i := 1; i < n; i++ {
+		if x <= c.xs[i] {
+			t := (x - c.xs[i-1]) / (c.xs[i] - c.xs[i-1])
+			return c.ys[i-1] + t*(c.ys[i]-c.ys[i-1])
+		}
+	}
+	return c.ys[n-1]
+}
+
+// NewCalibrator 根据配置创建校准器，未配置时返回nil
+func NewCalibrator(cfg config.CalibrationConfig) (Calibrator, error) {
+	switch cfg.Method {
+	case "":
+		return nil, nil
+	case "platt":
+		if cfg.A == 0 && cfg.B == 0 {
+			return nil, fmt.Errorf("platt校准需要配置a、b")
+		}
+		return PlattCalibrator{A: cfg.A, B: cfg.B}, nil
+	case "isotonic":
+		if len(cfg.Points) < 2 {
+			return nil, fmt.Errorf("isotonic校准至少需要2个点")
+		}
+		c := IsotonicCalibrator{}
+		for i, p := range cfg.Points {
+			if len(p) != 2 || p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+				return nil, fmt.Errorf("isotonic校准点必须为0-1之间的[原始值, 校准值]: %v", p)
+			}
+			if i > 0 && (p[0] <= c.xs[i-1] || p[1] < c.ys[i-1]) {
+				return nil, fmt.Errorf("isotonic校准点必须按原始值递增且校准值不递减: %v", p)
+			}
+			c.xs = append(c.xs, p[0])
+			c.ys = append(c.ys, p[1])
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("不支持的校准方式: %s", cfg.Method)
+	}
+}
+
+// ConfidenceNormalizer 把后端返回的原始置信度换算到0-1并校准
+type ConfidenceNormalizer struct {
+	percent    bool
+	calibrator Calibrator
+}
+
+// NewConfidenceNormalizer 根据后端的confidence_scale和calibration配置创建
+func NewConfidenceNormalizer(cfg config.BackendConfig) (*ConfidenceNormalizer, error) {
+	n := &ConfidenceNormalizer{}
+	switch cfg.ConfidenceScale {
+	case "", "unit":
+	case "percent":
+		n.percent = true
+	default:
+		return nil, fmt.Errorf("不支持的置信度刻度: %s", cfg.ConfidenceScale)
+	}
+
+	calibrator, err := NewCalibrator(cfg.Calibration)
+	if err != nil {
+		return nil, err
+	}
+	n.calibrator = calibrator
+	return n, nil
+}
+
+// Normalize 换算单个置信度，超出后端声明的刻度时返回ErrInvalidConfidence。
+// n为nil时只做校验
+func (n *ConfidenceNormalizer) Normalize(raw models.Confidence) (models.Confidence, error) {
+	v := float64(raw)
+	if n != nil && n.percent {
+		v /= 100
+	}
+	if !models.Confidence(v).Valid() {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidConfidence, float64(raw))
+	}
+	if n != nil && n.calibrator != nil {
+		v = math.Min(1, math.Max(0, n.calibrator.Calibrate(v)))
+	}
+	return models.Confidence(v), nil
+}
+
+// NormalizeResult 换算预测结果及其检测框的置信度
+func (n *ConfidenceNormalizer) NormalizeResult(result *models.PredictionResult) error {
+	confidence, err := n.Normalize(result.Confidence)
+	if err != nil {
+		return err
+	}
+	result.Confidence = confidence
+
+	for i := range result.Detections {
+		confidence, err := n.Normalize(result.Detections[i].Confidence)
+		if err != nil {
+			return err
+		}
+		result.Detections[i].Confidence = confidence
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfidenceNormalizerScale 测试百分比刻度换算和越界校验
+func TestConfidenceNormalizerScale(t *testing.T) {
+	unit, err := NewConfidenceNormalizer(config.BackendConfig{})
+	require.NoError(t, err)
+	v, err := unit.Normalize(0.42)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.42, float64(v), 1e-9)
+
+	_, err = unit.Normalize(87)
+	assert.True(t, errors.Is(err, ErrInvalidConfidence))
+
+	percent, err := NewConfidenceNormalizer(config.BackendConfig{ConfidenceScale: "percent"})
+	require.NoError(t, err)
+	v, err = percent.Normalize(87)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.87, float64(v), 1e-9)
+
+	_, err = percent.Normalize(-1)
+	assert.True(t, errors.Is(err, ErrInvalidConfidence))
+
+	// nil只做校验
+	var none *ConfidenceNormalizer
+	_, err = none.Normalize(1.5)
+	assert.True(t, errors.Is(err, ErrInvalidConfidence))
+
+	_, err = NewConfidenceNormalizer(config.BackendConfig{ConfidenceScale: "logit"})
+	assert.Error(t, err)
+}
+
+// TestConfidenceCalibration 测试Platt和保序回归校准
+func TestConfidenceCalibration(t *testing.T) {
+	platt, err := NewConfidenceNormalizer(config.BackendConfig{
+		Calibration: config.CalibrationConfig{Method: "platt", A: -10, B: 5},
+	})
+	require.NoError(t, err)
+	v, err := platt.Normalize(0.5)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, float64(v), 1e-9)
+	v, _ = platt.Normalize(0.9)
+	assert.Greater(t, float64(v), 0.95)
+
+	isotonic, err := NewConfidenceNormalizer(config.BackendConfig{
+		Calibration: config.CalibrationConfig{Method: "isotonic", Points: [][]float64{{0, 0}, {0.5, 0.2}, {1, 0.9}}},
+	})
+	require.NoError(t, err)
+	result := &models.PredictionResult{
+		Confidence: 0.75,
+		Detections: models.Detections{{Label: "spaghetti", Confidence: 0.25}},
+	}
+	require.NoError(t, isotonic.NormalizeResult(result))
+	assert.InDelta(t, 0.55, float64(result.Confidence), 1e-9)
+	assert.InDelta(t, 0.1, float64(result.Detections[0].Confidence), 1e-9)
+
+	_, err = NewCalibrator(config.CalibrationConfig{Method: "isotonic", Points: [][]float64{{0.5, 0.5}, {0.4, 0.6}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/utils"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DeviceAuthenticator 设备认证接口，由云端AI服务实现
+type DeviceAuthenticator interface {
+	RegisterDevice(ctx context.Context, sn, model string) (string, error)
+	AuthDevice(ctx context.Context, sn, secret string) (string, error)
+	RefreshToken(ctx context.Context, oldToken string) (string, error)
+}
+
+// CredentialManager 设备身份生命周期管理：保存设备密钥，在token过期前主动刷新，
+// 刷新失败时使用设备密钥重新认证。所有云端请求都应从这里获取token。
+type CredentialManager struct {
+	auth       DeviceAuthenticator
+	dbService  *DBService
+	logService *LogService
+
+	// mu 保护token，刷新期间一直持有，避免并发请求重复刷新
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time // token过期时间（服务器时钟）
+	// clockSkew 服务器时间 - 本地时间（纳秒）。刷新请求的响应也会更新它，此时mu已被持有，所以不能用mu保护
+	clockSkew atomic.Int64
+
+	// 提前刷新的时间窗口
+	refreshBefore time.Duration
+	// 刷新失败后的重试间隔
+	retryInterval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	wakeup chan struct{}
+}
+
+// NewCredentialManager 创建新的凭证管理器
+func NewCredentialManager(auth DeviceAuthenticator, dbService *DBService, logService *LogService) *CredentialManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &CredentialManager{
+		auth:          auth,
+		dbService:     dbService,
+		logService:    logService,
+		refreshBefore: 10 * time.Minute,
+		retryInterval: time.Minute,
+		ctx:           ctx,
+		cancel:        cancel,
+		wakeup:        make(chan struct{}, 1),
+	}
+}
+
+// Start 启动后台刷新协程
+func (m *CredentialManager) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.refreshLoop()
+	}()
+}
+
+// Stop 停止后台刷新协程
+func (m *CredentialManager) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// EnsureRegistered 确保设备已在云端注册并持有设备密钥
+func (m *CredentialManager) EnsureRegistered(ctx context.Context, sn, model string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info, err := m.dbService.GetMachineInfo()
+	if err == nil && info.DeviceSecret != "" && info.MachineSN == sn {
+		return nil
+	}
+
+	secret, err := m.auth.RegisterDevice(ctx, sn, model)
+	if err != nil {
+		return fmt.Errorf("设备注册失败: %v", err)
+	}
+	m.logService.SetSecret("device_secret", secret)
+	token, err := m.auth.AuthDevice(ctx, sn, secret)
+	if err != nil {
+		return fmt.Errorf("设备认证失败: %v", err)
+	}
+
+	if err := m.dbService.SaveMachineInfo(&models.MachineInfo{
+		MachineSN:    sn,
+		MachineModel: model,
+		AuthToken:    token,
+		DeviceSecret: secret,
+	}); err != nil {
+		return fmt.Errorf("保存设备凭证失败: %v", err)
+	}
+
+	m.setTokenLocked(token)
+	m.logService.Info("设备注册成功", zap.String("machine_sn", sn))
+	return nil
+}
+
+// Token 返回当前可用的token，临近过期时先刷新
+func (m *CredentialManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == "" {
+		if err := m.loadLocked(); err != nil {
+			return "", err
+		}
+	}
+
+	if m.needsRefreshLocked() {
+		if err := m.renewLocked(ctx); err != nil {
+			return "", err
+		}
+	}
+	return m.token, nil
+}
+
+// ForceRefresh 服务器拒绝当前token时强制刷新
+func (m *CredentialManager) ForceRefresh(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == "" {
+		if err := m.loadLocked(); err != nil {
+			return "", err
+		}
+	}
+	if err := m.renewLocked(ctx); err != nil {
+		return "", err
+	}
+	return m.token, nil
+}
+
+// ObserveResponse 根据服务器响应的Date头校正本地时钟偏差
+func (m *CredentialManager) ObserveResponse(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	m.clockSkew.Store(int64(serverTime.Sub(time.Now())))
+}
+
+// serverNow 按服务器时钟计算的当前时间
+func (m *CredentialManager) serverNow() time.Time {
+	return time.Now().Add(time.Duration(m.clockSkew.Load()))
+}
+
+func (m *CredentialManager) needsRefreshLocked() bool {
+	if m.expiresAt.IsZero() {
+		return false
+	}
+	return !m.serverNow().Add(m.refreshBefore).Before(m.expiresAt)
+}
+
+// loadLocked 从数据库加载已保存的token
+func (m *CredentialManager) loadLocked() error {
+	info, err := m.dbService.GetMachineInfo()
+	if err != nil {
+		return fmt.Errorf("获取机器信息失败: %v", err)
+	}
+	if info.AuthToken == "" && info.DeviceSecret == "" {
+		return fmt.Errorf("设备尚未注册")
+	}
+	m.logService.SetSecret("device_secret", info.DeviceSecret)
+	m.setTokenLocked(info.AuthToken)
+	return nil
+}
+
+// renewLocked 先尝试刷新token，失败后用设备密钥重新认证
+func (m *CredentialManager) renewLocked(ctx context.Context) error {
+	info, err := m.dbService.GetMachineInfo()
+	if err != nil {
+		return fmt.Errorf("获取机器信息失败: %v", err)
+	}
+
+	if m.token != "" {
+		token, err := m.auth.RefreshToken(ctx, m.token)
+		if err == nil {
+			return m.storeTokenLocked(info.MachineSN, token)
+		}
+		m.logService.Error("刷新token失败，尝试重新认证", zap.Error(err))
+	}
+
+	if info.DeviceSecret == "" {
+		return fmt.Errorf("缺少设备密钥，无法重新认证")
+	}
+	token, err := m.auth.AuthDevice(ctx, info.MachineSN, info.DeviceSecret)
+	if err != nil {
+		return fmt.Errorf("重新认证失败: %v", err)
+	}
+	return m.storeTokenLocked(info.MachineSN, token)
+}
+
+func (m *CredentialManager) storeTokenLocked(machineSN, token string) error {
+	if err := m.dbService.UpdateMachineToken(machineSN, token); err != nil {
+		return fmt.Errorf("保存token失败: %v", err)
+	}
+	m.setTokenLocked(token)
+
+	// 通知后台协程按新的过期时间重新计时
+	select {
+	case m.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (m *CredentialManager) setTokenLocked(token string) {
+	m.logService.SetSecret("auth_token", token)
+	m.token = token
+	m.expiresAt = time.Time{}
+	if expiresAt, err := utils.ParseTokenExpiry(token); err == nil {
+		m.expiresAt = expiresAt
+	}
+}
+
+// nextRefreshDelay 距离下一次主动刷新的等待时间
+func (m *CredentialManager) nextRefreshDelay() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token == "" {
+		if err := m.loadLocked(); err != nil {
+			return m.retryInterval
+		}
+	}
+	if m.expiresAt.IsZero() {
+		return time.Hour
+	}
+
+	delay := m.expiresAt.Add(-m.refreshBefore).Sub(m.serverNow())
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// refreshLoop 在token过期前主动刷新
+func (m *CredentialManager) refreshLoop() {
+	for {
+		timer := time.NewTimer(m.nextRefreshDelay())
+		select {
+		case <-m.ctx.Done():
+			timer.Stop()
+			return
+		case <-m.wakeup:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		m.mu.Lock()
+		var err error
+		if m.token != "" && m.needsRefreshLocked() {
+			err = m.renewLocked(m.ctx)
+		}
+		m.mu.Unlock()
+
+		if err != nil {
+			m.logService.Error("主动刷新token失败", zap.Error(err))
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-time.After(m.retryInterval):
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAuthenticator 记录调用的设备认证服务
+type fakeAuthenticator struct {
+	mu         sync.Mutex
+	refreshErr error
+	refreshed  int
+	authed     int
+	ttl        time.Duration
+}
+
+func (a *fakeAuthenticator) RegisterDevice(ctx context.Context, sn, model string) (string, error) {
+	return "device-secret", nil
+}
+
+func (a *fakeAuthenticator) AuthDevice(ctx context.Context, sn, secret string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if secret != "device-secret" {
+		return "", errors.New("invalid secret")
+	}
+	a.authed++
+	return utils.GenerateToken(sn, "cloud", a.ttl)
+}
+
+func (a *fakeAuthenticator) RefreshToken(ctx context.Context, oldToken string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.refreshErr != nil {
+		return "", a.refreshErr
+	}
+	a.refreshed++
+	return utils.GenerateToken("SN001", "cloud", a.ttl)
+}
+
+// newTestCredentials 保存一个指定有效期的token并创建凭证管理器
+func newTestCredentials(t *testing.T, auth DeviceAuthenticator, ttl time.Duration) (*CredentialManager, *DBService, string) {
+	dir := t.TempDir()
+	db, err := NewDBService(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	logService, err := NewLogService("error", filepath.Join(dir, "test.log"))
+	require.NoError(t, err)
+
+	token, err := utils.GenerateToken("SN001", "cloud", ttl)
+	require.NoError(t, err)
+	require.NoError(t, db.SaveMachineInfo(&models.MachineInfo{
+		MachineSN: "SN001", MachineModel: "MD-400", AuthToken: token, DeviceSecret: "device-secret",
+	}))
+	return NewCredentialManager(auth, db, logService), db, token
+}
+
+// TestCredentialManagerRefreshesBeforeExpiry 测试临近过期时主动刷新，新token写入数据库
+func TestCredentialManagerRefreshesBeforeExpiry(t *testing.T) {
+	auth := &fakeAuthenticator{ttl: time.Hour}
+	manager, db, old := newTestCredentials(t, auth, 5*time.Minute)
+
+	token, err := manager.Token(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, old, token)
+	assert.Equal(t, 1, auth.refreshed)
+	assert.Equal(t, 0, auth.authed)
+
+	info, err := db.GetMachineInfo()
+	require.NoError(t, err)
+	assert.Equal(t, token, info.AuthToken)
+
+	// 新token离过期还早，不再刷新
+	again, err := manager.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, token, again)
+	assert.Equal(t, 1, auth.refreshed)
+	assert.InDelta(t, float64(50*time.Minute), float64(manager.nextRefreshDelay()), float64(time.Minute))
+}
+
+// TestCredentialManagerFallsBackToAuth 测试刷新失败时用设备密钥重新认证
+func TestCredentialManagerFallsBackToAuth(t *testing.T) {
+	auth := &fakeAuthenticator{ttl: 2 * time.Hour, refreshErr: errors.New("token revoked")}
+	manager, db, old := newTestCredentials(t, auth, time.Hour)
+
+	token, err := manager.ForceRefresh(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, old, token)
+	assert.Equal(t, 0, auth.refreshed)
+	assert.Equal(t, 1, auth.authed)
+	info, err := db.GetMachineInfo()
+	require.NoError(t, err)
+	assert.Equal(t, token, info.AuthToken)
+}
+
+// TestCredentialManagerClockSkew 测试按服务器时间判断是否需要刷新
+func TestCredentialManagerClockSkew(t *testing.T) {
+	auth := &fakeAuthenticator{ttl: time.Hour}
+	manager, _, old := newTestCredentials(t, auth, 30*time.Minute)
+
+	token, err := manager.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, old, token)
+
+	// 服务器时钟快25分钟，token在服务器看来只剩5分钟
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Date", time.Now().Add(25*time.Minute).UTC().Format(http.TimeFormat))
+	manager.ObserveResponse(resp)
+	assert.InDelta(t, float64(25*time.Minute), float64(manager.clockSkew.Load()), float64(2*time.Second))
+
+	token, err = manager.Token(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, old, token)
+	assert.Equal(t, 1, auth.refreshed)
+}
+
+// TestCredentialManagerCloudRefresh 测试通过云端服务刷新：刷新请求的响应会更新时钟偏差，不能死锁
+func TestCredentialManagerCloudRefresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := utils.GenerateToken("SN001", "cloud", time.Hour)
+		resp := DeviceAuthResponse{}
+		resp.Data.Token = token
+		w.Header().Set("Date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	cloud := NewCloudAIService(server.URL, nil)
+	manager, _, old := newTestCredentials(t, cloud, 5*time.Minute)
+	cloud.SetCredentialManager(manager)
+
+	done := make(chan string, 1)
+	go func() {
+		token, err := manager.Token(context.Background())
+		assert.NoError(t, err)
+		done <- token
+	}()
+	select {
+	case token := <-done:
+		assert.NotEqual(t, old, token)
+	case <-time.After(5 * time.Second):
+		t.Fatal("刷新token时死锁")
+	}
+	assert.InDelta(t, float64(time.Minute), float64(manager.clockSkew.Load()), float64(2*time.Second))
+}
+
+// TestCredentialManagerRedactsCurrentToken 测试多次刷新后脱敏器只保留当前的token，不会无限增长
+func TestCredentialManagerRedactsCurrentToken(t *testing.T) {
+	auth := &fakeAuthenticator{ttl: time.Hour}
+	manager, _, _ := newTestCredentials(t, auth, time.Hour)
+	redactor := manager.logService.Redactor()
+
+	var token string
+	for i := 0; i < 50; i++ {
+		auth.ttl = time.Hour + time.Duration(i)*time.Minute
+		var err error
+		token, err = manager.ForceRefresh(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 50, auth.refreshed)
+
+	redactor.mu.RLock()
+	count := len(redactor.secrets)
+	redactor.mu.RUnlock()
+	// 设备密钥和当前token
+	assert.Equal(t, 2, count)
+	assert.Equal(t, "token "+redactedMark, redactor.Redact("token "+token))
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mingda_ai_helper/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExportFormat 训练数据集的导出格式
+type ExportFormat string
+
+const (
+	ExportYOLO ExportFormat = "yolo" // images/ + labels/*.txt + data.yaml
+	ExportCOCO ExportFormat = "coco" // images/ + annotations.json
+)
+
+// ExportFilter 数据集导出条件
+type ExportFilter struct {
+	Since        time.Time
+	Until        time.Time
+	Printer      string            // 机器序列号或型号，与本机不符时导出为空
+	DefectType   models.DefectType // 为空时不限
+	LabelledOnly bool              // 只导出有用户反馈的结果
+}
+
+// ExportSummary 导出结果统计
+type ExportSummary struct {
+	Images      int `json:"images"`
+	Annotations int `json:"annotations"`
+	Skipped     int `json:"skipped"` // 快照缺失、无法解码或YOLO无法表示的漏报
+}
+
+// ListExportResults 按条件获取要导出的预测结果。每台打印机运行一个助手，
+// 按打印机筛选时与本机的序列号或型号比较
+func ListExportResults(db DBInterface, filter ExportFilter) ([]models.PredictionResult, error) {
+	if filter.Printer != "" {
+		info, err := db.GetMachineInfo()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("获取设备信息失败: %v", err)
+		}
+		if info == nil || (info.MachineSN != filter.Printer && info.MachineModel != filter.Printer) {
+			return nil, nil
+		}
+	}
+	return db.ListExportPredictions(filter)
+}
+
+// exportClasses 导出的类别及编号，编号即在该列表中的位置
+func exportClasses() []models.DefectType {
+	return models.DefectTypes()
+}
+
+// exportSample 一张快照及其标注
+type exportSample struct {
+	result *models.PredictionResult
+	name   string // 压缩包中的文件名
+	width  int
+	height int
+	boxes  []models.Detection
+	// defective 图片级标签：用户反馈优先，否则使用模型判定
+	defective  bool
+	defectType models.DefectType
+}
+
+// labelsOf 生成快照的标注。误报、确认无缺陷以及判定无缺陷且没有反馈确认缺陷的快照作为负样本，
+// 不导出其中的检测框；确认有缺陷且纠正了类型时，检测框改为纠正后的类型
+func labelsOf(result *models.PredictionResult) (boxes []models.Detection, defective bool, defectType models.DefectType) {
+	feedback := result.Feedback
+	switch feedback.Verdict {
+	case models.FeedbackFalsePositive, models.FeedbackTrueNegative:
+		return nil, false, ""
+	case models.FeedbackFalseNegative:
+		return nil, true, feedback.DefectType
+	}
+	if !result.HasDefect && feedback.Verdict != models.FeedbackTruePositive {
+		return nil, false, ""
+	}
+
+	defectType = result.DefectType
+	if feedback.Verdict == models.FeedbackTruePositive && feedback.DefectType != "" {
+		defectType = feedback.DefectType
+	}
+	for _, d := range result.Detections {
+		if len(d.BBox) != 4 {
+			continue
+		}
+		if defectType != result.DefectType && d.Label == string(result.DefectType) {
+			d.Label = string(defectType)
+		}
+		boxes = append(boxes, d)
+	}
+	return boxes, true, defectType
+}
+
+// imageSize 读取图片尺寸，不解码像素
+func imageSize(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// ExportDataset 把快照和标注按指定格式打包为zip写入w
+func ExportDataset(w io.Writer, format ExportFormat, results []models.PredictionResult) (*ExportSummary, error) {
+	if format != ExportYOLO && format != ExportCOCO {
+		return nil, fmt.Errorf("不支持的导出格式: %s", format)
+	}
+
+	summary := &ExportSummary{}
+	var samples []exportSample
+	for i := range results {
+		result := &results[i]
+		width, height, err := imageSize(result.ImagePath)
+		if err != nil {
+			summary.Skipped++
+			continue
+		}
+		boxes, defective, defectType := labelsOf(result)
+		// YOLO只能用检测框表示缺陷，没有框的漏报无法导出
+		if format == ExportYOLO && defective && len(boxes) == 0 {
+			summary.Skipped++
+			continue
+		}
+		samples = append(samples, exportSample{
+			result:     result,
+			name:       result.TaskID + strings.ToLower(filepath.Ext(result.ImagePath)),
+			width:      width,
+			height:     height,
+			boxes:      boxes,
+			defective:  defective,
+			defectType: defectType,
+		})
+	}
+
+	zw := zip.NewWriter(w)
+	for _, sample := range samples {
+		if err := copyToZip(zw, "images/"+sample.name, sample.result.ImagePath); err != nil {
+			return nil, err
+		}
+		summary.Images++
+		summary.Annotations += len(sample.boxes)
+	}
+
+	var err error
+	if format == ExportYOLO {
+		err = writeYOLO(zw, samples)
+	} else {
+		err = writeCOCO(zw, samples)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("写入压缩包失败: %v", err)
+	}
+	return summary, nil
+}
+
+func copyToZip(zw *zip.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("读取快照失败: %v", err)
+	}
+	defer f.Close()
+	dst, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("写入压缩包失败: %v", err)
+	}
+	if _, err := io.Copy(dst, f); err != nil {
+		return fmt.Errorf("写入压缩包失败: %v", err)
+	}
+	return nil
+}
+
+// classIndex 类别编号，无法识别的类别归为unknown
+func classIndex(label string) int {
+	t, ok := models.LookupDefectType(label)
+	if !ok {
+		t = models.DefectUnknown
+	}
+	for i, c := range exportClasses() {
+		if c == t {
+			return i
+		}
+	}
+	return len(exportClasses()) - 1
+}
+
+// writeYOLO 每张图片一个标注文件，每行 "类别 中心x 中心y 宽 高"（相对图片尺寸），负样本为空文件
+func writeYOLO(zw *zip.Writer, samples []exportSample) error {
+	for _, sample := range samples {
+		var b strings.Builder
+		for _, d := range sample.boxes {
+			x1, y1, x2, y2 := d.BBox[0], d.BBox[1], d.BBox[2], d.BBox[3]
+			w, h := float64(sample.width), float64(sample.height)
+			fmt.Fprintf(&b, "%d %.6f %.6f %.6f %.6f\n", classIndex(d.Label),
+				(x1+x2)/2/w, (y1+y2)/2/h, (x2-x1)/w, (y2-y1)/h)
+		}
+		name := "labels/" + strings.TrimSuffix(sample.name, filepath.Ext(sample.name)) + ".txt"
+		f, err := zw.Create(name)
+		if err != nil {
+			return fmt.Errorf("写入压缩包失败: %v", err)
+		}
+		if _, err := io.WriteString(f, b.String()); err != nil {
+			return fmt.Errorf("写入压缩包失败: %v", err)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("path: .\ntrain: images\nval: images\nnames:\n")
+	for i, c := range exportClasses() {
+		fmt.Fprintf(&b, "  %d: %s\n", i, c)
+	}
+	f, err := zw.Create("data.yaml")
+	if err != nil {
+		return fmt.Errorf("写入压缩包失败: %v", err)
+	}
+	_, err = io.WriteString(f, b.String())
+	return err
+}
+
+// cocoImage COCO图片，额外记录图片级标签和用户反馈
+type cocoImage struct {
+	ID         int                    `json:"id"`
+	FileName   string                 `json:"file_name"`
+	Width      int                    `json:"width"`
+	Height     int                    `json:"height"`
+	DateTaken  time.Time              `json:"date_captured"`
+	TaskID     string                 `json:"task_id"`
+	Backend    string                 `json:"backend,omitempty"`
+	Defective  bool                   `json:"defective"`
+	DefectType models.DefectType      `json:"defect_type,omitempty"`
+	Feedback   models.FeedbackVerdict `json:"feedback,omitempty"`
+}
+
+type cocoAnnotation struct {
+	ID         int       `json:"id"`
+	ImageID    int       `json:"image_id"`
+	CategoryID int       `json:"category_id"`
+	BBox       []float64 `json:"bbox"` // [x, y, 宽, 高]，像素
+	Area       float64   `json:"area"`
+	IsCrowd    int       `json:"iscrowd"`
+	Score      float64   `json:"score"` // 模型置信度(0-1)
+}
+
+type cocoCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// writeCOCO 写入annotations.json，类别编号从1开始
+func writeCOCO(zw *zip.Writer, samples []exportSample) error {
+	dataset := struct {
+		Info        map[string]string `json:"info"`
+		Images      []cocoImage       `json:"images"`
+		Annotations []cocoAnnotation  `json:"annotations"`
+		Categories  []cocoCategory    `json:"categories"`
+	}{
+		Info:        map[string]string{"description": "mingda ai helper snapshots", "date_created": time.Now().Format(time.RFC3339)},
+		Images:      []cocoImage{},
+		Annotations: []cocoAnnotation{},
+	}
+	for i, c := range exportClasses() {
+		dataset.Categories = append(dataset.Categories, cocoCategory{ID: i + 1, Name: string(c)})
+	}
+
+	for i, sample := range samples {
+		imageID := i + 1
+		dataset.Images = append(dataset.Images, cocoImage{
+			ID:         imageID,
+			FileName:   sample.name,
+			Width:      sample.width,
+			Height:     sample.height,
+			DateTaken:  sample.result.CreatedAt,
+			TaskID:     sample.result.TaskID,
+			Backend:    sample.result.Backend,
+			Defective:  sample.defective,
+			DefectType: sample.defectType,
+			Feedback:   sample.result.Feedback.Verdict,
+		})
+		for _, d := range sample.boxes {
+			w, h := d.BBox[2]-d.BBox[0], d.BBox[3]-d.BBox[1]
+			dataset.Annotations = append(dataset.Annotations, cocoAnnotation{
+				ID:         len(dataset.Annotations) + 1,
+				ImageID:    imageID,
+				CategoryID: classIndex(d.Label) + 1,
+				BBox:       []float64{d.BBox[0], d.BBox[1], w, h},
+				Area:       w * h,
+				Score:      float64(d.Confidence),
+			})
+		}
+	}
+
+	f, err := zw.Create("annotations.json")
+	if err != nil {
+		return fmt.Errorf("写入压缩包失败: %v", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dataset)
+}
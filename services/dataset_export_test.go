@@ -0,0 +1,193 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestPNG 写入指定尺寸的PNG快照
+func writeTestPNG(t *testing.T, width, height int) string {
+	path := filepath.Join(t.TempDir(), "snapshot.png")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, png.Encode(f, image.NewGray(image.Rect(0, 0, width, height))))
+	return path
+}
+
+// readZip 读取压缩包中的所有文件
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func exportResults(t *testing.T) []models.PredictionResult {
+	snapshot := writeTestPNG(t, 200, 100)
+	now := time.Now()
+	return []models.PredictionResult{
+		{
+			TaskID: "EX001", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.9, ImagePath: snapshot,
+			Detections: models.Detections{{Label: "spaghetti", Confidence: 0.9, BBox: []float64{50, 25, 150, 75}}},
+		},
+		{
+			// 用户纠正为拉丝
+			TaskID: "EX002", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.7, ImagePath: snapshot,
+			Detections: models.Detections{{Label: "spaghetti", Confidence: 0.7, BBox: []float64{0, 0, 100, 100}}},
+			Feedback:   models.PredictionFeedback{Verdict: models.FeedbackTruePositive, DefectType: models.DefectStringing, At: &now},
+		},
+		{
+			// 误报作为负样本
+			TaskID: "EX003", HasDefect: true, DefectType: models.DefectBlob, Confidence: 0.6, ImagePath: snapshot,
+			Detections: models.Detections{{Label: "blob", Confidence: 0.6, BBox: []float64{0, 0, 10, 10}}},
+			Feedback:   models.PredictionFeedback{Verdict: models.FeedbackFalsePositive, At: &now},
+		},
+		{
+			// 漏报没有检测框
+			TaskID: "EX004", HasDefect: false, Confidence: 0.1, ImagePath: snapshot,
+			Feedback: models.PredictionFeedback{Verdict: models.FeedbackFalseNegative, DefectType: models.DefectWarping, At: &now},
+		},
+		{TaskID: "EX005", ImagePath: filepath.Join(t.TempDir(), "missing.jpg")},
+	}
+}
+
+// TestExportYOLO 测试YOLO格式的标注文件和类别编号
+func TestExportYOLO(t *testing.T) {
+	var buf bytes.Buffer
+	summary, err := ExportDataset(&buf, ExportYOLO, exportResults(t))
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.Images)
+	assert.Equal(t, 2, summary.Annotations)
+	assert.Equal(t, 2, summary.Skipped)
+
+	files := readZip(t, buf.Bytes())
+	assert.Contains(t, files, "images/EX001.png")
+	assert.Equal(t, "0 0.500000 0.500000 0.500000 0.500000\n", files["labels/EX001.txt"])
+	assert.Equal(t, "1 0.250000 0.500000 0.500000 1.000000\n", files["labels/EX002.txt"])
+	assert.Equal(t, "", files["labels/EX003.txt"])
+	assert.NotContains(t, files, "labels/EX004.txt")
+	assert.Contains(t, files["data.yaml"], "3: layer_shift")
+}
+
+// TestExportCOCO 测试COCO格式保留图片级标签和漏报
+func TestExportCOCO(t *testing.T) {
+	var buf bytes.Buffer
+	summary, err := ExportDataset(&buf, ExportCOCO, exportResults(t))
+	require.NoError(t, err)
+	assert.Equal(t, 4, summary.Images)
+	assert.Equal(t, 1, summary.Skipped)
+
+	var dataset struct {
+		Images      []cocoImage      `json:"images"`
+		Annotations []cocoAnnotation `json:"annotations"`
+		Categories  []cocoCategory   `json:"categories"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(readZip(t, buf.Bytes())["annotations.json"]), &dataset))
+	require.Len(t, dataset.Images, 4)
+	assert.Equal(t, 200, dataset.Images[0].Width)
+	assert.True(t, dataset.Images[3].Defective)
+	assert.Equal(t, models.DefectWarping, dataset.Images[3].DefectType)
+	assert.False(t, dataset.Images[2].Defective)
+
+	require.Len(t, dataset.Annotations, 2)
+	assert.Equal(t, []float64{50, 25, 100, 50}, dataset.Annotations[0].BBox)
+	assert.Equal(t, 2, dataset.Annotations[1].CategoryID) // stringing
+	assert.Len(t, dataset.Categories, len(models.DefectTypes()))
+
+	_, err = ExportDataset(&buf, "voc", nil)
+	assert.Error(t, err)
+}
+
+// TestExportNegativeFrames 测试判定无缺陷的快照即使有低置信度检测框也作为负样本，除非反馈确认有缺陷
+func TestExportNegativeFrames(t *testing.T) {
+	snapshot := writeTestPNG(t, 200, 100)
+	now := time.Now()
+	weak := models.Detections{{Label: "stringing", Confidence: 0.2, BBox: []float64{0, 0, 100, 100}}}
+	results := []models.PredictionResult{
+		{TaskID: "NEG001", Confidence: 0.2, ImagePath: snapshot, Detections: weak},
+		{
+			TaskID: "NEG002", Confidence: 0.2, ImagePath: snapshot, Detections: weak, DefectType: models.DefectStringing,
+			Feedback: models.PredictionFeedback{Verdict: models.FeedbackTruePositive, At: &now},
+		},
+	}
+
+	var buf bytes.Buffer
+	summary, err := ExportDataset(&buf, ExportYOLO, results)
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Images)
+	assert.Equal(t, 1, summary.Annotations)
+	files := readZip(t, buf.Bytes())
+	assert.Equal(t, "", files["labels/NEG001.txt"])
+	assert.Equal(t, "1 0.250000 0.500000 0.500000 1.000000\n", files["labels/NEG002.txt"])
+
+	buf.Reset()
+	_, err = ExportDataset(&buf, ExportCOCO, results)
+	require.NoError(t, err)
+	var dataset struct {
+		Images      []cocoImage      `json:"images"`
+		Annotations []cocoAnnotation `json:"annotations"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(readZip(t, buf.Bytes())["annotations.json"]), &dataset))
+	require.Len(t, dataset.Images, 2)
+	assert.False(t, dataset.Images[0].Defective)
+	assert.Empty(t, dataset.Images[0].DefectType)
+	assert.True(t, dataset.Images[1].Defective)
+	require.Len(t, dataset.Annotations, 1)
+	assert.Equal(t, 2, dataset.Annotations[0].ImageID)
+}
+
+// TestListExportPredictions 测试按缺陷类型、是否有反馈和打印机筛选
+func TestListExportPredictions(t *testing.T) {
+	_, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	for _, result := range exportResults(t)[:4] {
+		result := result
+		result.PredictionModel = "yolo"
+		result.PredictionStatus = models.StatusCompleted
+		require.NoError(t, db.SavePredictionResult(&result))
+	}
+	filter := ExportFilter{Since: time.Now().Add(-time.Hour), Until: time.Now().Add(time.Hour)}
+
+	results, err := ListExportResults(db, filter)
+	require.NoError(t, err)
+	assert.Len(t, results, 4)
+
+	filter.DefectType = models.DefectStringing
+	results, err = ListExportResults(db, filter)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "EX002", results[0].TaskID)
+
+	filter.DefectType = ""
+	filter.LabelledOnly = true
+	results, err = ListExportResults(db, filter)
+	require.NoError(t, err)
+	assert.Len(t, results, 3)
+
+	// 未注册的设备按打印机筛选时为空
+	filter.Printer = "SN001"
+	results, err = ListExportResults(db, filter)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// datasetMaxAttempts 单个样本最多上传的次数
+const datasetMaxAttempts = 5
+
+var (
+	// ErrDatasetDisabled 配置中未开启训练样本上传
+	ErrDatasetDisabled = errors.New("未开启训练样本上传")
+	// ErrDatasetOptOut 用户未开启数据共享
+	ErrDatasetOptOut = errors.New("用户未开启数据共享")
+)
+
+// DatasetUploader 上传训练样本，由CloudAIService实现并复用设备认证
+type DatasetUploader interface {
+	UploadDatasetSample(ctx context.Context, endpoint, imagePath string, fields map[string]string) error
+}
+
+// DatasetService 把不确定的帧和用户标记的帧加入队列，按每日配额上传到训练数据接口
+type DatasetService struct {
+	cfg        config.DatasetConfig
+	dbService  *DBService
+	uploader   DatasetUploader
+	printer    PrinterController
+	logService *LogService
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDatasetService 创建训练样本服务，配置中未开启时返回nil
+func NewDatasetService(cfg config.DatasetConfig, dbService *DBService, uploader DatasetUploader, printer PrinterController, logService *LogService) *DatasetService {
+	if !cfg.Enabled {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DatasetService{
+		cfg:        cfg,
+		dbService:  dbService,
+		uploader:   uploader,
+		printer:    printer,
+		logService: logService,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// startOfDay 本地时间的当天零点，每日配额按本地日期计算
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// Consider 置信度落在不确定区间的结果加入上传队列。
+// 需要用户开启数据共享且结果有本地快照；启发式检测的置信度没有参考价值，不采样
+func (s *DatasetService) Consider(settings *models.UserSettings, result *models.PredictionResult) {
+	if s == nil || !settings.ShareTrainingData || result.ImagePath == "" || result.PredictionModel == HeuristicModel {
+		return
+	}
+	confidence := float64(result.Confidence)
+	if confidence < s.cfg.UncertainLow || confidence > s.cfg.UncertainHigh {
+		return
+	}
+
+	// 当天排队的不确定样本已达到配额时不再加入，避免队列无限增长
+	queued, err := s.dbService.CountDatasetSamples(models.SampleUncertain, startOfDay(time.Now()))
+	if err != nil {
+		s.logService.Error("统计训练样本失败", zap.Error(err))
+		return
+	}
+	if queued >= int64(s.cfg.DailyQuota) {
+		return
+	}
+
+	if err := s.enqueue(result, models.SampleUncertain, ""); err != nil {
+		s.logService.Error("加入训练样本队列失败", zap.String("task_id", result.TaskID), zap.Error(err))
+	}
+}
+
+// Flag 用户标记的预测结果加入上传队列，优先上传
+func (s *DatasetService) Flag(taskID, note string) (*models.DatasetSample, error) {
+	if s == nil {
+		return nil, ErrDatasetDisabled
+	}
+	settings, err := s.dbService.GetUserSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取用户设置失败: %v", err)
+	}
+	if !settings.ShareTrainingData {
+		return nil, ErrDatasetOptOut
+	}
+
+	result, err := s.dbService.GetPredictionResult(taskID)
+	if err != nil {
+		return nil, fmt.Errorf("获取预测结果失败: %v", err)
+	}
+	if result == nil {
+		return nil, fmt.Errorf("预测任务不存在: %s", taskID)
+	}
+	if result.ImagePath == "" {
+		return nil, fmt.Errorf("预测任务%s没有本地快照", taskID)
+	}
+
+	sample, err := s.newSample(result, models.SampleFlagged, note)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dbService.EnqueueDatasetSample(sample); err != nil {
+		return nil, fmt.Errorf("加入训练样本队列失败: %v", err)
+	}
+	return sample, nil
+}
+
+func (s *DatasetService) enqueue(result *models.PredictionResult, reason models.SampleReason, note string) error {
+	sample, err := s.newSample(result, reason, note)
+	if err != nil {
+		return err
+	}
+	return s.dbService.EnqueueDatasetSample(sample)
+}
+
+// newSample 生成样本，元数据中记录当前的打印信息
+func (s *DatasetService) newSample(result *models.PredictionResult, reason models.SampleReason, note string) (*models.DatasetSample, error) {
+	meta := models.SampleMetadata{
+		Backend:         result.Backend,
+		PredictionModel: result.PredictionModel,
+		HasDefect:       result.HasDefect,
+		DefectType:      result.DefectType,
+		Confidence:      result.Confidence,
+		CapturedAt:      result.CreatedAt,
+	}
+	if meta.CapturedAt.IsZero() {
+		meta.CapturedAt = time.Now()
+	}
+	if info, err := s.dbService.GetMachineInfo(); err == nil && info != nil {
+		meta.MachineModel = info.MachineModel
+	}
+	if status, err := s.printer.GetPrinterStatus(); err == nil && status != nil {
+		meta.Filename = status.PrintStats.Filename
+		meta.Progress = status.VirtualSdcard.Progress
+		meta.PrintDuration = status.PrintStats.PrintDuration
+	}
+
+	return &models.DatasetSample{
+		TaskID:    result.TaskID,
+		ImagePath: result.ImagePath,
+		Reason:    reason,
+		Note:      note,
+		Metadata:  meta,
+	}, nil
+}
+
+// Start 启动上传协程
+func (s *DatasetService) Start() {
+	if s == nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(time.Duration(s.cfg.UploadInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.UploadPending(s.ctx)
+			}
+		}
+	}()
+}
+
+// Stop 停止上传协程
+func (s *DatasetService) Stop() {
+	if s == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+// UploadPending 在当天剩余配额内上传队列中的样本。用户关闭数据共享后不再上传
+func (s *DatasetService) UploadPending(ctx context.Context) {
+	settings, err := s.dbService.GetUserSettings()
+	if err != nil || !settings.ShareTrainingData {
+		return
+	}
+
+	uploaded, err := s.dbService.CountUploadedDatasetSamples(startOfDay(time.Now()))
+	if err != nil {
+		s.logService.Error("统计训练样本失败", zap.Error(err))
+		return
+	}
+	remaining := s.cfg.DailyQuota - int(uploaded)
+	if remaining <= 0 {
+		return
+	}
+
+	samples, err := s.dbService.ListPendingDatasetSamples(remaining)
+	if err != nil {
+		s.logService.Error("获取待上传的训练样本失败", zap.Error(err))
+		return
+	}
+	for i := range samples {
+		if ctx.Err() != nil {
+			return
+		}
+		s.upload(ctx, &samples[i])
+	}
+}
+
+func (s *DatasetService) upload(ctx context.Context, sample *models.DatasetSample) {
+	if _, err := os.Stat(sample.ImagePath); err != nil {
+		sample.Status = models.SampleSkipped
+		sample.LastError = "快照文件不存在"
+		s.saveStatus(sample)
+		return
+	}
+
+	metadata, err := sample.Metadata.Value()
+	if err != nil {
+		s.logService.Error("序列化样本元数据失败", zap.Error(err))
+		return
+	}
+	fields := map[string]string{
+		"task_id":  sample.TaskID,
+		"reason":   string(sample.Reason),
+		"note":     sample.Note,
+		"metadata": metadata.(string),
+	}
+
+	sample.Attempts++
+	if err := s.uploader.UploadDatasetSample(ctx, s.cfg.Endpoint, sample.ImagePath, fields); err != nil {
+		sample.LastError = truncate(err.Error(), 255)
+		if sample.Attempts >= datasetMaxAttempts {
+			sample.Status = models.SampleFailed
+		}
+		s.logService.Error("上传训练样本失败",
+			zap.String("task_id", sample.TaskID),
+			zap.Int("attempts", sample.Attempts),
+			zap.Error(err))
+		s.saveStatus(sample)
+		return
+	}
+
+	now := time.Now()
+	sample.Status = models.SampleUploaded
+	sample.UploadedAt = &now
+	sample.LastError = ""
+	s.saveStatus(sample)
+	s.logService.Info("已上传训练样本", zap.String("task_id", sample.TaskID), zap.String("reason", string(sample.Reason)))
+}
+
+func (s *DatasetService) saveStatus(sample *models.DatasetSample) {
+	if err := s.dbService.UpdateDatasetSampleStatus(sample); err != nil {
+		s.logService.Error("更新训练样本状态失败", zap.Error(err))
+	}
+}
+
+// DatasetStatus 上传队列和当天配额
+type DatasetStatus struct {
+	Enabled       bool  `json:"enabled"`
+	Share         bool  `json:"share_training_data"`
+	DailyQuota    int   `json:"daily_quota"`
+	UploadedToday int64 `json:"uploaded_today"`
+	Pending       int64 `json:"pending"`
+}
+
+// Status 获取上传队列状态
+func (s *DatasetService) Status() (*DatasetStatus, error) {
+	if s == nil {
+		return &DatasetStatus{}, nil
+	}
+	settings, err := s.dbService.GetUserSettings()
+	if err != nil {
+		return nil, fmt.Errorf("获取用户设置失败: %v", err)
+	}
+	uploaded, err := s.dbService.CountUploadedDatasetSamples(startOfDay(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.dbService.CountPendingDatasetSamples()
+	if err != nil {
+		return nil, err
+	}
+	return &DatasetStatus{
+		Enabled:       true,
+		Share:         settings.ShareTrainingData,
+		DailyQuota:    s.cfg.DailyQuota,
+		UploadedToday: uploaded,
+		Pending:       pending,
+	}, nil
+}
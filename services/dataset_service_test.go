@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUploader 记录上传的样本
+type fakeUploader struct {
+	fail    bool
+	uploads []map[string]string
+}
+
+func (u *fakeUploader) UploadDatasetSample(ctx context.Context, endpoint, imagePath string, fields map[string]string) error {
+	if u.fail {
+		return errors.New("upload failed")
+	}
+	u.uploads = append(u.uploads, fields)
+	return nil
+}
+
+// newTestDatasetService 创建开启数据共享的训练样本服务和一张临时快照
+func newTestDatasetService(t *testing.T, quota int) (*DatasetService, *PredictionProcessor, *fakeUploader, *DBService, string) {
+	processor, printer, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80, ShareTrainingData: true})
+	uploader := &fakeUploader{}
+	dataset := NewDatasetService(config.DatasetConfig{
+		Enabled: true, Endpoint: "/device/dataset/upload",
+		UncertainLow: 0.35, UncertainHigh: 0.65, DailyQuota: quota, UploadInterval: 60,
+	}, db, uploader, printer, processor.logService.(*LogService))
+	processor.SetDatasetService(dataset)
+
+	image := filepath.Join(t.TempDir(), "snapshot.jpg")
+	require.NoError(t, os.WriteFile(image, []byte("jpeg"), 0644))
+	return dataset, processor, uploader, db, image
+}
+
+// TestDatasetSamplesUncertainFrames 测试不确定区间内的帧按配额加入队列并上传
+func TestDatasetSamplesUncertainFrames(t *testing.T) {
+	dataset, processor, uploader, db, image := newTestDatasetService(t, 2)
+
+	for i, confidence := range []models.Confidence{0.9, 0.5, 0.4, 0.6, 0.1} {
+		require.NoError(t, processor.Process(&models.PredictionResult{
+			TaskID: "PT00" + string(rune('1'+i)), PredictionModel: "yolo", HasDefect: confidence > 0.45,
+			DefectType: models.DefectSpaghetti, Confidence: confidence, ImagePath: image,
+		}))
+	}
+	// 0.5和0.4在区间内，0.6因为当天配额已满不再加入
+	pending, err := db.CountPendingDatasetSamples()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pending)
+
+	dataset.UploadPending(context.Background())
+	require.Len(t, uploader.uploads, 2)
+	assert.Equal(t, "PT002", uploader.uploads[0]["task_id"])
+	assert.Equal(t, "uncertain", uploader.uploads[0]["reason"])
+	assert.Contains(t, uploader.uploads[0]["metadata"], `"prediction_model":"yolo"`)
+
+	// 当天上传配额已用完
+	_, err = dataset.Flag("PT004", "误报")
+	require.NoError(t, err)
+	dataset.UploadPending(context.Background())
+	assert.Len(t, uploader.uploads, 2)
+}
+
+// TestDatasetFlagRequiresOptIn 测试用户标记需要开启数据共享，标记的样本优先上传
+func TestDatasetFlagRequiresOptIn(t *testing.T) {
+	dataset, _, uploader, db, image := newTestDatasetService(t, 10)
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{
+		TaskID: "PT001", PredictionStatus: models.StatusCompleted, PredictionModel: "yolo", ImagePath: image,
+	}))
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{
+		TaskID: "PT002", PredictionStatus: models.StatusCompleted, PredictionModel: "yolo",
+	}))
+
+	_, err := dataset.Flag("PT002", "")
+	assert.Error(t, err)
+	_, err = dataset.Flag("PT404", "")
+	assert.Error(t, err)
+
+	sample, err := dataset.Flag("PT001", "漏报")
+	require.NoError(t, err)
+	assert.Equal(t, models.SampleFlagged, sample.Reason)
+
+	require.NoError(t, db.SaveUserSettings(&models.UserSettings{EnableAI: true, ConfidenceThreshold: 80}))
+	_, err = dataset.Flag("PT001", "")
+	assert.True(t, errors.Is(err, ErrDatasetOptOut))
+	// 关闭共享后不再上传
+	dataset.UploadPending(context.Background())
+	assert.Empty(t, uploader.uploads)
+
+	var disabled *DatasetService
+	_, err = disabled.Flag("PT001", "")
+	assert.True(t, errors.Is(err, ErrDatasetDisabled))
+}
+
+// TestDatasetUploadFailures 测试上传失败重试和快照缺失
+func TestDatasetUploadFailures(t *testing.T) {
+	dataset, _, uploader, db, image := newTestDatasetService(t, 10)
+	for _, taskID := range []string{"PT001", "PT002"} {
+		require.NoError(t, db.SavePredictionResult(&models.PredictionResult{
+			TaskID: taskID, PredictionStatus: models.StatusCompleted, PredictionModel: "yolo", ImagePath: image,
+		}))
+		_, err := dataset.Flag(taskID, "")
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.DB().Model(&models.DatasetSample{}).Where("task_id = ?", "PT002").
+		Update("image_path", filepath.Join(t.TempDir(), "missing.jpg")).Error)
+
+	uploader.fail = true
+	for i := 0; i < datasetMaxAttempts; i++ {
+		dataset.UploadPending(context.Background())
+	}
+
+	var samples []models.DatasetSample
+	require.NoError(t, db.DB().Order("task_id").Find(&samples).Error)
+	require.Len(t, samples, 2)
+	assert.Equal(t, models.SampleFailed, samples[0].Status)
+	assert.Equal(t, datasetMaxAttempts, samples[0].Attempts)
+	assert.Equal(t, models.SampleSkipped, samples[1].Status)
+}
+
+// TestDatasetSamplesAsyncFrames 测试异步后端通过回调返回的结果同样带有快照路径，可以采样和标记
+func TestDatasetSamplesAsyncFrames(t *testing.T) {
+	dataset, processor, _, db, image := newTestDatasetService(t, 10)
+
+	// T1沿用提交的任务ID，T2由后端生成新的任务ID
+	ai := &fakeQueueAI{predict: func(ctx context.Context, taskID string) (*models.PredictionResult, error) {
+		if taskID == "T2" {
+			return &models.PredictionResult{TaskID: "REMOTE-T2", PredictionStatus: models.StatusProcessing}, nil
+		}
+		return &models.PredictionResult{TaskID: taskID, PredictionStatus: models.StatusProcessing}, nil
+	}}
+	registry := NewBackendRegistry(NewHealthMonitor(processor.logService))
+	registry.Add("local", "http-json", InputURL, nil, ai, "")
+	processor.backends = registry
+	queue := NewPredictionQueue(config.QueueConfig{JobTimeout: 1, CallbackTimeout: 60}, registry, processor, db, processor.logService)
+	processor.SetPredictionQueue(queue)
+
+	for _, taskID := range []string{"T1", "T2"} {
+		_, err := queue.Submit(PredictionRequest{TaskID: taskID, ImageURL: "http://camera/snapshot", ImagePath: image, Priority: models.PriorityPeriodic})
+		require.NoError(t, err)
+		require.True(t, queue.RunNext(context.Background(), "local"))
+	}
+
+	// 回调只带任务ID和判定
+	for _, taskID := range []string{"T1", "REMOTE-T2"} {
+		require.NoError(t, processor.Process(&models.PredictionResult{
+			TaskID: taskID, PredictionModel: "yolo", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.5,
+		}))
+		result, err := db.GetPredictionResult(taskID)
+		require.NoError(t, err)
+		assert.Equal(t, image, result.ImagePath, taskID)
+		assert.Equal(t, "local", result.Backend, taskID)
+	}
+
+	pending, err := db.ListPendingDatasetSamples(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.ElementsMatch(t, []string{"T1", "REMOTE-T2"}, []string{pending[0].TaskID, pending[1].TaskID})
+	assert.Equal(t, image, pending[0].ImagePath)
+
+	sample, err := dataset.Flag("REMOTE-T2", "漏检")
+	require.NoError(t, err)
+	assert.Equal(t, image, sample.ImagePath)
+}
@@ -2,16 +2,25 @@ package services
 
 import (
 	"errors"
+	"fmt"
 	"mingda_ai_helper/models"
+	"mingda_ai_helper/utils"
 	"gorm.io/gorm"
 	"gorm.io/driver/sqlite"
+	"sort"
+	"strings"
 	"time"
 )
 
 type DBService struct {
 	db *gorm.DB
+	// 敏感字段加密器，为nil时以明文存储
+	cipher *utils.SecretCipher
 }
 
+// machineSecretColumns machine_info表中需要加密存储的字段
+var machineSecretColumns = []string{"auth_token", "device_secret"}
+
 func NewDBService(dbPath string) (*DBService, error) {
 	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
 	if err != nil {
@@ -27,12 +36,52 @@ func NewDBService(dbPath string) (*DBService, error) {
 }
 
 func (s *DBService) initTables() error {
+	if err := s.migratePredictionStatusCheck(); err != nil {
+		return fmt.Errorf("迁移prediction_status约束失败: %v", err)
+	}
+
 	// 自动迁移表结构
-	return s.db.AutoMigrate(
+	if err := s.db.AutoMigrate(
 		&models.MachineInfo{},
 		&models.UserSettings{},
 		&models.PredictionResult{},
-	)
+		&models.DefectPolicy{},
+		&models.ModelComparison{},
+		&models.ModelDisagreement{},
+		&models.DatasetSample{},
+		&models.PrintSession{},
+		&models.PrinterAction{},
+		&models.ResultDelivery{},
+		&models.DeliveryAttempt{},
+		&models.PredictionJob{},
+	); err != nil {
+		return err
+	}
+	return s.seedDefectPolicies()
+}
+
+// migratePredictionStatusCheck 旧版本的prediction_status约束只允许0-2，删除后由AutoMigrate按新的取值重建
+func (s *DBService) migratePredictionStatusCheck() error {
+	var ddl string
+	err := s.db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", models.PredictionResult{}.TableName()).
+		Scan(&ddl).Error
+	if err != nil || !strings.Contains(ddl, "prediction_status IN (0, 1, 2))") {
+		return err
+	}
+	return s.db.Migrator().DropConstraint(&models.PredictionResult{}, "chk_prediction_results_prediction_status")
+}
+
+// seedDefectPolicies 首次启动时写入默认的缺陷处理策略
+func (s *DBService) seedDefectPolicies() error {
+	var count int64
+	if err := s.db.Model(&models.DefectPolicy{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	policies := models.DefaultDefectPolicies()
+	return s.db.Create(&policies).Error
 }
 
 func (s *DBService) Close() error {
@@ -47,6 +96,81 @@ func (s *DBService) DB() *gorm.DB {
 	return s.db
 }
 
+// EnableSecretEncryption 启用敏感字段加密，并把已有的明文数据迁移为密文
+func (s *DBService) EnableSecretEncryption(cipher *utils.SecretCipher) error {
+	s.cipher = cipher
+	return s.reencryptSecrets(s.db, cipher)
+}
+
+// RotateSecretKey 生成新密钥，用新密钥重新加密所有敏感字段后替换密钥文件
+func (s *DBService) RotateSecretKey(keyFile string) error {
+	if s.cipher == nil {
+		return fmt.Errorf("未启用敏感字段加密")
+	}
+
+	rotated, err := utils.PrepareKeyRotation(keyFile, s.cipher)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		return s.reencryptSecrets(tx, rotated)
+	}); err != nil {
+		return fmt.Errorf("重新加密敏感字段失败: %v", err)
+	}
+	if err := utils.CommitKeyRotation(keyFile); err != nil {
+		return err
+	}
+
+	s.cipher = rotated
+	return nil
+}
+
+// reencryptSecrets 用指定加密器重写所有敏感字段（明文会被加密，旧密钥的密文会被换成新密钥）
+func (s *DBService) reencryptSecrets(tx *gorm.DB, cipher *utils.SecretCipher) error {
+	var rows []models.MachineInfo
+	if err := tx.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		values := map[string]string{
+			"auth_token":    row.AuthToken,
+			"device_secret": row.DeviceSecret,
+		}
+		updates := map[string]interface{}{}
+		for _, column := range machineSecretColumns {
+			encrypted, err := cipher.Encrypt(values[column])
+			if err != nil {
+				return fmt.Errorf("加密%s失败: %v", column, err)
+			}
+			if encrypted != values[column] {
+				updates[column] = encrypted
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := tx.Model(&models.MachineInfo{}).Where("id = ?", row.ID).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DBService) encryptSecret(value string) (string, error) {
+	if s.cipher == nil {
+		return value, nil
+	}
+	return s.cipher.Encrypt(value)
+}
+
+func (s *DBService) decryptSecret(value string) (string, error) {
+	if s.cipher == nil {
+		return value, nil
+	}
+	return s.cipher.Decrypt(value)
+}
+
 // 机器信息相关操作
 func (s *DBService) GetMachineInfo() (*models.MachineInfo, error) {
 	var info models.MachineInfo
@@ -54,22 +178,48 @@ func (s *DBService) GetMachineInfo() (*models.MachineInfo, error) {
 	if result.Error != nil {
 		return nil, result.Error
 	}
+
+	var err error
+	if info.AuthToken, err = s.decryptSecret(info.AuthToken); err != nil {
+		return nil, fmt.Errorf("解密auth_token失败: %v", err)
+	}
+	if info.DeviceSecret, err = s.decryptSecret(info.DeviceSecret); err != nil {
+		return nil, fmt.Errorf("解密device_secret失败: %v", err)
+	}
 	return &info, nil
 }
 
 func (s *DBService) SaveMachineInfo(info *models.MachineInfo) error {
+	// 加密副本，避免修改调用方持有的明文
+	stored := *info
+	var err error
+	if stored.AuthToken, err = s.encryptSecret(info.AuthToken); err != nil {
+		return err
+	}
+	if stored.DeviceSecret, err = s.encryptSecret(info.DeviceSecret); err != nil {
+		return err
+	}
+
 	var count int64
 	s.db.Model(&models.MachineInfo{}).Count(&count)
 	if count > 0 {
-		return s.db.Model(&models.MachineInfo{}).Updates(info).Error
+		return s.db.Model(&models.MachineInfo{}).Where("1 = 1").Updates(&stored).Error
+	}
+	if err := s.db.Create(&stored).Error; err != nil {
+		return err
 	}
-	return s.db.Create(info).Error
+	info.Model = stored.Model
+	return nil
 }
 
 func (s *DBService) UpdateMachineToken(machineSN string, newToken string) error {
+	encrypted, err := s.encryptSecret(newToken)
+	if err != nil {
+		return err
+	}
 	return s.db.Model(&models.MachineInfo{}).
 		Where("machine_sn = ?", machineSN).
-		Update("auth_token", newToken).Error
+		Update("auth_token", encrypted).Error
 }
 
 // 用户设置相关操作
@@ -92,6 +242,8 @@ func (s *DBService) SaveUserSettings(settings *models.UserSettings) error {
 			"enable_cloud_ai":       settings.EnableCloudAI,
 			"confidence_threshold":   settings.ConfidenceThreshold,
 			"pause_on_threshold":    settings.PauseOnThreshold,
+			"shadow_mode":           settings.ShadowMode,
+			"share_training_data":   settings.ShareTrainingData,
 		}).Error
 	} else if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		// 如果记录不存在，创建新记录
@@ -100,6 +252,8 @@ func (s *DBService) SaveUserSettings(settings *models.UserSettings) error {
 			EnableCloudAI:       settings.EnableCloudAI,
 			ConfidenceThreshold: settings.ConfidenceThreshold,
 			PauseOnThreshold:   settings.PauseOnThreshold,
+			ShadowMode:          settings.ShadowMode,
+			ShareTrainingData:   settings.ShareTrainingData,
 		}
 		return s.db.Create(newSettings).Error
 	}
@@ -107,6 +261,52 @@ func (s *DBService) SaveUserSettings(settings *models.UserSettings) error {
 	return result.Error
 }
 
+// UpdatePredictionSource 记录异步任务使用的后端、所属打印任务和快照，回调时按该后端换算置信度
+func (s *DBService) UpdatePredictionSource(taskID, backend, session, imagePath string) error {
+	return s.db.Model(&models.PredictionResult{}).
+		Where("task_id = ?", taskID).
+		Updates(map[string]interface{}{"backend": backend, "session": session, "image_path": imagePath}).Error
+}
+
+// 缺陷处理策略相关操作
+func (s *DBService) GetDefectPolicies() ([]models.DefectPolicy, error) {
+	var policies []models.DefectPolicy
+	if err := s.db.Order("id").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// SaveDefectPolicies 按缺陷类型新增或更新策略
+func (s *DBService) SaveDefectPolicies(policies []models.DefectPolicy) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, policy := range policies {
+			var existing models.DefectPolicy
+			err := tx.Where("defect_type = ?", policy.DefectType).First(&existing).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				p := policy
+				p.ID = 0
+				if err := tx.Create(&p).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&existing).Updates(map[string]interface{}{
+				"enabled":          policy.Enabled,
+				"threshold":        policy.Threshold,
+				"consecutive_hits": policy.ConsecutiveHits,
+				"action":           policy.Action,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // 预测结果相关操作
 func (s *DBService) GetPredictionResult(taskID string) (*models.PredictionResult, error) {
 	var result models.PredictionResult
@@ -137,6 +337,11 @@ func (s *DBService) SavePredictionResult(result *models.PredictionResult) error
 					"has_defect":      result.HasDefect,
 					"defect_type":     result.DefectType,
 					"confidence":      result.Confidence,
+					"detections":      result.Detections,
+					"backend":         gorm.Expr("COALESCE(NULLIF(?, ''), backend)", result.Backend),
+					"image_path":      gorm.Expr("COALESCE(NULLIF(?, ''), image_path)", result.ImagePath),
+					"session":         gorm.Expr("COALESCE(NULLIF(?, ''), session)", result.Session),
+					"latency_ms":      result.LatencyMs,
 					"updated_at":      time.Now(),
 				}).Error
 		}
@@ -150,6 +355,74 @@ func (s *DBService) SavePredictionResult(result *models.PredictionResult) error
 	return err
 }
 
+// UpdatePredictionAction 记录策略判定的动作，shadow表示影子模式下只记录未执行
+func (s *DBService) UpdatePredictionAction(taskID string, action models.PolicyAction, shadow bool) error {
+	return s.db.Model(&models.PredictionResult{}).
+		Where("task_id = ?", taskID).
+		Updates(map[string]interface{}{"action": action, "shadow": shadow}).Error
+}
+
+// MigrateDefectTypes 把旧版本保存的自由格式defect_type映射为统一的缺陷类型。
+// 按产生结果的后端选择映射（mappers中没有的后端只使用内置别名），无法识别的记为unknown。
+// 映射后的值都是统一类型，再次执行不会修改任何记录
+func (s *DBService) MigrateDefectTypes(mappers map[string]*DefectMapper) (int64, error) {
+	valid := make([]string, 0, len(models.DefectTypes()))
+	for _, t := range models.DefectTypes() {
+		valid = append(valid, string(t))
+	}
+
+	var rows []struct {
+		Backend    string
+		DefectType string
+	}
+	err := s.db.Model(&models.PredictionResult{}).
+		Distinct("COALESCE(backend, '') AS backend", "defect_type").
+		Where("defect_type <> '' AND defect_type NOT IN ?", valid).
+		Scan(&rows).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var migrated int64
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			target := mappers[row.Backend].Map(row.DefectType)
+			result := tx.Model(&models.PredictionResult{}).
+				Where("COALESCE(backend, '') = ? AND defect_type = ?", row.Backend, row.DefectType).
+				UpdateColumn("defect_type", target)
+			if result.Error != nil {
+				return result.Error
+			}
+			migrated += result.RowsAffected
+		}
+		return nil
+	})
+	return migrated, err
+}
+
+// ListCompletedPredictions 按时间顺序获取一段时间内已完成的预测结果，用于策略回放
+func (s *DBService) ListCompletedPredictions(since, until time.Time) ([]models.PredictionResult, error) {
+	var results []models.PredictionResult
+	err := s.db.Where("prediction_status = ? AND created_at >= ? AND created_at < ?", models.StatusCompleted, since, until).
+		Order("created_at, id").
+		Find(&results).Error
+	return results, err
+}
+
+// SavePredictionFeedback 保存用户对预测结果的反馈，重复提交时覆盖
+func (s *DBService) SavePredictionFeedback(taskID string, feedback *models.PredictionFeedback) error {
+	now := time.Now()
+	feedback.At = &now
+	return s.db.Model(&models.PredictionResult{}).
+		Where("task_id = ?", taskID).
+		Updates(map[string]interface{}{
+			"feedback_verdict":     feedback.Verdict,
+			"feedback_defect_type": feedback.DefectType,
+			"feedback_note":        feedback.Note,
+			"feedback_at":          feedback.At,
+		}).Error
+}
+
 func (s *DBService) UpdatePredictionStatus(taskID string, status models.PredictionStatus) error {
 	return s.db.Model(&models.PredictionResult{}).
 		Where("task_id = ?", taskID).
@@ -162,6 +435,588 @@ func (s *DBService) ListPredictionResults(limit int) ([]models.PredictionResult,
 	return results, err
 }
 
+// ListPredictions 按条件分页获取预测历史，返回下一页的游标，没有下一页时为空
+func (s *DBService) ListPredictions(q PredictionQuery) ([]models.PredictionResult, string, error) {
+	query := s.db.Model(&models.PredictionResult{})
+	if !q.Since.IsZero() {
+		query = query.Where("created_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		query = query.Where("created_at < ?", q.Until)
+	}
+	if q.HasDefect != nil {
+		query = query.Where("has_defect = ?", *q.HasDefect)
+	}
+	if q.DefectType != "" {
+		query = query.Where("defect_type = ?", q.DefectType)
+	}
+	if q.Backend != "" {
+		query = query.Where("backend = ?", q.Backend)
+	}
+	if q.Status != nil {
+		query = query.Where("prediction_status = ?", *q.Status)
+	}
+	if q.Session != "" {
+		query = query.Where("session = ?", q.Session)
+	}
+
+	cmp, dir := "<", "desc"
+	if q.Asc {
+		cmp, dir = ">", "asc"
+	}
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if q.Sort == SortByConfidence {
+			query = query.Where(fmt.Sprintf("ROUND(confidence, 6) %s ? OR (ROUND(confidence, 6) = ? AND id %s ?)", cmp, cmp),
+				cursor.Confidence, cursor.Confidence, cursor.ID)
+		} else {
+			query = query.Where(fmt.Sprintf("id %s ?", cmp), cursor.ID)
+		}
+	}
+	// 记录按创建顺序写入，按时间排序即按id排序
+	if q.Sort == SortByConfidence {
+		query = query.Order(fmt.Sprintf("ROUND(confidence, 6) %s, id %s", dir, dir))
+	} else {
+		query = query.Order("id " + dir)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPredictionLimit
+	}
+	var results []models.PredictionResult
+	if err := query.Limit(limit + 1).Find(&results).Error; err != nil {
+		return nil, "", err
+	}
+	if len(results) <= limit {
+		return results, "", nil
+	}
+	results = results[:limit]
+	last := results[limit-1]
+	next := predictionCursor{ID: last.ID}
+	if q.Sort == SortByConfidence {
+		next.Confidence = cursorConfidence(last.Confidence)
+	}
+	return results, encodeCursor(next), nil
+}
+
 func (s *DBService) DeletePredictionResult(taskID string) error {
 	return s.db.Where("task_id = ?", taskID).Delete(&models.PredictionResult{}).Error
-} 
\ No newline at end of file
+} 
+
+// 模型对比相关操作
+
+// DisagreementFilter 分歧记录查询条件
+type DisagreementFilter struct {
+	Since time.Time
+	Until time.Time
+	Kind  models.DisagreementKind // 为空时不限
+	Limit int                     // 为0时不限
+}
+
+// SaveModelComparison 保存对比结果，kind不为空时同时记录分歧
+func (s *DBService) SaveModelComparison(comparison *models.ModelComparison, kind models.DisagreementKind) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		comparison.Agree = kind == ""
+		if err := tx.Create(comparison).Error; err != nil {
+			return err
+		}
+		if kind == "" {
+			return nil
+		}
+		return tx.Create(&models.ModelDisagreement{ComparisonID: comparison.ID, Kind: kind}).Error
+	})
+}
+
+// ListModelDisagreements 按时间倒序获取分歧记录及对应的两个判定
+func (s *DBService) ListModelDisagreements(filter DisagreementFilter) ([]models.ModelDisagreement, error) {
+	query := s.db.Preload("Comparison").
+		Where("created_at >= ? AND created_at < ?", filter.Since, filter.Until)
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var disagreements []models.ModelDisagreement
+	err := query.Order("created_at desc, id desc").Find(&disagreements).Error
+	return disagreements, err
+}
+
+// CountModelComparisons 统计一段时间内的对比次数和一致次数
+func (s *DBService) CountModelComparisons(since, until time.Time) (total, agreed int64, err error) {
+	query := func() *gorm.DB {
+		return s.db.Model(&models.ModelComparison{}).Where("created_at >= ? AND created_at < ?", since, until)
+	}
+	if err = query().Count(&total).Error; err != nil {
+		return 0, 0, err
+	}
+	err = query().Where("agree = ?", true).Count(&agreed).Error
+	return total, agreed, err
+}
+
+// 打印任务相关操作
+
+// StartPrintSession 记录开始的打印任务，已存在时忽略
+func (s *DBService) StartPrintSession(session *models.PrintSession) error {
+	session.State = models.SessionPrinting
+	return s.db.Where("session = ?", session.Session).FirstOrCreate(session).Error
+}
+
+// FinishPrintSession 记录打印任务的结束状态，已结束的任务不再更新
+func (s *DBService) FinishPrintSession(session string, state models.PrintSessionState, endedAt time.Time) error {
+	return s.db.Model(&models.PrintSession{}).
+		Where("session = ? AND state = ?", session, models.SessionPrinting).
+		Updates(map[string]interface{}{"state": state, "ended_at": endedAt}).Error
+}
+
+// 打印控制记录相关操作
+
+// SavePrinterAction 记录一次打印控制操作
+func (s *DBService) SavePrinterAction(action *models.PrinterAction) error {
+	return s.db.Create(action).Error
+}
+
+// ListPrinterActions 获取最近的打印控制操作
+func (s *DBService) ListPrinterActions(limit int) ([]models.PrinterAction, error) {
+	var actions []models.PrinterAction
+	err := s.db.Order("id desc").Limit(limit).Find(&actions).Error
+	return actions, err
+}
+
+// 结果推送相关操作
+
+// CreateResultDelivery 登记预测完成后要推送的地址
+func (s *DBService) CreateResultDelivery(delivery *models.ResultDelivery) error {
+	return s.db.Create(delivery).Error
+}
+
+// ActivateResultDeliveries 预测完成后把等待中的推送加入发送队列
+func (s *DBService) ActivateResultDeliveries(taskID string, at time.Time) (int64, error) {
+	result := s.db.Model(&models.ResultDelivery{}).
+		Where("task_id = ? AND status = ?", taskID, models.DeliveryWaiting).
+		Updates(map[string]interface{}{"status": models.DeliveryPending, "next_attempt_at": at})
+	return result.RowsAffected, result.Error
+}
+
+// ListDueResultDeliveries 获取到达发送时间的推送
+func (s *DBService) ListDueResultDeliveries(now time.Time, limit int) ([]models.ResultDelivery, error) {
+	var deliveries []models.ResultDelivery
+	err := s.db.Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// RecordDeliveryAttempt 保存一次推送请求及推送的最新状态
+func (s *DBService) RecordDeliveryAttempt(delivery *models.ResultDelivery, attempt *models.DeliveryAttempt) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		attempt.DeliveryID = delivery.ID
+		if err := tx.Create(attempt).Error; err != nil {
+			return err
+		}
+		return tx.Model(delivery).Updates(map[string]interface{}{
+			"status":           delivery.Status,
+			"attempts":         delivery.Attempts,
+			"next_attempt_at":  delivery.NextAttemptAt,
+			"last_status_code": delivery.LastStatusCode,
+			"last_error":       delivery.LastError,
+			"delivered_at":     delivery.DeliveredAt,
+		}).Error
+	})
+}
+
+// ListResultDeliveries 获取预测任务的推送记录及每次请求
+func (s *DBService) ListResultDeliveries(taskID string) ([]models.ResultDelivery, error) {
+	var deliveries []models.ResultDelivery
+	err := s.db.Where("task_id = ?", taskID).
+		Preload("History", func(db *gorm.DB) *gorm.DB { return db.Order("attempt") }).
+		Order("id").
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// 预测队列相关操作
+
+// EnqueuePredictionJob 保存等待处理的预测任务并创建预测记录，callbackURL不为空时同时登记结果推送
+func (s *DBService) EnqueuePredictionJob(job *models.PredictionJob, callbackURL string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.PredictionResult{}).Where("task_id = ?", job.TaskID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTaskExists
+		}
+
+		job.Status, job.SubmittedID = models.StatusPending, job.TaskID
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.PredictionResult{
+			TaskID:           job.TaskID,
+			PredictionStatus: models.StatusPending,
+			Backend:          job.Backend,
+			ImagePath:        job.ImagePath,
+			Session:          job.Session,
+		}).Error; err != nil {
+			return err
+		}
+		if callbackURL == "" {
+			return nil
+		}
+		return tx.Create(&models.ResultDelivery{
+			TaskID: job.TaskID,
+			URL:    callbackURL,
+			Status: models.DeliveryWaiting,
+		}).Error
+	})
+}
+
+// GetPredictionJob 按任务ID或提交时的任务ID获取队列任务，不存在时返回nil
+func (s *DBService) GetPredictionJob(taskID string) (*models.PredictionJob, error) {
+	var jobs []models.PredictionJob
+	if err := s.db.Where("task_id = ? OR submitted_id = ?", taskID, taskID).
+		Order("id desc").
+		Limit(1).
+		Find(&jobs).Error; err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// ClaimPredictionJob 取出后端优先级最高的等待任务并标记为处理中，没有任务时返回nil
+func (s *DBService) ClaimPredictionJob(backend string, now time.Time) (*models.PredictionJob, error) {
+	var jobs []models.PredictionJob
+	if err := s.db.Where("backend = ? AND status = ?", backend, models.StatusPending).
+		Order("priority desc, id").
+		Limit(1).
+		Find(&jobs).Error; err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	job := &jobs[0]
+
+	claimed := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// 其他worker可能已取走该任务
+		result := tx.Model(job).Where("status = ?", models.StatusPending).Updates(map[string]interface{}{
+			"status":     models.StatusProcessing,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"started_at": now,
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		claimed = true
+		return tx.Model(&models.PredictionResult{}).
+			Where("task_id = ? AND prediction_status = ?", job.TaskID, models.StatusPending).
+			Update("prediction_status", models.StatusProcessing).Error
+	})
+	if err != nil || !claimed {
+		return nil, err
+	}
+	job.Status, job.StartedAt = models.StatusProcessing, &now
+	job.Attempts++
+	return job, nil
+}
+
+// FinishPredictionJob 结束未完成的任务。失败和超时同时更新预测记录的状态；已结束的任务不变，返回false
+func (s *DBService) FinishPredictionJob(taskID string, status models.PredictionStatus, message string, now time.Time) (bool, error) {
+	finished := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.PredictionJob{}).
+			Where("task_id = ? AND status IN ?", taskID, []models.PredictionStatus{models.StatusPending, models.StatusProcessing}).
+			Updates(map[string]interface{}{"status": status, "error": message, "finished_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		finished = true
+		if status == models.StatusCompleted {
+			return nil
+		}
+		return tx.Model(&models.PredictionResult{}).
+			Where("task_id = ? AND prediction_status IN ?", taskID, []models.PredictionStatus{models.StatusPending, models.StatusProcessing}).
+			Update("prediction_status", status).Error
+	})
+	return finished, err
+}
+
+// DispatchPredictionJob 异步后端已受理请求，记录等待回调的截止时间
+func (s *DBService) DispatchPredictionJob(taskID string, at, deadline time.Time) error {
+	return s.db.Model(&models.PredictionJob{}).
+		Where("task_id = ? AND status = ?", taskID, models.StatusProcessing).
+		Updates(map[string]interface{}{"dispatched_at": at, "deadline": deadline}).Error
+}
+
+// RebindPredictionJob 后端使用自己生成的任务ID时，把队列任务和结果推送改为新的ID。
+// 后端已保存新ID的预测记录时删除原有记录，否则把原有记录改为新的ID
+func (s *DBService) RebindPredictionJob(oldID, newID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PredictionJob{}).Where("task_id = ?", oldID).Update("task_id", newID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ResultDelivery{}).Where("task_id = ?", oldID).Update("task_id", newID).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.PredictionResult{}).Where("task_id = ?", newID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return tx.Unscoped().Where("task_id = ?", oldID).Delete(&models.PredictionResult{}).Error
+		}
+		return tx.Model(&models.PredictionResult{}).Where("task_id = ?", oldID).Update("task_id", newID).Error
+	})
+}
+
+// ListExpiredPredictionJobs 获取等待回调超过截止时间的任务
+func (s *DBService) ListExpiredPredictionJobs(now time.Time) ([]models.PredictionJob, error) {
+	var jobs []models.PredictionJob
+	err := s.db.Where("status = ? AND deadline IS NOT NULL AND deadline < ?", models.StatusProcessing, now).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// RecoverPredictionJobs 重启后恢复队列：尚未被后端受理的处理中任务重新排队，
+// 后端已从配置中删除的任务记为失败。等待回调的任务保持不变，超时后由队列结束
+func (s *DBService) RecoverPredictionJobs(backends []string, now time.Time) (requeued, failed int64, err error) {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var taskIDs []string
+		if err := tx.Model(&models.PredictionJob{}).
+			Where("status = ? AND dispatched_at IS NULL", models.StatusProcessing).
+			Pluck("task_id", &taskIDs).Error; err != nil {
+			return err
+		}
+		if len(taskIDs) > 0 {
+			result := tx.Model(&models.PredictionJob{}).Where("task_id IN ?", taskIDs).
+				Updates(map[string]interface{}{"status": models.StatusPending, "started_at": nil})
+			if result.Error != nil {
+				return result.Error
+			}
+			requeued = result.RowsAffected
+			if err := tx.Model(&models.PredictionResult{}).
+				Where("task_id IN ? AND prediction_status = ?", taskIDs, models.StatusProcessing).
+				Update("prediction_status", models.StatusPending).Error; err != nil {
+				return err
+			}
+		}
+
+		var orphans []string
+		query := tx.Model(&models.PredictionJob{}).
+			Where("status IN ?", []models.PredictionStatus{models.StatusPending, models.StatusProcessing})
+		if len(backends) > 0 {
+			query = query.Where("backend NOT IN ?", backends)
+		}
+		if err := query.Pluck("task_id", &orphans).Error; err != nil {
+			return err
+		}
+		if len(orphans) == 0 {
+			return nil
+		}
+		result := tx.Model(&models.PredictionJob{}).Where("task_id IN ?", orphans).
+			Updates(map[string]interface{}{"status": models.StatusFailed, "error": "AI后端已不存在", "finished_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		failed = result.RowsAffected
+		return tx.Model(&models.PredictionResult{}).
+			Where("task_id IN ? AND prediction_status IN ?", orphans, []models.PredictionStatus{models.StatusPending, models.StatusProcessing}).
+			Update("prediction_status", models.StatusFailed).Error
+	})
+	return requeued, failed, err
+}
+
+// 统计相关操作
+
+// DefectStats 按分组统计预测结果和打印任务，分组按键排序
+func (s *DBService) DefectStats(q StatsQuery) ([]StatsGroup, error) {
+	key, err := statsKey(q.GroupBy, "p.created_at")
+	if err != nil {
+		return nil, err
+	}
+	// 时间列保存为带本地时区偏移的文本，SQLite按字符串比较，查询条件也换成本地时区
+	since, until := q.Since.Local(), q.Until.Local()
+	from := `FROM prediction_results p
+		LEFT JOIN print_sessions s ON s.session = p.session AND s.deleted_at IS NULL
+		WHERE p.deleted_at IS NULL AND p.prediction_status = ? AND p.created_at >= ? AND p.created_at < ?`
+	args := []interface{}{models.StatusCompleted, since, until}
+
+	groups := make(map[string]*StatsGroup)
+	group := func(k string) *StatsGroup {
+		if g, ok := groups[k]; ok {
+			return g
+		}
+		g := &StatsGroup{Key: k, DefectsByClass: make(map[models.DefectType]int64)}
+		groups[k] = g
+		return g
+	}
+
+	var totals []struct {
+		GroupKey          string
+		Predictions       int64
+		Defects           int64
+		Pauses            int64
+		LabelledPositives int64
+		FalsePositives    int64
+		MeanLatencyMs     *float64
+	}
+	err = s.db.Raw(`SELECT `+key+` AS group_key,
+			COUNT(*) AS predictions,
+			SUM(CASE WHEN p.has_defect THEN 1 ELSE 0 END) AS defects,
+			SUM(CASE WHEN p.action IN (?, ?) AND NOT p.shadow THEN 1 ELSE 0 END) AS pauses,
+			SUM(CASE WHEN p.feedback_verdict IN (?, ?) THEN 1 ELSE 0 END) AS labelled_positives,
+			SUM(CASE WHEN p.feedback_verdict = ? THEN 1 ELSE 0 END) AS false_positives,
+			AVG(CASE WHEN p.latency_ms > 0 THEN p.latency_ms END) AS mean_latency_ms
+		`+from+` GROUP BY 1`,
+		append([]interface{}{models.ActionPause, models.ActionCancel, models.FeedbackTruePositive, models.FeedbackFalsePositive, models.FeedbackFalsePositive}, args...)...).
+		Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range totals {
+		g := group(row.GroupKey)
+		g.Predictions, g.Defects, g.Pauses = row.Predictions, row.Defects, row.Pauses
+		g.LabelledPositives, g.FalsePositives = row.LabelledPositives, row.FalsePositives
+		if row.MeanLatencyMs != nil {
+			g.MeanLatencyMs = *row.MeanLatencyMs
+		}
+	}
+
+	var classes []struct {
+		GroupKey   string
+		DefectType models.DefectType
+		Count      int64
+	}
+	err = s.db.Raw(`SELECT `+key+` AS group_key, p.defect_type AS defect_type, COUNT(*) AS count
+		`+from+` AND p.has_defect GROUP BY 1, 2`, args...).Scan(&classes).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range classes {
+		if row.DefectType == "" {
+			row.DefectType = models.DefectUnknown
+		}
+		group(row.GroupKey).DefectsByClass[row.DefectType] += row.Count
+	}
+
+	sessionKey, _ := statsKey(q.GroupBy, "s.started_at")
+	var sessions []struct {
+		GroupKey  string
+		Sessions  int64
+		Completed int64
+	}
+	err = s.db.Raw(`SELECT `+sessionKey+` AS group_key,
+			COUNT(*) AS sessions,
+			SUM(CASE WHEN s.state = ? THEN 1 ELSE 0 END) AS completed
+		FROM print_sessions s
+		WHERE s.deleted_at IS NULL AND s.state IN (?, ?, ?) AND s.started_at >= ? AND s.started_at < ?
+		GROUP BY 1`,
+		models.SessionComplete, models.SessionComplete, models.SessionCancelled, models.SessionError, since, until).
+		Scan(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range sessions {
+		g := group(row.GroupKey)
+		g.Sessions, g.CompletedSessions = row.Sessions, row.Completed
+	}
+
+	result := make([]StatsGroup, 0, len(groups))
+	for _, g := range groups {
+		g.finish()
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+// 训练样本相关操作
+
+// EnqueueDatasetSample 加入上传队列。同一任务已在队列中时，用户标记会覆盖原因并重新排队，已上传的样本不再重复上传
+func (s *DBService) EnqueueDatasetSample(sample *models.DatasetSample) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.DatasetSample
+		err := tx.Where("task_id = ?", sample.TaskID).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			sample.Status = models.SamplePending
+			return tx.Create(sample).Error
+		}
+		if err != nil {
+			return err
+		}
+		if existing.Status == models.SampleUploaded || sample.Reason != models.SampleFlagged {
+			*sample = existing
+			return nil
+		}
+		return tx.Model(&existing).Updates(map[string]interface{}{
+			"reason":   models.SampleFlagged,
+			"note":     sample.Note,
+			"status":   models.SamplePending,
+			"attempts": 0,
+		}).Error
+	})
+}
+
+// CountDatasetSamples 统计since之后加入队列的某种原因的样本数
+func (s *DBService) CountDatasetSamples(reason models.SampleReason, since time.Time) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.DatasetSample{}).
+		Where("reason = ? AND created_at >= ?", reason, since).
+		Count(&count).Error
+	return count, err
+}
+
+// CountUploadedDatasetSamples 统计since之后上传成功的样本数
+func (s *DBService) CountUploadedDatasetSamples(since time.Time) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.DatasetSample{}).
+		Where("status = ? AND uploaded_at >= ?", models.SampleUploaded, since).
+		Count(&count).Error
+	return count, err
+}
+
+// CountPendingDatasetSamples 统计待上传的样本数
+func (s *DBService) CountPendingDatasetSamples() (int64, error) {
+	var count int64
+	err := s.db.Model(&models.DatasetSample{}).Where("status = ?", models.SamplePending).Count(&count).Error
+	return count, err
+}
+
+// ListPendingDatasetSamples 获取待上传的样本，用户标记的优先
+func (s *DBService) ListPendingDatasetSamples(limit int) ([]models.DatasetSample, error) {
+	var samples []models.DatasetSample
+	err := s.db.Where("status = ?", models.SamplePending).
+		Order(fmt.Sprintf("CASE reason WHEN '%s' THEN 0 ELSE 1 END, created_at", models.SampleFlagged)).
+		Limit(limit).
+		Find(&samples).Error
+	return samples, err
+}
+
+// UpdateDatasetSampleStatus 更新样本的上传状态
+func (s *DBService) UpdateDatasetSampleStatus(sample *models.DatasetSample) error {
+	return s.db.Model(sample).Updates(map[string]interface{}{
+		"status":      sample.Status,
+		"attempts":    sample.Attempts,
+		"last_error":  sample.LastError,
+		"uploaded_at": sample.UploadedAt,
+	}).Error
+}
+
+// ListExportPredictions 获取可导出为训练数据的预测结果：已完成且有本地快照
+func (s *DBService) ListExportPredictions(filter ExportFilter) ([]models.PredictionResult, error) {
+	query := s.db.Where("prediction_status = ? AND image_path <> '' AND created_at >= ? AND created_at < ?",
+		models.StatusCompleted, filter.Since, filter.Until)
+	if filter.DefectType != "" {
+		query = query.Where("defect_type = ? OR feedback_defect_type = ?", filter.DefectType, filter.DefectType)
+	}
+	if filter.LabelledOnly {
+		query = query.Where("feedback_verdict <> ''")
+	}
+	var results []models.PredictionResult
+	err := query.Order("created_at, id").Find(&results).Error
+	return results, err
+}
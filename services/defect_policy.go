@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+	"mingda_ai_helper/models"
+)
+
+// PolicyDecision 策略判定结果
+type PolicyDecision struct {
+	DefectType models.DefectType   `json:"defect_type"`
+	Action     models.PolicyAction `json:"action"`
+	Hits       int                 `json:"hits"`
+	Required   int                 `json:"required"`
+	Reason     string              `json:"reason"`
+}
+
+// PolicySet 一次判定使用的策略
+type PolicySet struct {
+	Policies map[models.DefectType]models.DefectPolicy
+	// Default 没有单独配置策略的缺陷类型使用，由用户设置中的全局阈值生成
+	Default models.DefectPolicy
+	// AllowStop 为false时暂停、取消降级为提醒（对应用户设置中的"超过阈值暂停"）
+	AllowStop bool
+}
+
+// NewPolicySet 由策略表和用户设置生成判定策略
+func NewPolicySet(policies []models.DefectPolicy, settings *models.UserSettings) PolicySet {
+	set := PolicySet{
+		Policies: make(map[models.DefectType]models.DefectPolicy, len(policies)),
+		Default: models.DefectPolicy{
+			Enabled:         true,
+			Threshold:       settings.ConfidenceThreshold,
+			ConsecutiveHits: 1,
+			Action:          models.ActionPause,
+		},
+		AllowStop: settings.PauseOnThreshold,
+	}
+	for _, policy := range policies {
+		set.Policies[policy.DefectType] = policy
+	}
+	return set
+}
+
+// DefectStreaks 各缺陷类型的连续命中次数
+type DefectStreaks map[models.DefectType]int
+
+// EvaluatePolicy 判定本次结果应执行的动作并更新连续命中次数。
+// 不访问数据库和打印机，实时处理和历史回放共用同一套规则
+func EvaluatePolicy(set PolicySet, streaks DefectStreaks, result *models.PredictionResult) PolicyDecision {
+	decision := PolicyDecision{DefectType: result.DefectType}
+	if !result.HasDefect {
+		for defectType := range streaks {
+			delete(streaks, defectType)
+		}
+		decision.Reason = "未检测到缺陷"
+		return decision
+	}
+
+	// 启发式检测的置信度本身很低且已经要求连续帧异常，不套用阈值，只提醒不暂停
+	if result.PredictionModel == HeuristicModel {
+		decision.Action = models.ActionNotify
+		decision.Reason = "启发式检测疑似缺陷，只提醒"
+		return decision
+	}
+
+	policy, ok := set.Policies[result.DefectType]
+	if !ok {
+		policy = set.Default
+	}
+	decision.Required = policy.ConsecutiveHits
+
+	// 其他类型的连续命中中断
+	for defectType := range streaks {
+		if defectType != result.DefectType {
+			delete(streaks, defectType)
+		}
+	}
+
+	if !policy.Enabled {
+		delete(streaks, result.DefectType)
+		decision.Reason = "该缺陷类型的策略未启用"
+		return decision
+	}
+	if result.Confidence < models.ConfidenceFromPercent(float64(policy.Threshold)) {
+		delete(streaks, result.DefectType)
+		decision.Reason = fmt.Sprintf("置信度%.1f%%低于阈值%d%%", result.Confidence.Percent(), policy.Threshold)
+		return decision
+	}
+
+	streaks[result.DefectType]++
+	decision.Hits = streaks[result.DefectType]
+	if decision.Hits < policy.ConsecutiveHits {
+		decision.Reason = fmt.Sprintf("连续命中%d/%d次", decision.Hits, policy.ConsecutiveHits)
+		return decision
+	}
+
+	// 执行动作后重新计数，避免每一帧重复处理
+	delete(streaks, result.DefectType)
+	decision.Action = policy.Action
+	decision.Reason = fmt.Sprintf("置信度%.1f%%达到阈值%d%%，连续命中%d次", result.Confidence.Percent(), policy.Threshold, decision.Hits)
+
+	if decision.Action.Severity() > models.ActionNotify.Severity() && !set.AllowStop {
+		decision.Action = models.ActionNotify
+		decision.Reason += "（未开启超过阈值暂停）"
+	}
+	return decision
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// defectResult 构造百分比置信度的预测结果
+func defectResult(defectType models.DefectType, confidence float64) *models.PredictionResult {
+	return &models.PredictionResult{PredictionModel: "yolo", HasDefect: defectType != "", DefectType: defectType, Confidence: models.ConfidenceFromPercent(confidence)}
+}
+
+// TestEvaluatePolicyPerDefectType 测试不同缺陷类型使用各自的阈值、连续命中次数和动作
+func TestEvaluatePolicyPerDefectType(t *testing.T) {
+	set := NewPolicySet(models.DefaultDefectPolicies(), &models.UserSettings{ConfidenceThreshold: 90, PauseOnThreshold: true})
+	streaks := make(DefectStreaks)
+
+	// 拉丝80%只是外观问题：连续3次才提醒
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, models.ActionNone, EvaluatePolicy(set, streaks, defectResult("stringing", 85)).Action)
+	}
+	decision := EvaluatePolicy(set, streaks, defectResult("stringing", 85))
+	assert.Equal(t, models.ActionNotify, decision.Action)
+	assert.Equal(t, 3, decision.Hits)
+
+	// 炒面60%需要暂停，中间出现正常帧会重新计数
+	assert.Equal(t, models.ActionNone, EvaluatePolicy(set, streaks, defectResult("spaghetti", 62)).Action)
+	assert.Equal(t, models.ActionNone, EvaluatePolicy(set, streaks, defectResult("", 0)).Action)
+	assert.Equal(t, models.ActionNone, EvaluatePolicy(set, streaks, defectResult("spaghetti", 62)).Action)
+	assert.Equal(t, models.ActionPause, EvaluatePolicy(set, streaks, defectResult("spaghetti", 64)).Action)
+
+	// 低于阈值不计数
+	assert.Equal(t, models.ActionNone, EvaluatePolicy(set, streaks, defectResult("spaghetti", 40)).Action)
+	assert.Zero(t, streaks["spaghetti"])
+
+	// 未配置策略的类型使用全局阈值
+	assert.Equal(t, models.ActionNone, EvaluatePolicy(set, streaks, defectResult("nozzle_clog", 85)).Action)
+	assert.Equal(t, models.ActionPause, EvaluatePolicy(set, streaks, defectResult("nozzle_clog", 95)).Action)
+}
+
+// TestEvaluatePolicyDowngrades 测试未开启暂停时降级为提醒，启发式检测只提醒
+func TestEvaluatePolicyDowngrades(t *testing.T) {
+	policies := []models.DefectPolicy{
+		{DefectType: "spaghetti", Enabled: true, Threshold: 60, ConsecutiveHits: 1, Action: models.ActionCancel},
+		{DefectType: "blob", Enabled: false, Threshold: 10, ConsecutiveHits: 1, Action: models.ActionPause},
+	}
+
+	set := NewPolicySet(policies, &models.UserSettings{ConfidenceThreshold: 80, PauseOnThreshold: false})
+	assert.Equal(t, models.ActionNotify, EvaluatePolicy(set, make(DefectStreaks), defectResult("spaghetti", 70)).Action)
+	assert.Equal(t, models.ActionNone, EvaluatePolicy(set, make(DefectStreaks), defectResult("blob", 99)).Action)
+
+	set.AllowStop = true
+	assert.Equal(t, models.ActionCancel, EvaluatePolicy(set, make(DefectStreaks), defectResult("spaghetti", 70)).Action)
+
+	heuristic := defectResult("spaghetti", 30)
+	heuristic.PredictionModel = HeuristicModel
+	assert.Equal(t, models.ActionNotify, EvaluatePolicy(set, make(DefectStreaks), heuristic).Action)
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"fmt"
+	"mingda_ai_helper/models"
+	"sync"
+	"time"
+)
+
+// StatsGroupBy 统计的分组方式
+type StatsGroupBy string
+
+const (
+	GroupByNone    StatsGroupBy = ""        // 不分组，只统计总数
+	GroupByDay     StatsGroupBy = "day"     // 按本地日期
+	GroupByWeek    StatsGroupBy = "week"    // 按本地时间的ISO周，格式为2026-W41
+	GroupByPrinter StatsGroupBy = "printer" // 按机器序列号
+	GroupByFile    StatsGroupBy = "file"    // 按打印文件
+)
+
+// Valid 是否为支持的分组方式
+func (g StatsGroupBy) Valid() bool {
+	switch g {
+	case GroupByNone, GroupByDay, GroupByWeek, GroupByPrinter, GroupByFile:
+		return true
+	}
+	return false
+}
+
+// StatsQuery 统计条件
+type StatsQuery struct {
+	Since   time.Time
+	Until   time.Time
+	GroupBy StatsGroupBy
+}
+
+// StatsGroup 一个分组的统计结果。只统计已完成的预测，影子模式下的暂停不计入
+type StatsGroup struct {
+	Key               string                        `json:"key"`
+	Predictions       int64                         `json:"predictions"`
+	Defects           int64                         `json:"defects"`
+	DefectRate        float64                       `json:"defect_rate"`
+	DefectsByClass    map[models.DefectType]int64   `json:"defects_by_class"`
+	DefectRateByClass map[models.DefectType]float64 `json:"defect_rate_by_class"`
+	Pauses            int64                         `json:"pauses"` // 策略暂停或取消打印的次数
+	// 误报率 = 误报 / 有反馈的缺陷判定(true_positive + false_positive)
+	LabelledPositives int64   `json:"labelled_positives"`
+	FalsePositives    int64   `json:"false_positives"`
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+	MeanLatencyMs     float64 `json:"mean_latency_ms"`
+	// 打印成功率 = 完成的打印 / 已结束的打印
+	Sessions           int64   `json:"sessions"`
+	CompletedSessions  int64   `json:"completed_sessions"`
+	SessionSuccessRate float64 `json:"session_success_rate"`
+}
+
+// finish 计算各项比率
+func (g *StatsGroup) finish() {
+	g.DefectRateByClass = make(map[models.DefectType]float64, len(g.DefectsByClass))
+	if g.Predictions > 0 {
+		g.DefectRate = float64(g.Defects) / float64(g.Predictions)
+		for t, n := range g.DefectsByClass {
+			g.DefectRateByClass[t] = float64(n) / float64(g.Predictions)
+		}
+	}
+	if g.LabelledPositives > 0 {
+		g.FalsePositiveRate = float64(g.FalsePositives) / float64(g.LabelledPositives)
+	}
+	if g.Sessions > 0 {
+		g.SessionSuccessRate = float64(g.CompletedSessions) / float64(g.Sessions)
+	}
+}
+
+// statsKey 分组键的SQL表达式，column为分组使用的时间列
+func statsKey(groupBy StatsGroupBy, column string) (string, error) {
+	switch groupBy {
+	case GroupByNone:
+		return "'total'", nil
+	case GroupByDay:
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d', %s, 'localtime')", column), nil
+	case GroupByWeek:
+		// ISO周：周一开始，按所在周的周四确定年份和周数，跨年的一周不会拆成两组
+		thursday := fmt.Sprintf("%s, 'localtime', '-3 days', 'weekday 4'", column)
+		return fmt.Sprintf("printf('%%s-W%%02d', strftime('%%Y', %s), (CAST(strftime('%%j', %s) AS INTEGER) + 6) / 7)", thursday, thursday), nil
+	case GroupByPrinter:
+		return "COALESCE(s.printer, '')", nil
+	case GroupByFile:
+		return "COALESCE(s.filename, '')", nil
+	}
+	return "", fmt.Errorf("不支持的分组方式: %s", groupBy)
+}
+
+// StatsStore 提供统计数据的存储
+type StatsStore interface {
+	DefectStats(q StatsQuery) ([]StatsGroup, error)
+}
+
+// statsCacheKey 缓存键。同一时刻在不同时区下的time.Time不相等，按纳秒时间戳比较
+type statsCacheKey struct {
+	since, until int64
+	groupBy      StatsGroupBy
+}
+
+type statsEntry struct {
+	groups  []StatsGroup
+	expires time.Time
+}
+
+// StatsCache 缓存统计结果。统计需要扫描整个时间段的预测记录，报表页面反复刷新时直接使用缓存
+type StatsCache struct {
+	store StatsStore
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[statsCacheKey]statsEntry
+}
+
+// NewStatsCache 创建统计缓存
+func NewStatsCache(store StatsStore, ttl time.Duration) *StatsCache {
+	return &StatsCache{store: store, ttl: ttl, entries: make(map[statsCacheKey]statsEntry)}
+}
+
+// Get 获取统计结果，缓存过期时重新查询
+func (c *StatsCache) Get(q StatsQuery) ([]StatsGroup, error) {
+	key := statsCacheKey{since: q.Since.UnixNano(), until: q.Until.UnixNano(), groupBy: q.GroupBy}
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.groups, nil
+	}
+
+	groups, err := c.store.DefectStats(q)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = statsEntry{groups: groups, expires: now.Add(c.ttl)}
+	return groups, nil
+}
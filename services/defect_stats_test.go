@@ -0,0 +1,175 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// TestDefectStats 测试缺陷率、暂停、误报率、耗时和打印成功率的统计
+func TestDefectStats(t *testing.T) {
+	_, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	now := time.Now()
+	require.NoError(t, db.SaveMachineInfo(&models.MachineInfo{MachineSN: "SN001", MachineModel: "MD-400"}))
+
+	for _, session := range []*models.PrintSession{
+		{Session: "PS1", Printer: "SN001", Filename: "benchy.gcode", StartedAt: now.Add(-2 * time.Hour)},
+		{Session: "PS2", Printer: "SN001", Filename: "vase.gcode", StartedAt: now.Add(-time.Hour)},
+		{Session: "PS3", Printer: "SN001", Filename: "vase.gcode", StartedAt: now.Add(-30 * time.Minute)},
+	} {
+		require.NoError(t, db.StartPrintSession(session))
+	}
+	require.NoError(t, db.FinishPrintSession("PS1", models.SessionComplete, now))
+	require.NoError(t, db.FinishPrintSession("PS2", models.SessionCancelled, now))
+
+	results := []models.PredictionResult{
+		{TaskID: "PT001", Session: "PS1", Confidence: 0.1, LatencyMs: 100},
+		{TaskID: "PT002", Session: "PS1", HasDefect: true, DefectType: models.DefectStringing, Confidence: 0.6, LatencyMs: 300},
+		{TaskID: "PT003", Session: "PS2", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.9, Action: models.ActionPause},
+		{TaskID: "PT004", Session: "PS2", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.9, Action: models.ActionPause, Shadow: true},
+	}
+	for i := range results {
+		results[i].PredictionModel = "yolo"
+		results[i].PredictionStatus = models.StatusCompleted
+		require.NoError(t, db.SavePredictionResult(&results[i]))
+	}
+	require.NoError(t, db.SavePredictionFeedback("PT002", &models.PredictionFeedback{Verdict: models.FeedbackFalsePositive}))
+	require.NoError(t, db.SavePredictionFeedback("PT003", &models.PredictionFeedback{Verdict: models.FeedbackTruePositive}))
+	// 未完成的预测不统计
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{TaskID: "PT005", PredictionModel: "yolo", Session: "PS3"}))
+
+	q := StatsQuery{Since: now.Add(-24 * time.Hour), Until: now.Add(time.Hour)}
+	groups, err := db.DefectStats(q)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	total := groups[0]
+	assert.Equal(t, "total", total.Key)
+	assert.Equal(t, int64(4), total.Predictions)
+	assert.InDelta(t, 0.75, total.DefectRate, 1e-9)
+	assert.Equal(t, int64(2), total.DefectsByClass[models.DefectSpaghetti])
+	assert.InDelta(t, 0.25, total.DefectRateByClass[models.DefectStringing], 1e-9)
+	assert.Equal(t, int64(1), total.Pauses)
+	assert.InDelta(t, 0.5, total.FalsePositiveRate, 1e-9)
+	assert.InDelta(t, 200, total.MeanLatencyMs, 1e-9)
+	assert.Equal(t, int64(2), total.Sessions)
+	assert.InDelta(t, 0.5, total.SessionSuccessRate, 1e-9)
+
+	q.GroupBy = GroupByFile
+	groups, err = db.DefectStats(q)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "benchy.gcode", groups[0].Key)
+	assert.InDelta(t, 1, groups[0].SessionSuccessRate, 1e-9)
+	assert.Equal(t, "vase.gcode", groups[1].Key)
+	assert.Equal(t, int64(2), groups[1].Predictions)
+
+	q.GroupBy = GroupByDay
+	groups, err = db.DefectStats(q)
+	require.NoError(t, err)
+	require.NotEmpty(t, groups)
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}$`, groups[0].Key)
+}
+
+// TestDefectStatsLocalTimeZone 测试本地时区不是UTC时，任意时区的查询时间都按同一时刻筛选，暂停和取消都计入
+func TestDefectStatsLocalTimeZone(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("Asia/Shanghai", 8*3600)
+	t.Cleanup(func() { time.Local = local })
+
+	_, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	for _, r := range []models.PredictionResult{
+		{TaskID: "PT001", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.9, Action: models.ActionPause},
+		{TaskID: "PT002", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.95, Action: models.ActionCancel},
+		{TaskID: "PT003", Confidence: 0.1},
+	} {
+		r.PredictionModel, r.PredictionStatus = "yolo", models.StatusCompleted
+		require.NoError(t, db.SavePredictionResult(&r))
+	}
+
+	cache := NewStatsCache(db, time.Minute)
+	now := time.Now()
+	for _, zone := range []*time.Location{time.UTC, time.Local, time.FixedZone("UTC-5", -5*3600)} {
+		groups, err := cache.Get(StatsQuery{Since: now.Add(-time.Hour).In(zone), Until: now.Add(time.Hour).In(zone)})
+		require.NoError(t, err)
+		require.Len(t, groups, 1, zone.String())
+		assert.Equal(t, int64(3), groups[0].Predictions, zone.String())
+		assert.Equal(t, int64(2), groups[0].Pauses, zone.String())
+	}
+
+	// 不包含记录的时间段
+	groups, err := db.DefectStats(StatsQuery{Since: now.Add(30 * time.Minute).UTC(), Until: now.Add(2 * time.Hour).UTC()})
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+	groups, err = db.DefectStats(StatsQuery{Since: now.Add(-2 * time.Hour).UTC(), Until: now.Add(-30 * time.Minute).UTC()})
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+// TestDefectStatsISOWeek 测试按周分组使用ISO周，跨年的一周归入同一组
+func TestDefectStatsISOWeek(t *testing.T) {
+	_, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	days := []time.Time{
+		time.Date(2025, 12, 29, 12, 0, 0, 0, time.Local), // 周一，2026-W01
+		time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local),
+		time.Date(2026, 10, 5, 12, 0, 0, 0, time.Local),
+		time.Date(2026, 12, 31, 12, 0, 0, 0, time.Local),
+		time.Date(2027, 1, 3, 12, 0, 0, 0, time.Local), // 周日，仍是2026-W53
+		time.Date(2027, 1, 4, 12, 0, 0, 0, time.Local),
+	}
+	expected := map[string]int64{}
+	for i, day := range days {
+		year, week := day.ISOWeek()
+		expected[fmt.Sprintf("%d-W%02d", year, week)]++
+		require.NoError(t, db.SavePredictionResult(&models.PredictionResult{
+			TaskID: fmt.Sprintf("PT%03d", i), PredictionModel: "yolo", PredictionStatus: models.StatusCompleted, Model: gorm.Model{CreatedAt: day},
+		}))
+	}
+	assert.Equal(t, map[string]int64{"2026-W01": 2, "2026-W41": 1, "2026-W53": 2, "2027-W01": 1}, expected)
+
+	groups, err := db.DefectStats(StatsQuery{Since: days[0].Add(-time.Hour), Until: days[len(days)-1].Add(time.Hour), GroupBy: GroupByWeek})
+	require.NoError(t, err)
+	actual := map[string]int64{}
+	for _, g := range groups {
+		actual[g.Key] = g.Predictions
+	}
+	assert.Equal(t, expected, actual)
+}
+
+// countingStatsStore 记录查询次数
+type countingStatsStore struct {
+	calls int
+}
+
+func (s *countingStatsStore) DefectStats(q StatsQuery) ([]StatsGroup, error) {
+	s.calls++
+	return []StatsGroup{{Key: "total"}}, nil
+}
+
+// TestStatsCache 测试相同条件在缓存有效期内只查询一次
+func TestStatsCache(t *testing.T) {
+	store := &countingStatsStore{}
+	cache := NewStatsCache(store, time.Minute)
+	q := StatsQuery{Since: time.Now().Add(-time.Hour), Until: time.Now()}
+
+	_, err := cache.Get(q)
+	require.NoError(t, err)
+	_, err = cache.Get(StatsQuery{Since: q.Since.In(time.FixedZone("UTC+8", 8*3600)), Until: q.Until})
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.calls)
+
+	q.GroupBy = GroupByDay
+	_, err = cache.Get(q)
+	require.NoError(t, err)
+	assert.Equal(t, 2, store.calls)
+
+	expired := NewStatsCache(store, 0)
+	expired.Get(q)
+	expired.Get(q)
+	assert.Equal(t, 4, store.calls)
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+	"mingda_ai_helper/models"
+)
+
+// DefectMapper 把后端返回的类别映射为统一的缺陷类型
+type DefectMapper struct {
+	aliases map[string]models.DefectType
+}
+
+// NewDefectMapper 根据后端配置的class_aliases创建，映射目标必须是统一的缺陷类型
+func NewDefectMapper(aliases map[string]string) (*DefectMapper, error) {
+	m := &DefectMapper{aliases: make(map[string]models.DefectType, len(aliases))}
+	for class, target := range aliases {
+		t := models.DefectType(models.NormalizeDefectLabel(target))
+		if !t.Valid() {
+			return nil, fmt.Errorf("类别%s映射到未知的缺陷类型: %s", class, target)
+		}
+		m.aliases[models.NormalizeDefectLabel(class)] = t
+	}
+	return m, nil
+}
+
+// Map 映射单个类别，后端别名优先，其次是内置别名，都不匹配时为unknown。
+// m为nil时只使用内置别名
+func (m *DefectMapper) Map(class string) models.DefectType {
+	if m != nil {
+		if t, ok := m.aliases[models.NormalizeDefectLabel(class)]; ok {
+			return t
+		}
+	}
+	if t, ok := models.LookupDefectType(class); ok {
+		return t
+	}
+	return models.DefectUnknown
+}
+
+// MapResult 映射预测结果及其检测框的类别。
+// 有缺陷但没有给出类型的结果（如本地模型）取置信度最高的检测框的类别
+func (m *DefectMapper) MapResult(result *models.PredictionResult) {
+	var best *models.Detection
+	for i := range result.Detections {
+		d := &result.Detections[i]
+		if mapped := m.Map(d.Label); string(mapped) != d.Label {
+			d.RawLabel, d.Label = d.Label, string(mapped)
+		}
+		if best == nil || d.Confidence > best.Confidence {
+			best = d
+		}
+	}
+
+	if !result.HasDefect {
+		result.DefectType = ""
+		return
+	}
+	switch {
+	case result.DefectType != "":
+		result.DefectType = m.Map(string(result.DefectType))
+	case best != nil:
+		result.DefectType = models.DefectType(best.Label)
+	default:
+		result.DefectType = models.DefectUnknown
+	}
+}
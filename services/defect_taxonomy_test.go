@@ -0,0 +1,101 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDefectMapperAliases 测试后端别名、内置别名和未知类别的映射
+func TestDefectMapperAliases(t *testing.T) {
+	mapper, err := NewDefectMapper(map[string]string{"Fail": "spaghetti", "thin-lines": "Stringing"})
+	require.NoError(t, err)
+
+	assert.Equal(t, models.DefectSpaghetti, mapper.Map("fail"))
+	assert.Equal(t, models.DefectStringing, mapper.Map("Thin Lines"))
+	assert.Equal(t, models.DefectStringing, mapper.Map("string"))
+	assert.Equal(t, models.DefectLayerShift, mapper.Map("Layer-Shift"))
+	assert.Equal(t, models.DefectUnknown, mapper.Map("nozzle_clog"))
+
+	var none *DefectMapper
+	assert.Equal(t, models.DefectSpaghetti, none.Map("failure"))
+
+	_, err = NewDefectMapper(map[string]string{"string": "strings_and_things"})
+	assert.Error(t, err)
+}
+
+// TestDefectMapperResult 测试预测结果映射：没有给出类型时取置信度最高的检测框
+func TestDefectMapperResult(t *testing.T) {
+	result := &models.PredictionResult{
+		HasDefect: true,
+		Detections: models.Detections{
+			{Label: "string", Confidence: 0.4},
+			{Label: "spaghetti", Confidence: 0.8},
+		},
+	}
+	(*DefectMapper)(nil).MapResult(result)
+	assert.Equal(t, models.DefectSpaghetti, result.DefectType)
+	assert.Equal(t, "stringing", result.Detections[0].Label)
+	assert.Equal(t, "string", result.Detections[0].RawLabel)
+	assert.Empty(t, result.Detections[1].RawLabel)
+
+	result = &models.PredictionResult{HasDefect: true, DefectType: "failure"}
+	(*DefectMapper)(nil).MapResult(result)
+	assert.Equal(t, models.DefectSpaghetti, result.DefectType)
+
+	result = &models.PredictionResult{HasDefect: false, DefectType: "string"}
+	(*DefectMapper)(nil).MapResult(result)
+	assert.Empty(t, result.DefectType)
+}
+
+// TestMigrateDefectTypes 测试把旧版本保存的原始类别按后端映射为统一类型
+func TestMigrateDefectTypes(t *testing.T) {
+	db, err := NewDBService(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	legacy := []models.PredictionResult{
+		{TaskID: "T1", Backend: "local", HasDefect: true, DefectType: "Spaghetti"},
+		{TaskID: "T2", Backend: "local", HasDefect: true, DefectType: "string"},
+		{TaskID: "T3", Backend: "local", HasDefect: true, DefectType: "nozzle_clog"},
+		{TaskID: "T4", Backend: "cloud", HasDefect: true, DefectType: "nozzle_clog"},
+		{TaskID: "T5", HasDefect: true, DefectType: "zit"},
+		{TaskID: "T6", Backend: "local", HasDefect: true, DefectType: models.DefectWarping},
+		{TaskID: "T7", Backend: "local"},
+	}
+	for i := range legacy {
+		legacy[i].PredictionStatus = models.StatusCompleted
+		legacy[i].PredictionModel = "legacy"
+		require.NoError(t, db.DB().Create(&legacy[i]).Error)
+	}
+
+	local, err := NewDefectMapper(map[string]string{"nozzle_clog": "blob"})
+	require.NoError(t, err)
+	migrated, err := db.MigrateDefectTypes(map[string]*DefectMapper{"local": local, "cloud": nil})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), migrated)
+
+	want := map[string]models.DefectType{
+		"T1": models.DefectSpaghetti,
+		"T2": models.DefectStringing,
+		"T3": models.DefectBlob,
+		"T4": models.DefectUnknown,
+		"T5": models.DefectBlob,
+		"T6": models.DefectWarping,
+		"T7": "",
+	}
+	for taskID, defectType := range want {
+		result, err := db.GetPredictionResult(taskID)
+		require.NoError(t, err)
+		assert.Equal(t, defectType, result.DefectType, taskID)
+	}
+
+	// 已经是统一类型，再次执行不修改
+	migrated, err = db.MigrateDefectTypes(map[string]*DefectMapper{"local": local})
+	require.NoError(t, err)
+	assert.Zero(t, migrated)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType 推送给前端的事件类型
+type EventType string
+
+const (
+	EventPrinterState        EventType = "printer_state"        // 打印机状态变化
+	EventSnapshotTaken       EventType = "snapshot_taken"       // 监控拍照完成
+	EventPredictionStarted   EventType = "prediction_started"   // 开始预测
+	EventPredictionCompleted EventType = "prediction_completed" // 预测结果已保存
+	EventPauseIssued         EventType = "pause_issued"         // 策略暂停了打印
+	EventBackendHealth       EventType = "backend_health"       // AI后端熔断状态变化
+	EventSettingsChanged     EventType = "settings_changed"     // 用户设置或缺陷处理策略变化
+)
+
+// EventTypes 所有事件类型
+func EventTypes() []EventType {
+	return []EventType{
+		EventPrinterState, EventSnapshotTaken, EventPredictionStarted, EventPredictionCompleted,
+		EventPauseIssued, EventBackendHealth, EventSettingsChanged,
+	}
+}
+
+// ParseEventTypes 解析逗号分隔的事件类型，为空时返回nil表示全部
+func ParseEventTypes(s string) ([]EventType, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var types []EventType
+	for _, name := range strings.Split(s, ",") {
+		t := EventType(strings.TrimSpace(name))
+		valid := false
+		for _, v := range EventTypes() {
+			if t == v {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("未知的事件类型: %s", t)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// Event 一条事件，ID在进程内递增
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type EventType   `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// eventBuffer 每个订阅者缓冲的事件数，客户端读取过慢时丢弃新事件
+const eventBuffer = 64
+
+// Subscription 一个订阅者
+type Subscription struct {
+	bus     *EventBus
+	ch      chan Event
+	types   map[EventType]bool // 为空时接收全部
+	dropped int
+}
+
+// Events 接收事件的通道，取消订阅后关闭
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped 因缓冲已满丢弃的事件数
+func (s *Subscription) Dropped() int {
+	if s.bus == nil {
+		return 0
+	}
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.dropped
+}
+
+// Close 取消订阅
+func (s *Subscription) Close() {
+	if s.bus == nil {
+		return
+	}
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
+
+// EventBus 进程内的事件分发。发布不会阻塞，为nil时发布被忽略
+type EventBus struct {
+	mu     sync.Mutex
+	nextID uint64
+	subs   map[*Subscription]struct{}
+}
+
+// NewEventBus 创建事件总线
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe 订阅指定类型的事件，不指定时订阅全部。b为nil时返回不会收到事件的订阅
+func (b *EventBus) Subscribe(types ...EventType) *Subscription {
+	if b == nil {
+		return &Subscription{ch: make(chan Event)}
+	}
+	sub := &Subscription{bus: b, ch: make(chan Event, eventBuffer), types: make(map[EventType]bool)}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Subscribers 当前的订阅者数量
+func (b *EventBus) Subscribers() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Publish 发布事件
+func (b *EventBus) Publish(t EventType, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	event := Event{ID: b.nextID, Type: t, Time: time.Now(), Data: data}
+	for sub := range b.subs {
+		if len(sub.types) > 0 && !sub.types[t] {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped++
+		}
+	}
+}
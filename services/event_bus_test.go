@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEventBusFiltersAndDrops 测试按类型订阅、缓冲满时丢弃和取消订阅
+func TestEventBusFiltersAndDrops(t *testing.T) {
+	bus := NewEventBus()
+	all := bus.Subscribe()
+	pauses := bus.Subscribe(EventPauseIssued)
+
+	bus.Publish(EventPrinterState, map[string]interface{}{"state": "printing"})
+	bus.Publish(EventPauseIssued, map[string]interface{}{"task_id": "PT001"})
+
+	first := <-all.Events()
+	assert.Equal(t, EventPrinterState, first.Type)
+	assert.Equal(t, uint64(1), first.ID)
+	assert.Equal(t, EventPauseIssued, (<-all.Events()).Type)
+	event := <-pauses.Events()
+	assert.Equal(t, uint64(2), event.ID)
+	assert.Empty(t, pauses.Events())
+
+	// 不读取的订阅者不会阻塞发布
+	for i := 0; i < eventBuffer+5; i++ {
+		bus.Publish(EventSnapshotTaken, nil)
+	}
+	assert.Equal(t, 5, all.Dropped())
+
+	all.Close()
+	all.Close()
+	_, ok := <-all.Events()
+	for ok {
+		_, ok = <-all.Events()
+	}
+
+	// 未设置事件总线时订阅和发布都不生效
+	var none *EventBus
+	none.Publish(EventPrinterState, nil)
+	sub := none.Subscribe(EventPauseIssued)
+	assert.Empty(t, sub.Events())
+	assert.Zero(t, sub.Dropped())
+	assert.Zero(t, none.Subscribers())
+	sub.Close()
+
+	types, err := ParseEventTypes("pause_issued, printer_state")
+	require.NoError(t, err)
+	assert.Equal(t, []EventType{EventPauseIssued, EventPrinterState}, types)
+	_, err = ParseEventTypes("pause_issued,coffee")
+	assert.Error(t, err)
+}
+
+// TestProcessorPublishesEvents 测试处理结果后推送预测完成和暂停事件
+func TestProcessorPublishesEvents(t *testing.T) {
+	processor, printer, _ := newTestProcessor(t, &models.UserSettings{
+		EnableAI: true, ConfidenceThreshold: 80, PauseOnThreshold: true,
+	})
+	bus := NewEventBus()
+	processor.SetEventBus(bus)
+	sub := bus.Subscribe(EventPredictionCompleted, EventPauseIssued)
+
+	for _, taskID := range []string{"TASK001", "TASK002"} {
+		require.NoError(t, processor.Process(&models.PredictionResult{
+			TaskID: taskID, PredictionModel: "yolo", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.9,
+		}))
+	}
+	require.Equal(t, 1, printer.paused)
+
+	var types []EventType
+	for len(sub.Events()) > 0 {
+		types = append(types, (<-sub.Events()).Type)
+	}
+	assert.Equal(t, []EventType{EventPredictionCompleted, EventPauseIssued, EventPredictionCompleted}, types)
+}
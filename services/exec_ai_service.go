@@ -0,0 +1,301 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// imagePlaceholder 命令参数中的快照路径占位符
+const imagePlaceholder = "{image}"
+
+// maxVerdictSize 命令输出的最大长度，超过部分丢弃
+const maxVerdictSize = 1 << 20
+
+// ExecVerdict 推理命令在stdout输出的JSON结果
+type ExecVerdict struct {
+	PredictModel string  `json:"predict_model"`
+	HasDefect    bool    `json:"has_defect"`
+	DefectType   string  `json:"defect_type"`
+	Confidence   float64 `json:"confidence"` // 0-1
+	Error        string  `json:"error"`
+}
+
+// ExecAIService 运行本地推理命令（Python脚本、编译好的模型程序等），
+// 把快照路径作为参数传入，并从stdout读取JSON结果
+type ExecAIService struct {
+	name       string
+	command    []string
+	timeout    time.Duration
+	slots      chan struct{} // 并发限制
+	httpClient *http.Client
+	logService *LogService
+}
+
+// newExecBackend 根据command、timeout、max_concurrency创建exec后端
+func newExecBackend(cfg config.BackendConfig, deps BackendDeps) (AIService, error) {
+	if len(cfg.Command) == 0 || cfg.Command[0] == "" {
+		return nil, fmt.Errorf("缺少command")
+	}
+	if _, err := exec.LookPath(cfg.Command[0]); err != nil {
+		return nil, fmt.Errorf("推理命令不可用: %v", err)
+	}
+	concurrency := cfg.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return NewExecAIService(cfg.Name, cfg.Command, time.Duration(cfg.Timeout)*time.Second, concurrency, deps.LogService), nil
+}
+
+// NewExecAIService 创建命令行推理服务
+func NewExecAIService(name string, command []string, timeout time.Duration, concurrency int, logService *LogService) *ExecAIService {
+	return &ExecAIService{
+		name:       name,
+		command:    command,
+		timeout:    timeout,
+		slots:      make(chan struct{}, concurrency),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		logService: logService,
+	}
+}
+
+// Predict 图片为本地文件（file://或绝对路径）时直接使用，为HTTP地址时先下载到临时文件
+func (s *ExecAIService) Predict(ctx context.Context, imageURL string, taskID string) (*models.PredictionResult, error) {
+	imagePath := strings.TrimPrefix(imageURL, "file://")
+	if strings.HasPrefix(imageURL, "http://") || strings.HasPrefix(imageURL, "https://") {
+		tmpPath, err := s.download(ctx, imageURL)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmpPath)
+		imagePath = tmpPath
+	}
+	return s.run(ctx, imagePath, taskID)
+}
+
+func (s *ExecAIService) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
+	return s.run(ctx, imagePath, fileTaskID(taskID))
+}
+
+// run 运行推理命令并解析结果
+func (s *ExecAIService) run(ctx context.Context, imagePath, taskID string) (*models.PredictionResult, error) {
+	if _, err := os.Stat(imagePath); err != nil {
+		return nil, fmt.Errorf("图片文件不可用: %v", err)
+	}
+
+	// 等待空闲的执行名额，调用方取消或等待超过单次推理的超时时间时放弃，
+	// 避免没有截止时间的调用方在命令卡住时无限排队
+	var waitTimeout <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		waitTimeout = timer.C
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		return nil, fmt.Errorf("等待推理名额时取消: %w", ctx.Err())
+	case <-waitTimeout:
+		return nil, fmt.Errorf("等待推理名额超时(%v)", s.timeout)
+	}
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, s.command[0], s.args(imagePath)...)
+	// 超时或取消时结束整个进程组，避免脚本拉起的子进程残留
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.WaitDelay = 2 * time.Second
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &limitedWriter{w: &stdout, n: maxVerdictSize}
+	stderr := &stderrLogger{log: s.logService, backend: s.name, taskID: taskID}
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	stderr.Flush()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("推理命令超时(%v)", s.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("推理命令执行失败: %v", err)
+	}
+
+	verdict, err := parseVerdict(stdout.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if verdict.Error != "" {
+		return nil, fmt.Errorf("推理命令返回错误: %s", verdict.Error)
+	}
+	if verdict.Confidence < 0 || verdict.Confidence > 1 {
+		return nil, fmt.Errorf("无效的置信度: %v", verdict.Confidence)
+	}
+
+	s.logService.Info("命令行推理完成",
+		zap.String("backend", s.name),
+		zap.String("task_id", taskID),
+		zap.Duration("elapsed", time.Since(start)))
+
+	model := verdict.PredictModel
+	if model == "" {
+		model = "exec_" + s.name
+	}
+	return &models.PredictionResult{
+		TaskID:           taskID,
+		PredictionStatus: models.StatusCompleted,
+		PredictionModel:  model,
+		HasDefect:        verdict.HasDefect,
+		DefectType:       models.DefectType(verdict.DefectType),
+		Confidence:       models.Confidence(verdict.Confidence),
+	}, nil
+}
+
+// args 替换参数中的图片占位符，没有占位符时把路径追加到末尾
+func (s *ExecAIService) args(imagePath string) []string {
+	args := make([]string, 0, len(s.command))
+	replaced := false
+	for _, arg := range s.command[1:] {
+		if strings.Contains(arg, imagePlaceholder) {
+			arg = strings.ReplaceAll(arg, imagePlaceholder, imagePath)
+			replaced = true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, imagePath)
+	}
+	return args
+}
+
+// download 下载图片到临时文件
+func (s *ExecAIService) download(ctx context.Context, imageURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", imageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("创建下载请求失败: %v", err)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("下载图片失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("下载图片失败，状态码: %d", resp.StatusCode)
+	}
+
+	file, err := os.CreateTemp("", "mingda_ai_*.jpg")
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("保存图片失败: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// parseVerdict 解析stdout中的JSON结果。命令可能先输出日志，此时取最后一个非空行
+func parseVerdict(output []byte) (*ExecVerdict, error) {
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return nil, fmt.Errorf("推理命令没有输出结果")
+	}
+
+	var verdict ExecVerdict
+	if err := json.Unmarshal(output, &verdict); err == nil {
+		return &verdict, nil
+	}
+	if i := bytes.LastIndexByte(output, '\n'); i >= 0 {
+		if err := json.Unmarshal(bytes.TrimSpace(output[i+1:]), &verdict); err == nil {
+			return &verdict, nil
+		}
+	}
+	return nil, fmt.Errorf("解析推理结果失败: %q", truncate(string(output), 200))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// limitedWriter 最多写入n字节，超出部分丢弃
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	if len(p) > l.n {
+		p = p[:l.n]
+	}
+	if len(p) > 0 {
+		if _, err := l.w.Write(p); err != nil {
+			return 0, err
+		}
+		l.n -= len(p)
+	}
+	return written, nil
+}
+
+// stderrLogger 把推理命令的stderr按行写入日志
+type stderrLogger struct {
+	log     *LogService
+	backend string
+	taskID  string
+	buf     []byte
+}
+
+func (w *stderrLogger) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	// 单行过长时直接输出
+	if len(w.buf) > 4096 {
+		w.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush 输出剩余不完整的行
+func (w *stderrLogger) Flush() {
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *stderrLogger) emit(line []byte) {
+	if text := strings.TrimSpace(string(line)); text != "" {
+		w.log.Info("推理命令输出",
+			zap.String("backend", w.backend),
+			zap.String("task_id", w.taskID),
+			zap.String("stderr", text))
+	}
+}
@@ -0,0 +1,101 @@
+//go:build !windows
+
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestExecService 用shell脚本模拟推理命令
+func newTestExecService(t *testing.T, script string, timeout time.Duration) (*ExecAIService, string) {
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "detect.sh")
+	require.NoError(t, os.WriteFile(scriptPath, []byte("#!/bin/sh\n"+script), 0755))
+	imagePath := filepath.Join(dir, "snapshot.jpg")
+	require.NoError(t, os.WriteFile(imagePath, []byte("jpeg"), 0644))
+
+	logService, err := NewLogService("error", filepath.Join(dir, "test.log"))
+	require.NoError(t, err)
+	svc := NewExecAIService("yolo", []string{"/bin/sh", scriptPath, "--image", "{image}"}, timeout, 1, logService)
+	return svc, imagePath
+}
+
+// TestExecAIServiceVerdict 测试读取stdout最后一行的JSON结果
+func TestExecAIServiceVerdict(t *testing.T) {
+	svc, imagePath := newTestExecService(t, `
+echo "loading model" >&2
+echo "warmup done"
+echo '{"predict_model":"yolov8n","has_defect":true,"defect_type":"spaghetti","confidence":0.87,"image":"'$2'"}'
+`, 5*time.Second)
+
+	result, err := svc.Predict(context.Background(), "file://"+imagePath, "TASK001")
+	require.NoError(t, err)
+	assert.Equal(t, "TASK001", result.TaskID)
+	assert.Equal(t, models.StatusCompleted, result.PredictionStatus)
+	assert.Equal(t, "yolov8n", result.PredictionModel)
+	assert.True(t, result.HasDefect)
+	assert.Equal(t, models.DefectSpaghetti, result.DefectType)
+	assert.InDelta(t, 0.87, float64(result.Confidence), 0.001)
+}
+
+// TestExecAIServiceTimeoutKillsProcessGroup 测试超时后结束整个进程组
+func TestExecAIServiceTimeoutKillsProcessGroup(t *testing.T) {
+	svc, imagePath := newTestExecService(t, `
+sleep 30 &
+sleep 30
+`, 200*time.Millisecond)
+
+	start := time.Now()
+	_, err := svc.PredictWithFile(context.Background(), imagePath, "")
+	assert.ErrorContains(t, err, "超时")
+	// 子进程持有stdout时也不应等到sleep结束
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+// TestExecAIServiceCommandError 测试命令失败和返回错误字段
+func TestExecAIServiceCommandError(t *testing.T) {
+	svc, imagePath := newTestExecService(t, `
+echo "CUDA out of memory" >&2
+exit 3
+`, 5*time.Second)
+	_, err := svc.PredictWithFile(context.Background(), imagePath, "")
+	assert.ErrorContains(t, err, "exit status 3")
+
+	svc, imagePath = newTestExecService(t, `echo '{"error":"model not found"}'`, 5*time.Second)
+	_, err = svc.PredictWithFile(context.Background(), imagePath, "")
+	assert.ErrorContains(t, err, "model not found")
+}
+
+// TestExecAIServiceSlotWait 测试并发名额占满时，等待会随调用方取消或超时结束
+func TestExecAIServiceSlotWait(t *testing.T) {
+	svc, imagePath := newTestExecService(t, `echo '{"has_defect":false,"confidence":0.1}'`, 300*time.Millisecond)
+	// 模拟另一个推理正在运行
+	svc.slots <- struct{}{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := svc.PredictWithFile(ctx, imagePath, "")
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	assert.Less(t, time.Since(start), 250*time.Millisecond)
+
+	_, err = svc.PredictWithFile(context.Background(), imagePath, "")
+	assert.ErrorContains(t, err, "等待推理名额超时")
+
+	// 名额释放后正常执行
+	<-svc.slots
+	result, err := svc.PredictWithFile(context.Background(), imagePath, "PU001")
+	require.NoError(t, err)
+	assert.False(t, result.HasDefect)
+	assert.Equal(t, "PU001", result.TaskID)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package services
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup Windows下不设置进程组
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup 结束推理命令进程
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
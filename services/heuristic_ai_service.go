@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeuristicModel 启发式检测的模型名称，其结果只用于提醒，不会触发暂停
+const HeuristicModel = "heuristic"
+
+// 启发式检测参数
+const (
+	heuristicWidth         = 160              // 计算前缩放到的宽度
+	heuristicEdgeLevel     = 48.0             // Sobel梯度超过该值视为边缘
+	heuristicWarmup        = 3                // 建立基线需要的帧数
+	heuristicGrowth        = 1.5              // 边缘密度和纹理能量同时增长到基线的倍数时判定为异常
+	heuristicHits          = 2                // 连续异常帧数
+	heuristicAdapt         = 0.1              // 正常帧对基线的更新权重，适应模型逐渐长高
+	heuristicResetAfter    = 15 * time.Minute // 两帧间隔超过该时间重新建立基线
+	heuristicMaxConfidence = 0.4              // 最高置信度
+)
+
+// frameStats 单帧图像统计
+type frameStats struct {
+	edgeDensity float64 // 边缘像素比例
+	texture     float64 // 拉普拉斯算子平均绝对值（高频纹理能量）
+}
+
+// HeuristicAIService 不依赖模型的纯Go检测：比较连续帧打印区域内边缘密度和高频纹理的增长，
+// 炒面和脱落的零件会让画面突然出现大量杂乱的细线。只能给出低置信度的"疑似炒面"，
+// 作为所有后端都不可用时的兜底
+type HeuristicAIService struct {
+	region [4]float64 // 打印区域 [x1, y1, x2, y2]，取值0-1
+	client *http.Client
+
+	mu       sync.Mutex
+	baseline frameStats
+	frames   int
+	hits     int
+	lastAt   time.Time
+}
+
+// newHeuristicBackend 根据options.region（"x1,y1,x2,y2"，取值0-1）创建启发式检测
+func newHeuristicBackend(cfg config.BackendConfig, deps BackendDeps) (AIService, error) {
+	svc := NewHeuristicAIService()
+	if v, ok := cfg.Options["region"]; ok {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("无效的region: %s", v)
+		}
+		for i, part := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || f < 0 || f > 1 {
+				return nil, fmt.Errorf("无效的region: %s", v)
+			}
+			svc.region[i] = f
+		}
+		if svc.region[0] >= svc.region[2] || svc.region[1] >= svc.region[3] {
+			return nil, fmt.Errorf("无效的region: %s", v)
+		}
+	}
+	return svc, nil
+}
+
+// NewHeuristicAIService 创建启发式检测，默认检测画面中下部区域
+func NewHeuristicAIService() *HeuristicAIService {
+	return &HeuristicAIService{
+		region: [4]float64{0.15, 0.2, 0.85, 0.95},
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *HeuristicAIService) Predict(ctx context.Context, imageURL string, taskID string) (*models.PredictionResult, error) {
+	if !strings.HasPrefix(imageURL, "http://") && !strings.HasPrefix(imageURL, "https://") {
+		return s.predictFile(strings.TrimPrefix(imageURL, "file://"), taskID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", imageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建下载请求失败: %v", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("下载图片失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载图片失败，状态码: %d", resp.StatusCode)
+	}
+	return s.predictReader(resp.Body, taskID, time.Now())
+}
+
+func (s *HeuristicAIService) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
+	return s.predictFile(imagePath, fileTaskID(taskID))
+}
+
+func (s *HeuristicAIService) predictFile(imagePath, taskID string) (*models.PredictionResult, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("打开图片失败: %v", err)
+	}
+	defer file.Close()
+	return s.predictReader(file, taskID, time.Now())
+}
+
+func (s *HeuristicAIService) predictReader(r io.Reader, taskID string, at time.Time) (*models.PredictionResult, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("解码图片失败: %v", err)
+	}
+	stats := computeFrameStats(img, s.region)
+	hasDefect, confidence := s.observe(stats, at)
+
+	result := &models.PredictionResult{
+		TaskID:           taskID,
+		PredictionStatus: models.StatusCompleted,
+		PredictionModel:  HeuristicModel,
+		HasDefect:        hasDefect,
+		Confidence:       models.Confidence(confidence),
+	}
+	if hasDefect {
+		result.DefectType = models.DefectSpaghetti
+	}
+	return result, nil
+}
+
+// observe 与基线比较并更新基线，返回是否异常及置信度
+func (s *HeuristicAIService) observe(stats frameStats, at time.Time) (bool, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 新的打印或长时间没有检测，重新建立基线
+	if s.frames == 0 || at.Sub(s.lastAt) > heuristicResetAfter {
+		s.baseline = stats
+		s.frames = 1
+		s.hits = 0
+		s.lastAt = at
+		return false, 0
+	}
+	s.lastAt = at
+
+	if s.frames < heuristicWarmup {
+		n := float64(s.frames)
+		s.baseline.edgeDensity = (s.baseline.edgeDensity*n + stats.edgeDensity) / (n + 1)
+		s.baseline.texture = (s.baseline.texture*n + stats.texture) / (n + 1)
+		s.frames++
+		return false, 0
+	}
+
+	edgeRatio := stats.edgeDensity / math.Max(s.baseline.edgeDensity, 0.001)
+	textureRatio := stats.texture / math.Max(s.baseline.texture, 0.1)
+	growth := math.Min(edgeRatio, textureRatio)
+
+	if growth < heuristicGrowth {
+		s.hits = 0
+		s.baseline.edgeDensity += (stats.edgeDensity - s.baseline.edgeDensity) * heuristicAdapt
+		s.baseline.texture += (stats.texture - s.baseline.texture) * heuristicAdapt
+		return false, 0
+	}
+
+	// 异常帧不更新基线，避免基线被炒面同化
+	s.hits++
+	if s.hits < heuristicHits {
+		return false, 0
+	}
+	confidence := math.Min(heuristicMaxConfidence, 0.2+(growth-heuristicGrowth)*0.2)
+	return true, confidence
+}
+
+// computeFrameStats 把打印区域缩放为灰度图后计算边缘密度和拉普拉斯纹理能量
+func computeFrameStats(img image.Image, region [4]float64) frameStats {
+	b := img.Bounds()
+	x0 := b.Min.X + int(region[0]*float64(b.Dx()))
+	y0 := b.Min.Y + int(region[1]*float64(b.Dy()))
+	x1 := b.Min.X + int(region[2]*float64(b.Dx()))
+	y1 := b.Min.Y + int(region[3]*float64(b.Dy()))
+
+	w := heuristicWidth
+	if x1-x0 < w {
+		w = x1 - x0
+	}
+	h := w * (y1 - y0) / (x1 - x0)
+	if w < 3 || h < 3 {
+		return frameStats{}
+	}
+
+	// 按块求平均缩放，同时起到降噪作用
+	gray := make([]float64, w*h)
+	for gy := 0; gy < h; gy++ {
+		sy0 := y0 + gy*(y1-y0)/h
+		sy1 := y0 + (gy+1)*(y1-y0)/h
+		for gx := 0; gx < w; gx++ {
+			sx0 := x0 + gx*(x1-x0)/w
+			sx1 := x0 + (gx+1)*(x1-x0)/w
+			var sum float64
+			var n int
+			for y := sy0; y < sy1; y++ {
+				for x := sx0; x < sx1; x++ {
+					r, g, bl, _ := img.At(x, y).RGBA()
+					sum += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+					n++
+				}
+			}
+			if n > 0 {
+				gray[gy*w+gx] = sum / float64(n)
+			}
+		}
+	}
+
+	var edges int
+	var laplacian float64
+	at := func(x, y int) float64 { return gray[y*w+x] }
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			if math.Hypot(gx, gy) > heuristicEdgeLevel {
+				edges++
+			}
+			laplacian += math.Abs(4*at(x, y) - at(x-1, y) - at(x+1, y) - at(x, y-1) - at(x, y+1))
+		}
+	}
+	inner := float64((w - 2) * (h - 2))
+	return frameStats{
+		edgeDensity: float64(edges) / inner,
+		texture:     laplacian / inner,
+	}
+}
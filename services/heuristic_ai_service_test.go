@@ -0,0 +1,86 @@
+package services
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFrame 生成模拟画面：平滑背景上的一个矩形模型，tangles条随机细线模拟炒面
+func testFrame(tangles int, seed int64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(60 + y/8)})
+		}
+	}
+	for y := 300; y < 420; y++ {
+		for x := 260; x < 380; x++ {
+			img.SetGray(x, y, color.Gray{Y: 200})
+		}
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < tangles; i++ {
+		x, y := 100+rng.Intn(440), 120+rng.Intn(320)
+		for j := 0; j < 200; j++ {
+			x += rng.Intn(5) - 2
+			y += rng.Intn(5) - 2
+			if x >= 0 && x < 640 && y >= 0 && y < 480 {
+				img.SetGray(x, y, color.Gray{Y: 250})
+				img.SetGray(x+1, y, color.Gray{Y: 250})
+			}
+		}
+	}
+	return img
+}
+
+// TestHeuristicDetectsTangles 测试画面出现大量杂乱细线时低置信度报警
+func TestHeuristicDetectsTangles(t *testing.T) {
+	svc := NewHeuristicAIService()
+	at := time.Now()
+	observe := func(img image.Image) (bool, float64) {
+		at = at.Add(3 * time.Minute)
+		return svc.observe(computeFrameStats(img, svc.region), at)
+	}
+
+	// 建立基线和正常打印
+	for i := 0; i < 5; i++ {
+		defect, _ := observe(testFrame(0, int64(i)))
+		assert.False(t, defect)
+	}
+
+	// 第一帧异常不报警，连续异常才报警
+	defect, _ := observe(testFrame(60, 100))
+	assert.False(t, defect)
+	defect, confidence := observe(testFrame(60, 101))
+	assert.True(t, defect)
+	assert.Greater(t, confidence, 0.0)
+	assert.LessOrEqual(t, confidence, heuristicMaxConfidence)
+
+	// 恢复正常后清除
+	defect, _ = observe(testFrame(0, 102))
+	assert.False(t, defect)
+}
+
+// TestHeuristicResetsBaselineAfterGap 测试长时间间隔后重新建立基线
+func TestHeuristicResetsBaselineAfterGap(t *testing.T) {
+	svc := NewHeuristicAIService()
+	at := time.Now()
+	for i := 0; i < heuristicWarmup; i++ {
+		svc.observe(computeFrameStats(testFrame(0, int64(i)), svc.region), at)
+		at = at.Add(time.Minute)
+	}
+
+	at = at.Add(heuristicResetAfter + time.Minute)
+	tangled := computeFrameStats(testFrame(60, 7), svc.region)
+	for i := 0; i < heuristicWarmup+heuristicHits; i++ {
+		defect, _ := svc.observe(tangled, at)
+		assert.False(t, defect)
+		at = at.Add(time.Minute)
+	}
+}
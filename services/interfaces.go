@@ -3,6 +3,7 @@ package services
 import (
 	"go.uber.org/zap"
 	"mingda_ai_helper/models"
+	"time"
 )
 
 // DBInterface 数据库服务接口
@@ -13,10 +14,32 @@ type DBInterface interface {
 	SaveUserSettings(settings *models.UserSettings) error
 	GetUserSettings() (*models.UserSettings, error)
 	SavePredictionResult(result *models.PredictionResult) error
+	GetPredictionResult(taskID string) (*models.PredictionResult, error)
+	SavePredictionFeedback(taskID string, feedback *models.PredictionFeedback) error
+	UpdatePredictionAction(taskID string, action models.PolicyAction, shadow bool) error
+	ListPredictions(q PredictionQuery) ([]models.PredictionResult, string, error)
+	ListCompletedPredictions(since, until time.Time) ([]models.PredictionResult, error)
+	ListExportPredictions(filter ExportFilter) ([]models.PredictionResult, error)
+	ListModelDisagreements(filter DisagreementFilter) ([]models.ModelDisagreement, error)
+	CountModelComparisons(since, until time.Time) (total, agreed int64, err error)
+	DefectStats(q StatsQuery) ([]StatsGroup, error)
+	SavePrinterAction(action *models.PrinterAction) error
+	ListPrinterActions(limit int) ([]models.PrinterAction, error)
+	ListResultDeliveries(taskID string) ([]models.ResultDelivery, error)
+	GetDefectPolicies() ([]models.DefectPolicy, error)
+	SaveDefectPolicies(policies []models.DefectPolicy) error
 }
 
 // LogInterface 日志服务接口
 type LogInterface interface {
 	Info(msg string, fields ...zap.Field)
 	Error(msg string, fields ...zap.Field)
-} 
\ No newline at end of file
+}
+
+// PrinterController 打印机控制接口
+type PrinterController interface {
+	GetPrinterStatus() (*PrinterStatus, error)
+	PausePrint() error
+	CancelPrint() error
+	Notify(message string) error
+}
@@ -6,7 +6,8 @@ import (
 )
 
 type LogService struct {
-	logger *zap.Logger
+	logger   *zap.Logger
+	redactor *Redactor
 }
 
 func NewLogService(level string, filePath string) (*LogService, error) {
@@ -19,12 +20,31 @@ func NewLogService(level string, filePath string) (*LogService, error) {
 	}
 	config.Level = zap.NewAtomicLevelAt(logLevel)
 
-	logger, err := config.Build()
+	// 所有日志输出前先脱敏
+	redactor := NewRedactor()
+	logger, err := config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newRedactCore(core, redactor)
+	}))
 	if err != nil {
 		return nil, err
 	}
 
-	return &LogService{logger: logger}, nil
+	return &LogService{logger: logger, redactor: redactor}, nil
+}
+
+// Redactor 返回日志脱敏器，标准输出重定向也应使用同一个实例
+func (s *LogService) Redactor() *Redactor {
+	return s.redactor
+}
+
+// AddSecret 登记需要从日志中隐藏的密钥值
+func (s *LogService) AddSecret(secret string) {
+	s.redactor.AddSecret(secret)
+}
+
+// SetSecret 按名称登记需要从日志中隐藏的密钥值，替换同名的旧值
+func (s *LogService) SetSecret(name, secret string) {
+	s.redactor.SetSecret(name, secret)
 }
 
 func (s *LogService) Info(msg string, fields ...zap.Field) {
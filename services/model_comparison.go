@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"math/rand"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ModelComparator 按比例抽样，用参考后端对同一张快照再预测一次，保存两个判定并记录分歧
+type ModelComparator struct {
+	cfg        config.ComparisonConfig
+	registry   *BackendRegistry
+	processor  *PredictionProcessor
+	dbService  *DBService
+	logService *LogService
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewModelComparator 创建模型对比器，未开启对比时返回nil
+func NewModelComparator(cfg config.ComparisonConfig, registry *BackendRegistry, processor *PredictionProcessor, dbService *DBService, logService *LogService) *ModelComparator {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &ModelComparator{
+		cfg:        cfg,
+		registry:   registry,
+		processor:  processor,
+		dbService:  dbService,
+		logService: logService,
+		rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Sample 是否对本次检测做对比，c为nil时总是false
+func (c *ModelComparator) Sample() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rnd.Float64() < c.cfg.SampleRate
+}
+
+// Reference 参考后端，与本次使用的后端相同、不可用或云端AI未开启时返回nil
+func (c *ModelComparator) Reference(used *Backend, settings *models.UserSettings) *Backend {
+	backend, err := c.registry.Get(c.cfg.Reference)
+	if err != nil || backend.Name == used.Name || !backend.Available() {
+		return nil
+	}
+	if backend.Type == "mingda-cloud" && !settings.EnableCloudAI {
+		return nil
+	}
+	return backend
+}
+
+// Compare 用参考后端预测快照并与已处理的结果比较。primary必须已经过Process换算
+func (c *ModelComparator) Compare(ctx context.Context, reference *Backend, snap Snapshot, primary *models.PredictionResult) {
+	result, err := reference.PredictSnapshot(ctx, snap)
+	if err != nil {
+		c.logService.Error("模型对比预测失败", zap.String("backend", reference.Name), zap.Error(err))
+		return
+	}
+	if result == nil || result.PredictionStatus != models.StatusCompleted {
+		// 异步返回的后端无法在这里取得结果
+		c.logService.Debug("参考后端未同步返回结果，跳过对比", zap.String("backend", reference.Name))
+		return
+	}
+	if err := c.processor.Normalize(result); err != nil {
+		c.logService.Error("模型对比结果无效", zap.String("backend", reference.Name), zap.Error(err))
+		return
+	}
+
+	comparison := &models.ModelComparison{
+		TaskID:    primary.TaskID,
+		ImagePath: snap.ImagePath,
+		Primary:   models.VerdictOf(primary),
+		Reference: models.VerdictOf(result),
+	}
+	kind := comparison.Compare(c.cfg.ConfidenceGap)
+	if err := c.dbService.SaveModelComparison(comparison, kind); err != nil {
+		c.logService.Error("保存模型对比结果失败", zap.Error(err))
+		return
+	}
+	if kind != "" {
+		c.logService.Info("模型判定不一致",
+			zap.String("task_id", primary.TaskID),
+			zap.String("kind", string(kind)),
+			zap.String("primary", primary.Backend),
+			zap.String("reference", reference.Name))
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestModelComparatorRecordsDisagreement 测试参考后端判定不一致时记录分歧
+func TestModelComparatorRecordsDisagreement(t *testing.T) {
+	processor, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, EnableCloudAI: true, ConfidenceThreshold: 80})
+	logService, err := NewLogService("error", filepath.Join(t.TempDir(), "test.log"))
+	require.NoError(t, err)
+
+	registry := NewBackendRegistry(NewHealthMonitor(logService))
+	require.NoError(t, registry.Build([]config.BackendConfig{
+		{Name: "local", Type: "mock", Options: map[string]string{"has_defect": "true", "defect_type": "string", "confidence": "0.9"}},
+		{Name: "cloud", Type: "mock", Options: map[string]string{"has_defect": "false", "confidence": "0.1"}},
+	}, BackendDeps{LogService: logService}))
+	processor.backends = registry
+
+	comparator := NewModelComparator(config.ComparisonConfig{Enabled: true, Reference: "cloud", SampleRate: 1, ConfidenceGap: 0.3},
+		registry, processor, db, logService)
+	assert.True(t, comparator.Sample())
+
+	local, err := registry.Get("local")
+	require.NoError(t, err)
+	settings := &models.UserSettings{EnableCloudAI: true}
+	cloud, err := registry.Get("cloud")
+	require.NoError(t, err)
+	assert.Nil(t, comparator.Reference(cloud, settings))
+	reference := comparator.Reference(local, settings)
+	require.NotNil(t, reference)
+
+	snap := Snapshot{TaskID: "PT001", ImageURL: "http://camera/snapshot", ImagePath: "/tmp/snapshot.jpg"}
+	result, err := local.PredictSnapshot(context.Background(), snap)
+	require.NoError(t, err)
+	require.NoError(t, processor.Process(result))
+	assert.Equal(t, models.DefectStringing, result.DefectType)
+
+	comparator.Compare(context.Background(), reference, snap, result)
+
+	until := time.Now().Add(time.Minute)
+	total, agreed, err := db.CountModelComparisons(until.Add(-time.Hour), until)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, int64(0), agreed)
+
+	disagreements, err := db.ListModelDisagreements(DisagreementFilter{Since: until.Add(-time.Hour), Until: until})
+	require.NoError(t, err)
+	require.Len(t, disagreements, 1)
+	assert.Equal(t, models.DisagreeVerdict, disagreements[0].Kind)
+	assert.Equal(t, "local", disagreements[0].Comparison.Primary.Backend)
+	assert.Equal(t, "cloud", disagreements[0].Comparison.Reference.Backend)
+	assert.Equal(t, "/tmp/snapshot.jpg", disagreements[0].Comparison.ImagePath)
+}
+
+// TestModelComparisonKinds 测试分歧类型的判定
+func TestModelComparisonKinds(t *testing.T) {
+	verdict := func(hasDefect bool, defectType models.DefectType, confidence models.Confidence) models.ModelVerdict {
+		return models.ModelVerdict{HasDefect: hasDefect, DefectType: defectType, Confidence: confidence}
+	}
+	cases := []struct {
+		primary, reference models.ModelVerdict
+		kind               models.DisagreementKind
+	}{
+		{verdict(false, "", 0.1), verdict(false, "", 0.9), ""},
+		{verdict(true, models.DefectBlob, 0.8), verdict(false, "", 0.2), models.DisagreeVerdict},
+		{verdict(true, models.DefectBlob, 0.8), verdict(true, models.DefectSpaghetti, 0.8), models.DisagreeDefectType},
+		{verdict(true, models.DefectBlob, 0.9), verdict(true, models.DefectBlob, 0.5), models.DisagreeConfidence},
+		{verdict(true, models.DefectBlob, 0.9), verdict(true, models.DefectBlob, 0.7), ""},
+	}
+	for _, tc := range cases {
+		c := models.ModelComparison{Primary: tc.primary, Reference: tc.reference}
+		assert.Equal(t, tc.kind, c.Compare(0.3))
+	}
+}
+
+// TestModelComparisonAsyncPrimary 测试主后端异步返回时，在回调结果处理后对比
+func TestModelComparisonAsyncPrimary(t *testing.T) {
+	processor, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, EnableCloudAI: true, ConfidenceThreshold: 80})
+	logService := processor.logService.(*LogService)
+
+	// 本地后端受理后使用自己的任务ID，结果通过回调返回
+	local := &fakeQueueAI{predict: func(ctx context.Context, taskID string) (*models.PredictionResult, error) {
+		return &models.PredictionResult{TaskID: "REMOTE-" + taskID, PredictionStatus: models.StatusProcessing}, nil
+	}}
+	registry := NewBackendRegistry(NewHealthMonitor(logService))
+	registry.Add("local", "http-json", InputURL, nil, local, "")
+	require.NoError(t, registry.Build([]config.BackendConfig{
+		{Name: "cloud", Type: "mock", Options: map[string]string{"has_defect": "false", "confidence": "0.1"}},
+	}, BackendDeps{LogService: logService}))
+	processor.backends = registry
+	queue := NewPredictionQueue(config.QueueConfig{JobTimeout: 1, CallbackTimeout: 60}, registry, processor, db, logService)
+	processor.SetPredictionQueue(queue)
+
+	comparator := NewModelComparator(config.ComparisonConfig{Enabled: true, Reference: "cloud", SampleRate: 1, ConfidenceGap: 0.3},
+		registry, processor, db, logService)
+	primary, err := registry.Get("local")
+	require.NoError(t, err)
+	reference := comparator.Reference(primary, &models.UserSettings{EnableCloudAI: true})
+	require.NotNil(t, reference)
+
+	compared := make(chan string, 2)
+	submit := func(taskID string) {
+		snap := Snapshot{TaskID: taskID, ImageURL: "http://camera/snapshot", ImagePath: "/tmp/" + taskID + ".jpg"}
+		_, err := queue.Submit(PredictionRequest{
+			TaskID: taskID, Backend: "local", ImageURL: snap.ImageURL, ImagePath: snap.ImagePath, Priority: models.PriorityPeriodic,
+			OnResult: func(result *models.PredictionResult) {
+				comparator.Compare(context.Background(), reference, snap, result)
+				compared <- result.TaskID
+			},
+		})
+		require.NoError(t, err)
+		require.True(t, queue.RunNext(context.Background(), "local"))
+	}
+	submit("PT001")
+	submit("PT002")
+	assert.Empty(t, compared)
+
+	// PT001的回调到达，PT002等待超时
+	require.NoError(t, processor.Process(&models.PredictionResult{
+		TaskID: "REMOTE-PT001", PredictionModel: "yolo", HasDefect: true, DefectType: "string", Confidence: 0.9,
+	}))
+	queue.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	queue.ExpireWaiting()
+	require.Len(t, compared, 1)
+	assert.Equal(t, "REMOTE-PT001", <-compared)
+
+	until := time.Now().Add(time.Minute)
+	disagreements, err := db.ListModelDisagreements(DisagreementFilter{Since: until.Add(-time.Hour), Until: until})
+	require.NoError(t, err)
+	require.Len(t, disagreements, 1)
+	assert.Equal(t, models.DisagreeVerdict, disagreements[0].Kind)
+	assert.Equal(t, "REMOTE-PT001", disagreements[0].Comparison.TaskID)
+	assert.Equal(t, "local", disagreements[0].Comparison.Primary.Backend)
+	assert.Equal(t, models.DefectStringing, disagreements[0].Comparison.Primary.DefectType)
+	assert.Equal(t, "/tmp/PT001.jpg", disagreements[0].Comparison.ImagePath)
+	assert.Empty(t, queue.hooks)
+}
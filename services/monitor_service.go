@@ -3,24 +3,28 @@ package services
 import (
 	"context"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
-	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+
 	"go.uber.org/zap"
 )
 
 // MonitorService 监控服务
 type MonitorService struct {
 	moonrakerClient *MoonrakerClient
-	aiService       AIService
-	cloudAIService  AIService  // 添加云端AI服务
+	registry        *BackendRegistry
+	routing         config.MonitorRoutingConfig
 	dbService       *DBService
 	logService      *LogService
+	queue           *PredictionQueue
+	snapshots       *SnapshotStore
+	comparator      *ModelComparator
+	events          *EventBus
 	
 	ctx            context.Context
 	cancel         context.CancelFunc
@@ -32,23 +36,37 @@ type MonitorService struct {
 
 	// AI服务计数器
 	aiCounter int
+
+	// 当前打印任务，打印文件变化或打印时长变短时视为新的打印
+	session         string
+	sessionFile     string
+	sessionDuration float64
+
+	// 上次观察到的打印机状态
+	printerState string
 }
 
 // NewMonitorService 创建新的监控服务
 func NewMonitorService(
 	moonrakerClient *MoonrakerClient,
-	localAIService AIService,
-	cloudAIService AIService,
+	registry *BackendRegistry,
+	routing config.MonitorRoutingConfig,
 	dbService *DBService,
 	logService *LogService,
+	queue *PredictionQueue,
+	snapshots *SnapshotStore,
+	comparator *ModelComparator,
 ) *MonitorService {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &MonitorService{
 		moonrakerClient:     moonrakerClient,
-		aiService:           localAIService,
-		cloudAIService:      cloudAIService,
+		registry:            registry,
+		routing:             routing,
 		dbService:           dbService,
 		logService:          logService,
+		queue:               queue,
+		snapshots:           snapshots,
+		comparator:          comparator,
 		ctx:                 ctx,
 		cancel:             cancel,
 		statusCheckInterval: time.Second * 30,    // 30秒检查一次状态
@@ -57,6 +75,11 @@ func NewMonitorService(
 	}
 }
 
+// SetEventBus 设置事件总线，推送打印机状态、拍照和预测开始事件
+func (s *MonitorService) SetEventBus(events *EventBus) {
+	s.events = events
+}
+
 // Start 启动监控服务
 func (s *MonitorService) Start() error {
 	s.logService.Info("监控服务启动")
@@ -121,33 +144,8 @@ func (s *MonitorService) getSnapshot(url string) (string, error) {
 		return "", fmt.Errorf("获取快照失败，状态码: %d", resp.StatusCode)
 	}
 
-	// 生成保存路径
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("获取用户主目录失败: %v", err)
-	}
-	
-	timestamp := time.Now().Format("20060102_150405")
-	savePath := filepath.Join(homeDir, "printer_data", "ai_snapshots", fmt.Sprintf("snapshot_%s.jpg", timestamp))
-
-	// 创建保存目录
-	if err := os.MkdirAll(filepath.Dir(savePath), 0755); err != nil {
-		return "", fmt.Errorf("创建目录失败: %v", err)
-	}
-
-	// 创建文件
-	file, err := os.Create(savePath)
-	if err != nil {
-		return "", fmt.Errorf("创建文件失败: %v", err)
-	}
-	defer file.Close()
-
-	// 将响应内容写入文件
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		return "", fmt.Errorf("保存图片失败: %v", err)
-	}
-
-	return savePath, nil
+	// 保存到快照目录
+	return s.snapshots.Save("snapshot", ".jpg", resp.Body)
 }
 
 // monitor 监控打印状态
@@ -162,6 +160,17 @@ func (s *MonitorService) monitor() {
 		case <-s.ctx.Done():
 			return
 		case <-statusTicker.C:
+			// 获取打印状态，AI功能关闭时也推送状态变化
+			status, err := s.moonrakerClient.GetPrinterStatus()
+			if err != nil {
+				s.logService.Error("获取打印机状态失败", zap.Error(err))
+				continue
+			}
+			s.observeState(status)
+			if !status.IsPrinting() {
+				s.finishSession(status)
+			}
+
 			// 获取用户设置
 			settings, err := s.dbService.GetUserSettings()
 			if err != nil {
@@ -175,14 +184,6 @@ func (s *MonitorService) monitor() {
 				continue
 			}
 
-
-			// 获取打印状态
-			status, err := s.moonrakerClient.GetPrinterStatus()
-			if err != nil {
-				s.logService.Error("获取打印机状态失败", zap.Error(err))
-				continue
-			}
-
 			// 如果不在打印状态，跳过检查
 			if !status.IsPrinting() {
 				s.logService.Info("不在打印状态，跳过检查")
@@ -211,12 +212,25 @@ func (s *MonitorService) monitor() {
 				s.logService.Error("获取打印机状态失败", zap.Error(err))
 				continue
 			}
+			s.observeState(status)
 
 			// 如果不在打印状态，跳过拍照
 			if !status.IsPrinting() {
 				continue
 			}
 
+			session := s.printSession(status)
+
+			// 选择本次检测使用的后端，都不可用时使用兜底检测
+			backend := s.selectBackend(settings)
+			if backend == nil {
+				backend = s.fallbackBackend()
+			}
+			if backend == nil {
+				s.logService.Debug("没有可用的AI后端，跳过本次检测")
+				continue
+			}
+
 			// 获取本地IP
 			localIP, err := s.getLocalIP()
 			if err != nil {
@@ -235,53 +249,152 @@ func (s *MonitorService) monitor() {
 				continue
 			}
 
-			// 选择AI服务（每4次循环使用1次云端服务）
-			var currentAIService AIService
-			useCloudAI := s.aiCounter%4 == 3 && settings.EnableCloudAI
-			if useCloudAI {
-				currentAIService = s.cloudAIService
-				s.logService.Info("使用云端AI服务")
-			} else {
-				currentAIService = s.aiService
-				s.logService.Info("使用本地AI服务")
-			}
-			s.aiCounter++
-
-			// 调用AI服务进行预测
-			s.logService.Info("开始AI预测", 
-				zap.String("image_path", savePath),
-				zap.Bool("use_cloud", useCloudAI))
-
 			// 生成任务ID
 			taskID := fmt.Sprintf("PT%s", time.Now().Format("20060102150405"))
-
-			// 创建初始预测结果
-			// result := &models.PredictionResult{
-			// 	TaskID:           taskID,
-			// 	PredictionStatus: models.StatusProcessing,
-			// 	PredictionModel:  "local_ai",
-			// }
-
-			// // 保存初始预测结果
-			// if err := s.dbService.SavePredictionResult(result); err != nil {
-			// 	s.logService.Error("保存初始预测结果失败", zap.Error(err))
-			// 	continue
-			// }
-
-			// var err error
-			if useCloudAI {
-				_, err = currentAIService.PredictWithFile(s.ctx, savePath)
-			} else {
-				_, err = currentAIService.Predict(s.ctx, cameraURL, taskID)
+			s.events.Publish(EventSnapshotTaken, map[string]interface{}{
+				"task_id": taskID, "image_path": savePath, "session": session,
+			})
+
+			// 加入预测队列，结果处理完成后（异步后端在回调之后）抽样对比
+			snap := Snapshot{
+				TaskID:    taskID,
+				ImageURL:  cameraURL,
+				ImagePath: savePath,
 			}
-
-			if err != nil {
-				s.logService.Error("AI预测失败", zap.Error(err))
+			if _, err := s.queue.Submit(PredictionRequest{
+				TaskID:    taskID,
+				Backend:   backend.Name,
+				ImageURL:  cameraURL,
+				ImagePath: savePath,
+				Session:   session,
+				Priority:  models.PriorityPeriodic,
+				OnResult: func(result *models.PredictionResult) {
+					s.compare(backend, settings, snap, result)
+				},
+			}); err != nil {
+				s.logService.Error("提交预测任务失败", zap.String("task_id", taskID), zap.Error(err))
 				continue
 			}
+			s.logService.Info("已提交预测任务",
+				zap.String("task_id", taskID),
+				zap.String("image_path", savePath),
+				zap.String("backend", backend.Name))
+		}
+	}
+}
 
-			s.logService.Info("预测请求已发送，等待回调处理",
-				zap.String("task_id", taskID))
+// observeState 打印机状态变化时推送事件
+func (s *MonitorService) observeState(status *PrinterStatus) {
+	state := status.PrintStats.State
+	if state == s.printerState {
+		return
+	}
+	previous := s.printerState
+	s.printerState = state
+	s.events.Publish(EventPrinterState, map[string]interface{}{
+		"state":    state,
+		"previous": previous,
+		"filename": status.PrintStats.Filename,
+		"progress": status.VirtualSdcard.Progress,
+	})
+}
+
+// printSession 当前打印任务的ID，格式为PS加打印开始时间。新的打印任务同时记录到数据库
+func (s *MonitorService) printSession(status *PrinterStatus) string {
+	file, duration := status.PrintStats.Filename, status.PrintStats.TotalDuration
+	if s.session == "" || file != s.sessionFile || duration < s.sessionDuration {
+		start := time.Now().Add(-time.Duration(duration * float64(time.Second)))
+		s.session = "PS" + start.Format("20060102150405")
+		s.sessionFile = file
+
+		session := &models.PrintSession{Session: s.session, Filename: file, StartedAt: start}
+		if info, err := s.dbService.GetMachineInfo(); err == nil && info != nil {
+			session.Printer = info.MachineSN
+		}
+		if err := s.dbService.StartPrintSession(session); err != nil {
+			s.logService.Error("记录打印任务失败", zap.Error(err))
 		}
 	}
-} 
\ No newline at end of file
+	s.sessionDuration = duration
+	return s.session
+}
+
+// finishSession 打印结束、取消或出错时记录打印任务的结果
+func (s *MonitorService) finishSession(status *PrinterStatus) {
+	state := models.PrintSessionState(status.PrintStats.State)
+	if s.session == "" || !state.Finished() {
+		return
+	}
+	if err := s.dbService.FinishPrintSession(s.session, state, time.Now()); err != nil {
+		s.logService.Error("记录打印任务结果失败", zap.Error(err))
+		return
+	}
+	s.session = ""
+}
+
+// selectBackend 选择本次检测使用的后端：每SecondaryEvery次使用一次secondary，
+// 选中的后端熔断或未开启云端AI时改用另一个
+func (s *MonitorService) selectBackend(settings *models.UserSettings) *Backend {
+	candidates := []string{s.routing.Primary}
+	if s.routing.Secondary != "" {
+		if s.aiCounter%s.routing.SecondaryEvery == s.routing.SecondaryEvery-1 {
+			candidates = []string{s.routing.Secondary, s.routing.Primary}
+		} else {
+			candidates = append(candidates, s.routing.Secondary)
+		}
+	}
+	s.aiCounter++
+
+	for _, name := range candidates {
+		if name == s.routing.Fallback {
+			continue
+		}
+		backend, err := s.registry.Get(name)
+		if err != nil {
+			s.logService.Error("获取AI后端失败", zap.Error(err))
+			continue
+		}
+		// 云端后端需要用户开启云端AI
+		if backend.Type == "mingda-cloud" && !settings.EnableCloudAI {
+			continue
+		}
+		if !backend.Available() {
+			continue
+		}
+		return backend
+	}
+	return nil
+}
+
+// fallbackBackend 所有后端都不可用时使用的兜底后端
+func (s *MonitorService) fallbackBackend() *Backend {
+	if s.routing.Fallback == "" || s.routing.Fallback == "none" {
+		return nil
+	}
+	backend, err := s.registry.Get(s.routing.Fallback)
+	if err != nil {
+		s.logService.Error("获取兜底AI后端失败", zap.Error(err))
+		return nil
+	}
+	if !backend.Available() {
+		return nil
+	}
+	s.logService.Info("所有AI后端不可用，使用兜底检测", zap.String("backend", backend.Name))
+	return backend
+}
+
+// compare 抽样用参考后端对同一张快照再预测一次，兜底检测的结果不参与对比
+func (s *MonitorService) compare(used *Backend, settings *models.UserSettings, snap Snapshot, result *models.PredictionResult) {
+	if used.Name == s.routing.Fallback || !s.comparator.Sample() {
+		return
+	}
+	reference := s.comparator.Reference(used, settings)
+	if reference == nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.comparator.Compare(s.ctx, reference, snap, result)
+	}()
+}
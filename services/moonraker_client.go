@@ -13,6 +13,7 @@ import (
     "go.uber.org/zap"
 	"github.com/gorilla/websocket"
 	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
 )
 
 // PrinterStatus 打印机状态
@@ -265,24 +266,53 @@ func (c *MoonrakerClient) PausePrint() error {
 	time.Sleep(3 * time.Second)
 	
 	// 第三步：发送暂停命令
-	req, err := http.NewRequest("POST", c.baseURL+"/printer/print/pause", nil)
+	return c.SendPrintCommand(models.CommandPause)
+}
+
+// CancelPrint 取消打印
+func (c *MoonrakerClient) CancelPrint() error {
+	if err := c.sendGCodeCommand("M118 AI detected a critical printing error, cancelling"); err != nil {
+		return fmt.Errorf("发送M118消息失败: %v", err)
+	}
+	return c.SendPrintCommand(models.CommandCancel)
+}
+
+// SendPrintCommand 直接发送暂停、恢复或取消打印指令，不显示提醒消息
+func (c *MoonrakerClient) SendPrintCommand(command models.PrinterCommand) error {
+	req, err := http.NewRequest("POST", c.baseURL+"/printer/print/"+string(command), nil)
 	if err != nil {
-		return fmt.Errorf("创建暂停打印请求失败: %v", err)
+		return fmt.Errorf("创建%s请求失败: %v", command, err)
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("发送暂停打印请求失败: %v", err)
+		return fmt.Errorf("发送%s请求失败: %v", command, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("暂停打印失败，状态码: %d", resp.StatusCode)
+		return fmt.Errorf("执行%s失败，状态码: %d", command, resp.StatusCode)
 	}
 
 	return nil
 }
 
+// Notify 通过M118在控制台显示提醒消息
+func (c *MoonrakerClient) Notify(message string) error {
+	// 消息会拼进JSON和G-code，去掉引号、反斜杠和换行
+	message = strings.Map(func(r rune) rune {
+		switch r {
+		case '"', '\\', '\n', '\r':
+			return ' '
+		}
+		return r
+	}, message)
+	if err := c.sendGCodeCommand("M118 " + message); err != nil {
+		return fmt.Errorf("发送M118消息失败: %v", err)
+	}
+	return nil
+}
+
 // GetPrintProgress 获取打印进度
 func (c *MoonrakerClient) GetPrintProgress() (float64, error) {
 	resp, err := c.httpClient.Get(c.baseURL + "/printer/objects/query?print_stats")
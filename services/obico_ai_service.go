@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// obicoDefaultThreshold Obico判定为失败的默认置信度，与Obico服务端的低阈值一致
+const obicoDefaultThreshold = 0.38
+
+// ObicoAIService 自建的Obico（原The Spaghetti Detective）ML API后端，
+// 调用 GET /p/?img=<图片URL>，由ML API自行下载图片
+type ObicoAIService struct {
+	baseURL    string
+	threshold  float64 // 0-1
+	httpClient *http.Client
+	authHeader string
+	authValue  string
+}
+
+// obicoResponse ML API返回的检测结果，每个检测为 [标签, 置信度, [中心x, 中心y, 宽, 高]]
+type obicoResponse struct {
+	Detections []obicoDetection `json:"detections"`
+}
+
+type obicoDetection struct {
+	Label      string
+	Confidence float64
+	Box        [4]float64
+}
+
+// UnmarshalJSON 解析 [label, confidence, [xc, yc, w, h]] 形式的检测元组
+func (d *obicoDetection) UnmarshalJSON(data []byte) error {
+	var tuple []json.RawMessage
+	if err := json.Unmarshal(data, &tuple); err != nil {
+		return err
+	}
+	if len(tuple) != 3 {
+		return fmt.Errorf("检测结果格式错误: %s", string(data))
+	}
+	if err := json.Unmarshal(tuple[0], &d.Label); err != nil {
+		return fmt.Errorf("解析检测标签失败: %v", err)
+	}
+	if err := json.Unmarshal(tuple[1], &d.Confidence); err != nil {
+		return fmt.Errorf("解析检测置信度失败: %v", err)
+	}
+	if err := json.Unmarshal(tuple[2], &d.Box); err != nil {
+		return fmt.Errorf("解析检测框失败: %v", err)
+	}
+	return nil
+}
+
+// newObicoBackend 根据url、auth和options.threshold创建Obico后端
+func newObicoBackend(cfg config.BackendConfig, deps BackendDeps) (AIService, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("缺少url")
+	}
+	svc := NewObicoAIService(cfg.URL, time.Duration(cfg.Timeout)*time.Second)
+
+	if v, ok := cfg.Options["threshold"]; ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("无效的threshold: %s", v)
+		}
+		svc.threshold = f
+	}
+
+	switch cfg.Auth.Type {
+	case "", "none":
+	case "bearer":
+		// ML API设置了ML_API_TOKEN时需要
+		svc.authHeader, svc.authValue = "Authorization", "Bearer "+cfg.Auth.Token
+	case "header":
+		if cfg.Auth.Header == "" {
+			return nil, fmt.Errorf("auth.type为header时必须配置auth.header")
+		}
+		svc.authHeader, svc.authValue = cfg.Auth.Header, cfg.Auth.Token
+	default:
+		return nil, fmt.Errorf("obico后端不支持认证方式: %s", cfg.Auth.Type)
+	}
+	return svc, nil
+}
+
+// NewObicoAIService 创建Obico ML API服务
+func NewObicoAIService(baseURL string, timeout time.Duration) *ObicoAIService {
+	return &ObicoAIService{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		threshold:  obicoDefaultThreshold,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *ObicoAIService) Predict(ctx context.Context, imageURL string, taskID string) (*models.PredictionResult, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.baseURL+"/p/?img="+url.QueryEscape(imageURL), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	if s.authHeader != "" {
+		req.Header.Set(s.authHeader, s.authValue)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求Obico ML API失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Obico ML API返回状态码: %d, body: %s", resp.StatusCode, truncate(string(body), 200))
+	}
+
+	var obicoResp obicoResponse
+	if err := json.Unmarshal(body, &obicoResp); err != nil {
+		return nil, fmt.Errorf("解析Obico响应失败: %v", err)
+	}
+	return s.toResult(taskID, obicoResp.Detections), nil
+}
+
+// PredictWithFile ML API只能按URL下载图片
+func (s *ObicoAIService) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
+	return nil, fmt.Errorf("obico后端不支持本地文件，请使用图片URL")
+}
+
+// toResult 把检测元组转换为预测结果，取最高置信度的检测
+func (s *ObicoAIService) toResult(taskID string, detections []obicoDetection) *models.PredictionResult {
+	result := &models.PredictionResult{
+		TaskID:           taskID,
+		PredictionStatus: models.StatusCompleted,
+		PredictionModel:  "obico",
+	}
+
+	var best *obicoDetection
+	for i := range detections {
+		d := &detections[i]
+		xc, yc, w, h := d.Box[0], d.Box[1], d.Box[2], d.Box[3]
+		result.Detections = append(result.Detections, models.Detection{
+			Label:      d.Label,
+			Confidence: models.Confidence(d.Confidence),
+			BBox:       []float64{xc - w/2, yc - h/2, xc + w/2, yc + h/2},
+		})
+		if best == nil || d.Confidence > best.Confidence {
+			best = d
+		}
+	}
+
+	if best != nil {
+		result.Confidence = models.Confidence(best.Confidence)
+		if best.Confidence >= s.threshold {
+			result.HasDefect = true
+			// Obico模型只有一个failure类别，对应炒面
+			result.DefectType = models.DefectSpaghetti
+		}
+	}
+	return result
+}
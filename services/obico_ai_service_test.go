@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestObicoAIServiceDetections 测试检测元组转换为预测结果
+func TestObicoAIServiceDetections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/p/", r.URL.Path)
+		assert.Equal(t, "http://192.168.1.20/webcam/?action=snapshot", r.URL.Query().Get("img"))
+		assert.Equal(t, "Bearer ml-token", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"detections": [["failure", 0.21, [100, 80, 40, 20]], ["failure", 0.64, [320, 240, 60, 50]]]}`))
+	}))
+	defer server.Close()
+
+	svc := NewObicoAIService(server.URL+"/", 5*time.Second)
+	svc.authHeader, svc.authValue = "Authorization", "Bearer ml-token"
+
+	result, err := svc.Predict(context.Background(), "http://192.168.1.20/webcam/?action=snapshot", "TASK001")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCompleted, result.PredictionStatus)
+	assert.True(t, result.HasDefect)
+	assert.Equal(t, models.DefectSpaghetti, result.DefectType)
+	assert.InDelta(t, 0.64, float64(result.Confidence), 0.001)
+	require.Len(t, result.Detections, 2)
+	assert.Equal(t, []float64{80, 70, 120, 90}, result.Detections[0].BBox)
+	assert.InDelta(t, 0.21, float64(result.Detections[0].Confidence), 0.001)
+}
+
+// TestObicoAIServiceBelowThreshold 测试低于阈值或无检测时不判定为缺陷
+func TestObicoAIServiceBelowThreshold(t *testing.T) {
+	svc := NewObicoAIService("http://localhost:3333", time.Second)
+
+	result := svc.toResult("TASK002", []obicoDetection{{Label: "failure", Confidence: 0.2, Box: [4]float64{10, 10, 4, 4}}})
+	assert.False(t, result.HasDefect)
+	assert.InDelta(t, 0.2, float64(result.Confidence), 0.001)
+
+	result = svc.toResult("TASK003", nil)
+	assert.False(t, result.HasDefect)
+	assert.Zero(t, result.Confidence)
+	assert.Empty(t, result.Detections)
+}
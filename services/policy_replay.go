@@ -0,0 +1,149 @@
+package services
+
+import (
+	"fmt"
+	"mingda_ai_helper/models"
+	"time"
+)
+
+const (
+	// replayStreakGap 相邻两次预测间隔超过该时间视为不同的打印，连续命中次数清零
+	replayStreakGap = 10 * time.Minute
+	// replayMaxEvents 每个候选方案最多返回的动作明细
+	replayMaxEvents = 50
+)
+
+// ReplayCandidate 待评估的阈值或策略方案
+type ReplayCandidate struct {
+	Name string `json:"name"`
+	// ConfidenceThreshold 覆盖全局阈值和各缺陷类型的阈值(0-100)，为nil时不覆盖
+	ConfidenceThreshold *int `json:"confidence_threshold"`
+	// Policies 替换当前的缺陷处理策略，为空时使用当前策略
+	Policies []models.DefectPolicy `json:"policies"`
+}
+
+// ReplayEvent 回放中触发的一次动作
+type ReplayEvent struct {
+	TaskID     string              `json:"task_id"`
+	Time       time.Time           `json:"time"`
+	DefectType models.DefectType   `json:"defect_type"`
+	Confidence float64             `json:"confidence"` // 百分比
+	Action     models.PolicyAction `json:"action"`
+	Reason     string              `json:"reason"`
+	// Feedback 用户对该结果的反馈，没有反馈时为空
+	Feedback models.FeedbackVerdict `json:"feedback,omitempty"`
+}
+
+// ReplayFeedback 按用户反馈统计方案的效果，只统计有反馈的结果
+type ReplayFeedback struct {
+	Labelled    int `json:"labelled"`     // 有反馈的结果数
+	TruePauses  int `json:"true_pauses"`  // 暂停或取消的结果确实有缺陷
+	FalsePauses int `json:"false_pauses"` // 暂停或取消的结果被标记为误报
+	Missed      int `json:"missed"`       // 实际有缺陷但该帧没有暂停或取消
+}
+
+// ReplayReport 单个方案的回放结果
+type ReplayReport struct {
+	Name        string                    `json:"name"`
+	Predictions int                       `json:"predictions"`
+	Notifies    int                       `json:"notifies"`
+	Pauses      int                       `json:"pauses"`
+	Cancels     int                       `json:"cancels"`
+	ByDefect    map[models.DefectType]int `json:"by_defect"` // 各缺陷类型触发的暂停和取消次数
+	Events      []ReplayEvent             `json:"events"`
+	Feedback    ReplayFeedback            `json:"feedback"`
+}
+
+// CandidatePolicySet 由当前策略、用户设置和候选方案生成判定策略。
+// 回放评估的是开启自动暂停后的效果，因此总是允许暂停和取消
+func CandidatePolicySet(candidate ReplayCandidate, policies []models.DefectPolicy, settings *models.UserSettings) (PolicySet, error) {
+	if len(candidate.Policies) > 0 {
+		for i := range candidate.Policies {
+			if err := candidate.Policies[i].Validate(); err != nil {
+				return PolicySet{}, err
+			}
+		}
+		policies = candidate.Policies
+	}
+
+	s := *settings
+	s.PauseOnThreshold = true
+	if t := candidate.ConfidenceThreshold; t != nil {
+		if *t < 0 || *t > 100 {
+			return PolicySet{}, fmt.Errorf("置信度阈值必须在0-100之间")
+		}
+		s.ConfidenceThreshold = *t
+		overridden := make([]models.DefectPolicy, len(policies))
+		for i, policy := range policies {
+			policy.Threshold = *t
+			overridden[i] = policy
+		}
+		policies = overridden
+	}
+	return NewPolicySet(policies, &s), nil
+}
+
+// ReplayPolicies 按时间顺序用策略重新判定已保存的预测结果，统计会触发的动作
+func ReplayPolicies(name string, set PolicySet, results []models.PredictionResult) ReplayReport {
+	report := ReplayReport{
+		Name:     name,
+		ByDefect: make(map[models.DefectType]int),
+		Events:   []ReplayEvent{},
+	}
+	streaks := make(DefectStreaks)
+
+	var last time.Time
+	for i := range results {
+		result := &results[i]
+		if !last.IsZero() && result.CreatedAt.Sub(last) > replayStreakGap {
+			streaks = make(DefectStreaks)
+		}
+		last = result.CreatedAt
+		report.Predictions++
+
+		decision := EvaluatePolicy(set, streaks, result)
+		report.Feedback.count(result.Feedback.Verdict, decision.Action)
+		switch decision.Action {
+		case models.ActionNone:
+			continue
+		case models.ActionNotify:
+			report.Notifies++
+		case models.ActionPause:
+			report.Pauses++
+			report.ByDefect[result.DefectType]++
+		case models.ActionCancel:
+			report.Cancels++
+			report.ByDefect[result.DefectType]++
+		}
+
+		if len(report.Events) < replayMaxEvents {
+			report.Events = append(report.Events, ReplayEvent{
+				TaskID:     result.TaskID,
+				Time:       result.CreatedAt,
+				DefectType: result.DefectType,
+				Confidence: result.Confidence.Percent(),
+				Action:     decision.Action,
+				Reason:     decision.Reason,
+				Feedback:   result.Feedback.Verdict,
+			})
+		}
+	}
+	return report
+}
+
+// count 统计一条有反馈的结果
+func (f *ReplayFeedback) count(verdict models.FeedbackVerdict, action models.PolicyAction) {
+	if verdict == "" {
+		return
+	}
+	f.Labelled++
+	stopped := action.Severity() > models.ActionNotify.Severity()
+	switch {
+	case stopped && verdict.Defective():
+		f.TruePauses++
+	case stopped:
+		f.FalsePauses++
+	case verdict.Defective():
+		f.Missed++
+	}
+}
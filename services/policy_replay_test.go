@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReplayPolicies 测试回放统计和长间隔清零连续命中
+func TestReplayPolicies(t *testing.T) {
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	offsets := []time.Duration{0, time.Minute, time.Hour, time.Hour + time.Minute, 2 * time.Hour}
+	confidences := []models.Confidence{0.7, 0.7, 0.7, 0.5, 0.9}
+	var results []models.PredictionResult
+	for i := range offsets {
+		r := models.PredictionResult{PredictionModel: "yolo", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: confidences[i]}
+		r.CreatedAt = start.Add(offsets[i])
+		results = append(results, r)
+	}
+
+	// 第2帧暂停被标记为误报，第3帧实际有缺陷
+	results[1].Feedback.Verdict = models.FeedbackFalsePositive
+	results[2].Feedback.Verdict = models.FeedbackTruePositive
+
+	settings := &models.UserSettings{ConfidenceThreshold: 80}
+	set, err := CandidatePolicySet(ReplayCandidate{}, models.DefaultDefectPolicies(), settings)
+	require.NoError(t, err)
+	report := ReplayPolicies("current", set, results)
+	assert.Equal(t, 5, report.Predictions)
+	assert.Equal(t, 1, report.Pauses)
+	assert.Equal(t, 1, report.ByDefect[models.DefectSpaghetti])
+	require.Len(t, report.Events, 1)
+	assert.Equal(t, start.Add(time.Minute), report.Events[0].Time)
+	assert.Equal(t, models.FeedbackFalsePositive, report.Events[0].Feedback)
+	assert.Equal(t, ReplayFeedback{Labelled: 2, FalsePauses: 1, Missed: 1}, report.Feedback)
+
+	// 阈值40、连续1次：每个缺陷结果都会暂停
+	threshold := 40
+	set, err = CandidatePolicySet(ReplayCandidate{ConfidenceThreshold: &threshold, Policies: []models.DefectPolicy{
+		{DefectType: models.DefectSpaghetti, Enabled: true, Threshold: 90, ConsecutiveHits: 1, Action: models.ActionPause},
+	}}, models.DefaultDefectPolicies(), settings)
+	require.NoError(t, err)
+	loose := ReplayPolicies("loose", set, results)
+	assert.Equal(t, 5, loose.Pauses)
+	assert.Equal(t, ReplayFeedback{Labelled: 2, TruePauses: 1, FalsePauses: 1}, loose.Feedback)
+
+	// 暂停未开启时回放仍按允许暂停评估
+	assert.True(t, set.AllowStop)
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"mingda_ai_helper/models"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PredictionProcessor 处理已完成的预测结果：保存结果，并按缺陷处理策略提醒、暂停或取消打印。
+// 回调接口和同步返回结果的后端共用同一套处理流程。
+type PredictionProcessor struct {
+	backends   *BackendRegistry
+	dbService  DBInterface
+	printer    PrinterController
+	logService LogInterface
+	dataset    *DatasetService
+	events     *EventBus
+	deliveries *ResultDeliveryService
+	jobs       *PredictionQueue
+
+	mu      sync.Mutex
+	streaks DefectStreaks
+}
+
+// NewPredictionProcessor 创建预测结果处理器，backends用于按后端换算置信度，可以为nil
+func NewPredictionProcessor(backends *BackendRegistry, dbService DBInterface, printer PrinterController, logService LogInterface) *PredictionProcessor {
+	return &PredictionProcessor{
+		backends:   backends,
+		dbService:  dbService,
+		printer:    printer,
+		logService: logService,
+		streaks:    make(DefectStreaks),
+	}
+}
+
+// SetDatasetService 设置训练样本服务，不确定的结果保存后加入上传队列
+func (p *PredictionProcessor) SetDatasetService(dataset *DatasetService) {
+	p.dataset = dataset
+}
+
+// SetEventBus 设置事件总线，推送预测结果和暂停事件
+func (p *PredictionProcessor) SetEventBus(events *EventBus) {
+	p.events = events
+}
+
+// SetDeliveryService 设置结果推送服务，结果保存后推送到/predict请求中的callback_url
+func (p *PredictionProcessor) SetDeliveryService(deliveries *ResultDeliveryService) {
+	p.deliveries = deliveries
+}
+
+// SetPredictionQueue 设置预测任务队列，结果保存后结束对应的任务
+func (p *PredictionProcessor) SetPredictionQueue(jobs *PredictionQueue) {
+	p.jobs = jobs
+}
+
+// Fail 后端报告预测失败时结束对应的任务，不保存结果，也不执行缺陷处理策略
+func (p *PredictionProcessor) Fail(taskID, message string) {
+	p.logService.Error("AI后端报告预测失败", zap.String("task_id", taskID), zap.String("error", message))
+	p.jobs.Failed(taskID, message)
+}
+
+// Process 换算置信度、保存预测结果并执行缺陷处理策略。
+// 置信度超出后端声明的刻度时返回ErrInvalidConfidence，结果不会保存，对应的任务记为失败
+func (p *PredictionProcessor) Process(result *models.PredictionResult) error {
+	if err := p.normalize(result); err != nil {
+		if errors.Is(err, ErrInvalidConfidence) {
+			p.jobs.Failed(result.TaskID, err.Error())
+		}
+		return err
+	}
+
+	result.PredictionStatus = models.StatusCompleted
+	if err := p.dbService.SavePredictionResult(result); err != nil {
+		return fmt.Errorf("保存预测结果失败: %v", err)
+	}
+	p.jobs.Finished(result)
+	// 推送的结果包含策略判定的动作
+	defer func() {
+		p.events.Publish(EventPredictionCompleted, *result)
+		p.deliveries.Ready(result.TaskID)
+	}()
+
+	settings, err := p.dbService.GetUserSettings()
+	if err != nil {
+		return fmt.Errorf("获取用户设置失败: %v", err)
+	}
+	p.dataset.Consider(settings, result)
+
+	policies, err := p.dbService.GetDefectPolicies()
+	if err != nil {
+		return fmt.Errorf("获取缺陷处理策略失败: %v", err)
+	}
+
+	p.mu.Lock()
+	decision := EvaluatePolicy(NewPolicySet(policies, settings), p.streaks, result)
+	p.mu.Unlock()
+
+	if decision.Action == models.ActionNone {
+		return nil
+	}
+	result.Action, result.Shadow = decision.Action, settings.ShadowMode
+	if err := p.dbService.UpdatePredictionAction(result.TaskID, decision.Action, settings.ShadowMode); err != nil {
+		p.logService.Error("记录策略动作失败", zap.String("task_id", result.TaskID), zap.Error(err))
+	}
+	if settings.ShadowMode {
+		p.logService.Info("影子模式，未执行策略动作",
+			zap.String("task_id", result.TaskID),
+			zap.String("would_action", string(decision.Action)),
+			zap.String("defect_type", string(result.DefectType)),
+			zap.Float64("confidence", float64(result.Confidence)),
+			zap.String("reason", decision.Reason))
+		return nil
+	}
+	p.execute(decision, result)
+	return nil
+}
+
+// Normalize 换算置信度并映射缺陷类型，不保存也不执行策略，用于只需要统一判定的场景（如模型对比）
+func (p *PredictionProcessor) Normalize(result *models.PredictionResult) error {
+	return p.normalize(result)
+}
+
+// normalize 按产生结果的后端换算置信度并映射缺陷类型。回调结果不带后端名称和快照路径，从等待中的任务获取
+func (p *PredictionProcessor) normalize(result *models.PredictionResult) error {
+	if result.Backend == "" || result.ImagePath == "" || result.Session == "" || result.LatencyMs == 0 {
+		existing, err := p.dbService.GetPredictionResult(result.TaskID)
+		if err != nil {
+			return fmt.Errorf("获取预测任务失败: %v", err)
+		}
+		if existing != nil {
+			if result.Backend == "" {
+				result.Backend = existing.Backend
+			}
+			if result.ImagePath == "" {
+				result.ImagePath = existing.ImagePath
+			}
+			if result.Session == "" {
+				result.Session = existing.Session
+			}
+			// 异步任务的耗时从创建任务算起
+			if result.LatencyMs == 0 && existing.PredictionStatus != models.StatusCompleted {
+				result.LatencyMs = time.Since(existing.CreatedAt).Milliseconds()
+			}
+		}
+	}
+
+	var normalizer *ConfidenceNormalizer
+	var defects *DefectMapper
+	if p.backends != nil && result.Backend != "" {
+		if backend, err := p.backends.Get(result.Backend); err == nil {
+			normalizer, defects = backend.Confidence, backend.Defects
+		}
+	}
+	if err := normalizer.NormalizeResult(result); err != nil {
+		return err
+	}
+	defects.MapResult(result)
+	return nil
+}
+
+// execute 执行策略动作，暂停和取消只在打印机正在打印时执行
+func (p *PredictionProcessor) execute(decision PolicyDecision, result *models.PredictionResult) {
+	fields := []zap.Field{
+		zap.String("task_id", result.TaskID),
+		zap.Float64("confidence", float64(result.Confidence)),
+		zap.String("defect_type", string(result.DefectType)),
+		zap.String("action", string(decision.Action)),
+		zap.String("reason", decision.Reason),
+	}
+
+	if decision.Action == models.ActionNotify {
+		message := fmt.Sprintf("AI helper: possible %s detected (%s, %.0f%%)", result.DefectType, result.PredictionModel, result.Confidence.Percent())
+		if err := p.printer.Notify(message); err != nil {
+			p.logService.Error("发送提醒失败", append(fields, zap.Error(err))...)
+			return
+		}
+		p.logService.Info("已发送缺陷提醒", fields...)
+		return
+	}
+
+	status, err := p.printer.GetPrinterStatus()
+	if err != nil {
+		p.logService.Error("获取打印机状态失败", zap.Error(err))
+		return
+	}
+	if status == nil || !status.IsPrinting() {
+		return
+	}
+
+	action := &models.PrinterAction{
+		Source:      models.SourcePolicy,
+		Actor:       result.Backend,
+		Reason:      decision.Reason,
+		TaskID:      result.TaskID,
+		StateBefore: status.PrintStats.State,
+	}
+	defer func() {
+		if err := p.dbService.SavePrinterAction(action); err != nil {
+			p.logService.Error("记录打印控制操作失败", zap.Error(err))
+		}
+	}()
+
+	switch decision.Action {
+	case models.ActionPause:
+		action.Command = models.CommandPause
+		if err := p.printer.PausePrint(); err != nil {
+			action.Error = err.Error()
+			p.logService.Error("暂停打印失败", append(fields, zap.Error(err))...)
+			return
+		}
+		p.logService.Info("已暂停打印", fields...)
+		p.events.Publish(EventPauseIssued, map[string]interface{}{
+			"task_id":     result.TaskID,
+			"defect_type": result.DefectType,
+			"confidence":  result.Confidence,
+			"reason":      decision.Reason,
+		})
+	case models.ActionCancel:
+		action.Command = models.CommandCancel
+		if err := p.printer.CancelPrint(); err != nil {
+			action.Error = err.Error()
+			p.logService.Error("取消打印失败", append(fields, zap.Error(err))...)
+			return
+		}
+		p.logService.Info("已取消打印", fields...)
+	}
+}
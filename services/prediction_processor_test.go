@@ -0,0 +1,119 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePrinter 记录暂停和提醒的打印机
+type fakePrinter struct {
+	state    string
+	paused    int
+	cancelled int
+	messages  []string
+}
+
+func (p *fakePrinter) GetPrinterStatus() (*PrinterStatus, error) {
+	status := &PrinterStatus{}
+	status.PrintStats.State = p.state
+	status.VirtualSdcard.IsActive = p.state == "printing"
+	return status, nil
+}
+
+func (p *fakePrinter) PausePrint() error {
+	p.paused++
+	return nil
+}
+
+func (p *fakePrinter) CancelPrint() error {
+	p.cancelled++
+	return nil
+}
+
+func (p *fakePrinter) Notify(message string) error {
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+// newTestProcessor 使用临时数据库创建处理器
+func newTestProcessor(t *testing.T, settings *models.UserSettings) (*PredictionProcessor, *fakePrinter, *DBService) {
+	dir := t.TempDir()
+	db, err := NewDBService(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.SaveUserSettings(settings))
+
+	logService, err := NewLogService("error", filepath.Join(dir, "test.log"))
+	require.NoError(t, err)
+
+	printer := &fakePrinter{state: "printing"}
+	return NewPredictionProcessor(nil, db, printer, logService), printer, db
+}
+
+// TestProcessorAppliesDefectPolicies 测试按缺陷类型的策略处理：炒面连续命中后暂停，拉丝只提醒
+func TestProcessorAppliesDefectPolicies(t *testing.T) {
+	processor, printer, db := newTestProcessor(t, &models.UserSettings{
+		EnableAI: true, ConfidenceThreshold: 80, PauseOnThreshold: true,
+	})
+
+	require.NoError(t, processor.Process(&models.PredictionResult{
+		TaskID: "TASK001", PredictionModel: "yolo", HasDefect: true, DefectType: "spaghetti", Confidence: 0.65,
+	}))
+	assert.Equal(t, 0, printer.paused)
+	require.NoError(t, processor.Process(&models.PredictionResult{
+		TaskID: "TASK002", PredictionModel: "yolo", HasDefect: true, DefectType: "spaghetti", Confidence: 0.70,
+	}))
+	assert.Equal(t, 1, printer.paused)
+
+	saved, err := db.GetPredictionResult("TASK001")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCompleted, saved.PredictionStatus)
+
+	// 拉丝改为取消后，连续命中时取消打印
+	require.NoError(t, db.SaveDefectPolicies([]models.DefectPolicy{
+		{DefectType: "stringing", Enabled: true, Threshold: 50, ConsecutiveHits: 1, Action: models.ActionCancel},
+	}))
+	require.NoError(t, processor.Process(&models.PredictionResult{
+		TaskID: "TASK003", PredictionModel: "yolo", HasDefect: true, DefectType: "stringing", Confidence: 0.55,
+	}))
+	assert.Equal(t, 1, printer.cancelled)
+}
+
+// TestProcessorHeuristicOnlyNotifies 测试启发式检测只提醒不暂停
+func TestProcessorHeuristicOnlyNotifies(t *testing.T) {
+	processor, printer, _ := newTestProcessor(t, &models.UserSettings{
+		EnableAI: true, ConfidenceThreshold: 10, PauseOnThreshold: true,
+	})
+
+	require.NoError(t, processor.Process(&models.PredictionResult{
+		TaskID: "TASK002", PredictionModel: HeuristicModel, HasDefect: true, DefectType: "spaghetti", Confidence: 0.35,
+	}))
+	assert.Equal(t, 0, printer.paused)
+	require.Len(t, printer.messages, 1)
+	assert.Contains(t, printer.messages[0], "spaghetti")
+}
+
+// TestProcessorShadowMode 测试影子模式只记录动作不暂停
+func TestProcessorShadowMode(t *testing.T) {
+	processor, printer, db := newTestProcessor(t, &models.UserSettings{
+		EnableAI: true, ConfidenceThreshold: 80, PauseOnThreshold: true, ShadowMode: true,
+	})
+
+	for _, taskID := range []string{"TASK001", "TASK002"} {
+		require.NoError(t, processor.Process(&models.PredictionResult{
+			TaskID: taskID, PredictionModel: "yolo", HasDefect: true, DefectType: "spaghetti", Confidence: 0.9,
+		}))
+	}
+	assert.Equal(t, 0, printer.paused)
+	assert.Empty(t, printer.messages)
+
+	saved, err := db.GetPredictionResult("TASK002")
+	require.NoError(t, err)
+	assert.Equal(t, models.ActionPause, saved.Action)
+	assert.True(t, saved.Shadow)
+}
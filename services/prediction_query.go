@@ -0,0 +1,88 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mingda_ai_helper/models"
+	"time"
+)
+
+const (
+	DefaultPredictionLimit = 50    // 每页默认条数
+	MaxPredictionLimit     = 200   // 每页最大条数
+	MaxPredictionExport    = 10000 // 一次最多导出的条数
+)
+
+// PredictionSort 预测历史的排序字段
+type PredictionSort string
+
+const (
+	SortByTime       PredictionSort = "created_at"
+	SortByConfidence PredictionSort = "confidence"
+)
+
+// PredictionQuery 预测历史查询条件，零值表示不限
+type PredictionQuery struct {
+	Since      time.Time
+	Until      time.Time
+	HasDefect  *bool
+	DefectType models.DefectType
+	Backend    string
+	Status     *models.PredictionStatus
+	Session    string
+	Sort       PredictionSort // 为空时按时间
+	Asc        bool           // 默认降序
+	Cursor     string         // 上一页返回的next_cursor
+	Limit      int
+}
+
+// predictionCursor 上一页最后一条记录的位置。按置信度排序时同时记录置信度(百分比，保留6位小数)
+type predictionCursor struct {
+	Confidence float64 `json:"c,omitempty"`
+	ID         uint    `json:"id"`
+}
+
+// cursorConfidence 游标中的置信度，与数据库中ROUND(confidence, 6)比较
+func cursorConfidence(c models.Confidence) float64 {
+	return math.Round(c.Percent()*1e6) / 1e6
+}
+
+func encodeCursor(cursor predictionCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*predictionCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("无效的cursor")
+	}
+	var cursor predictionCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == 0 {
+		return nil, fmt.Errorf("无效的cursor")
+	}
+	return &cursor, nil
+}
+
+// Validate 校验查询条件
+func (q *PredictionQuery) Validate() error {
+	switch q.Sort {
+	case "", SortByTime, SortByConfidence:
+	default:
+		return fmt.Errorf("不支持的排序字段: %s", q.Sort)
+	}
+	if q.DefectType != "" && !q.DefectType.Valid() {
+		return fmt.Errorf("未知的缺陷类型: %s", q.DefectType)
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && !q.Since.Before(q.Until) {
+		return fmt.Errorf("since必须早于until")
+	}
+	if q.Cursor != "" {
+		if _, err := decodeCursor(q.Cursor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestListPredictionsPagination 测试按时间和置信度排序的游标分页
+func TestListPredictionsPagination(t *testing.T) {
+	_, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	// 置信度有重复，分页时按id区分
+	for i, confidence := range []models.Confidence{0.3, 0.7, 0.7, 0.1, 0.7} {
+		require.NoError(t, db.SavePredictionResult(&models.PredictionResult{
+			TaskID: fmt.Sprintf("PT%03d", i+1), PredictionModel: "yolo", PredictionStatus: models.StatusCompleted,
+			HasDefect: confidence > 0.5, Confidence: confidence, Session: "PS1",
+		}))
+	}
+
+	collect := func(q PredictionQuery) []string {
+		var ids []string
+		for {
+			results, next, err := db.ListPredictions(q)
+			require.NoError(t, err)
+			for _, r := range results {
+				ids = append(ids, r.TaskID)
+			}
+			if next == "" {
+				return ids
+			}
+			q.Cursor = next
+		}
+	}
+
+	assert.Equal(t, []string{"PT005", "PT004", "PT003", "PT002", "PT001"}, collect(PredictionQuery{Limit: 2}))
+	assert.Equal(t, []string{"PT001", "PT002", "PT003", "PT004", "PT005"}, collect(PredictionQuery{Limit: 3, Asc: true}))
+	assert.Equal(t, []string{"PT005", "PT003", "PT002", "PT001", "PT004"}, collect(PredictionQuery{Limit: 2, Sort: SortByConfidence}))
+	assert.Equal(t, []string{"PT004", "PT001", "PT002", "PT003", "PT005"}, collect(PredictionQuery{Limit: 1, Sort: SortByConfidence, Asc: true}))
+
+	hasDefect := true
+	assert.Equal(t, []string{"PT005", "PT003", "PT002"}, collect(PredictionQuery{HasDefect: &hasDefect}))
+	assert.Empty(t, collect(PredictionQuery{Session: "PS2"}))
+
+	q := PredictionQuery{Cursor: "not-a-cursor"}
+	assert.Error(t, q.Validate())
+	q = PredictionQuery{Sort: "size"}
+	assert.Error(t, q.Validate())
+}
+
+// TestProcessorKeepsSession 测试回调结果沿用异步任务记录的打印任务
+func TestProcessorKeepsSession(t *testing.T) {
+	processor, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{
+		TaskID: "PT001", PredictionModel: "local_ai", PredictionStatus: models.StatusProcessing,
+	}))
+	require.NoError(t, db.UpdatePredictionSource("PT001", "cloud", "PS20261019080000", "/tmp/snapshot.jpg"))
+
+	require.NoError(t, processor.Process(&models.PredictionResult{TaskID: "PT001", PredictionModel: "yolo", Confidence: 0.2}))
+	saved, err := db.GetPredictionResult("PT001")
+	require.NoError(t, err)
+	assert.Equal(t, "PS20261019080000", saved.Session)
+	assert.Equal(t, "/tmp/snapshot.jpg", saved.ImagePath)
+	assert.Equal(t, models.StatusCompleted, saved.PredictionStatus)
+}
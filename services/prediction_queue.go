@@ -0,0 +1,388 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// queuePollInterval worker没有被唤醒时检查队列的间隔，同时用于检查回调超时
+	queuePollInterval = 5 * time.Second
+	// waitPollInterval Wait检查任务状态的间隔
+	waitPollInterval = 200 * time.Millisecond
+)
+
+// ErrTaskExists 任务ID已被使用
+var ErrTaskExists = errors.New("任务ID已存在")
+
+// PredictionRequest 提交到队列的预测任务
+type PredictionRequest struct {
+	TaskID    string
+	Backend   string // 为空时使用默认后端
+	ImageURL  string
+	ImagePath string
+	Session   string
+	Priority  int
+	// CallbackURL 任务结束后推送结果的地址
+	CallbackURL string
+	// OnResult 结果处理完成后调用，异步后端在回调结果处理后调用；任务失败或超时时不调用。
+	// 只保存在内存中，重启后不再调用
+	OnResult func(*models.PredictionResult)
+}
+
+// PredictionQueue 保存在数据库中的预测任务队列。每个后端有固定数量的worker，
+// 手动检测优先于定时检测；重启后未被后端受理的任务重新排队，等待回调的任务继续等待到截止时间
+type PredictionQueue struct {
+	cfg        config.QueueConfig
+	backends   *BackendRegistry
+	processor  *PredictionProcessor
+	dbService  *DBService
+	logService LogInterface
+	events     *EventBus
+	deliveries *ResultDeliveryService
+	now        func() time.Time
+
+	mu    sync.Mutex
+	wake  map[string]chan struct{}
+	hooks map[string]func(*models.PredictionResult)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPredictionQueue 创建预测任务队列
+func NewPredictionQueue(cfg config.QueueConfig, backends *BackendRegistry, processor *PredictionProcessor, dbService *DBService, logService LogInterface) *PredictionQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &PredictionQueue{
+		cfg:        cfg,
+		backends:   backends,
+		processor:  processor,
+		dbService:  dbService,
+		logService: logService,
+		now:        time.Now,
+		wake:       make(map[string]chan struct{}),
+		hooks:      make(map[string]func(*models.PredictionResult)),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// SetEventBus 设置事件总线，任务开始处理时推送prediction_started
+func (q *PredictionQueue) SetEventBus(events *EventBus) {
+	q.events = events
+}
+
+// SetDeliveryService 设置结果推送服务，失败和超时的任务也推送给调用方
+func (q *PredictionQueue) SetDeliveryService(deliveries *ResultDeliveryService) {
+	q.deliveries = deliveries
+}
+
+// Submit 把任务加入队列
+func (q *PredictionQueue) Submit(req PredictionRequest) (*models.PredictionJob, error) {
+	backend, err := q.backends.Resolve(req.Backend)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.PredictionJob{
+		TaskID:    req.TaskID,
+		Backend:   backend.Name,
+		Priority:  req.Priority,
+		ImageURL:  req.ImageURL,
+		ImagePath: req.ImagePath,
+		Session:   req.Session,
+	}
+
+	// 先登记回调，worker可能在入队后立即取走任务；任务ID重复时不能覆盖已有任务的回调
+	registered := false
+	if req.OnResult != nil {
+		q.mu.Lock()
+		if _, exists := q.hooks[req.TaskID]; !exists {
+			q.hooks[req.TaskID] = req.OnResult
+			registered = true
+		}
+		q.mu.Unlock()
+	}
+	if err := q.dbService.EnqueuePredictionJob(job, req.CallbackURL); err != nil {
+		if registered {
+			q.takeHook(req.TaskID)
+		}
+		return nil, err
+	}
+	q.notify(backend.Name)
+	return job, nil
+}
+
+// Finished 预测结果处理完成后由PredictionProcessor调用，结束对应的任务并调用提交时登记的OnResult。
+// 同步返回和回调返回的结果都经过这里
+func (q *PredictionQueue) Finished(result *models.PredictionResult) {
+	if q == nil {
+		return
+	}
+	if _, err := q.dbService.FinishPredictionJob(result.TaskID, models.StatusCompleted, "", q.now()); err != nil {
+		q.logService.Error("更新预测任务状态失败", zap.String("task_id", result.TaskID), zap.Error(err))
+	}
+	if hook := q.takeHook(result.TaskID); hook != nil {
+		// Process在回调返回后还会记录策略动作，回调拿到的是副本
+		copied := *result
+		hook(&copied)
+	}
+}
+
+// Failed 回调结果无效、无法保存时由PredictionProcessor调用，以失败结束任务，不再等待到回调超时
+func (q *PredictionQueue) Failed(taskID, message string) {
+	if q == nil {
+		return
+	}
+	q.finish(taskID, models.StatusFailed, message)
+}
+
+// Wait 等待任务结束，返回预测记录。ctx结束时返回当前的记录，调用方按状态判断是否完成。
+// 后端使用自己生成的任务ID时，返回的记录为新的ID
+func (q *PredictionQueue) Wait(ctx context.Context, taskID string) (*models.PredictionResult, error) {
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := q.dbService.GetPredictionJob(taskID)
+		if err != nil {
+			return nil, err
+		}
+		if job == nil {
+			return nil, fmt.Errorf("预测任务不存在: %s", taskID)
+		}
+		result, err := q.dbService.GetPredictionResult(job.TaskID)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil && result.PredictionStatus.Finished() {
+			return result, nil
+		}
+		select {
+		case <-ctx.Done():
+			return result, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Start 恢复重启前的任务，并为每个后端启动worker
+func (q *PredictionQueue) Start() error {
+	names := q.backends.Names()
+	requeued, failed, err := q.dbService.RecoverPredictionJobs(names, q.now())
+	if err != nil {
+		return err
+	}
+	if requeued > 0 || failed > 0 {
+		q.logService.Info("已恢复预测队列", zap.Int64("requeued", requeued), zap.Int64("failed", failed))
+	}
+
+	for _, name := range names {
+		backend, err := q.backends.Get(name)
+		if err != nil {
+			continue
+		}
+		workers := backend.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+		wake := make(chan struct{}, workers)
+		q.mu.Lock()
+		q.wake[name] = wake
+		q.mu.Unlock()
+
+		for i := 0; i < workers; i++ {
+			q.wg.Add(1)
+			go func() {
+				defer q.wg.Done()
+				q.work(backend, wake)
+			}()
+		}
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		ticker := time.NewTicker(queuePollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-q.ctx.Done():
+				return
+			case <-ticker.C:
+				q.ExpireWaiting()
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop 停止worker，正在请求后端的任务重启后重新发送
+func (q *PredictionQueue) Stop() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+func (q *PredictionQueue) work(backend *Backend, wake chan struct{}) {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for {
+		if q.RunNext(q.ctx, backend.Name) {
+			continue
+		}
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// notify 唤醒后端的一个空闲worker
+func (q *PredictionQueue) notify(backend string) {
+	q.mu.Lock()
+	wake := q.wake[backend]
+	q.mu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *PredictionQueue) takeHook(taskID string) func(*models.PredictionResult) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	hook := q.hooks[taskID]
+	delete(q.hooks, taskID)
+	return hook
+}
+
+// rebindHook 后端使用自己生成的任务ID时，回调结果按新的ID查找OnResult
+func (q *PredictionQueue) rebindHook(oldID, newID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if hook, ok := q.hooks[oldID]; ok {
+		delete(q.hooks, oldID)
+		q.hooks[newID] = hook
+	}
+}
+
+// RunNext 处理后端的下一个任务，队列为空时返回false
+func (q *PredictionQueue) RunNext(ctx context.Context, backendName string) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	job, err := q.dbService.ClaimPredictionJob(backendName, q.now())
+	if err != nil {
+		q.logService.Error("获取预测任务失败", zap.String("backend", backendName), zap.Error(err))
+		return false
+	}
+	if job == nil {
+		return false
+	}
+	backend, err := q.backends.Get(backendName)
+	if err != nil {
+		q.finish(job.TaskID, models.StatusFailed, err.Error())
+		return true
+	}
+	q.run(ctx, backend, job)
+	return true
+}
+
+func (q *PredictionQueue) run(ctx context.Context, backend *Backend, job *models.PredictionJob) {
+	q.events.Publish(EventPredictionStarted, map[string]interface{}{
+		"task_id":  job.TaskID,
+		"backend":  backend.Name,
+		"session":  job.Session,
+		"priority": job.Priority,
+	})
+	reqCtx, cancel := context.WithTimeout(ctx, time.Duration(q.cfg.JobTimeout)*time.Second)
+	defer cancel()
+	result, err := backend.PredictSnapshot(reqCtx, Snapshot{
+		TaskID:    job.TaskID,
+		ImageURL:  job.ImageURL,
+		ImagePath: job.ImagePath,
+	})
+	switch {
+	case ctx.Err() != nil:
+		// 停止服务时中断，任务保持处理中，重启后重新排队
+		return
+	case errors.Is(reqCtx.Err(), context.DeadlineExceeded):
+		q.finish(job.TaskID, models.StatusTimeout, "请求AI后端超时")
+		return
+	case err != nil:
+		q.finish(job.TaskID, models.StatusFailed, err.Error())
+		return
+	case result == nil:
+		q.finish(job.TaskID, models.StatusFailed, "AI后端没有返回结果")
+		return
+	}
+
+	// 同步返回结果的后端直接处理，Process保存结果后通过Finished结束任务
+	if result.PredictionStatus == models.StatusCompleted {
+		result.TaskID, result.Session = job.TaskID, job.Session
+		if result.ImagePath == "" {
+			result.ImagePath = job.ImagePath
+		}
+		if err := q.processor.Process(result); err != nil {
+			q.finish(job.TaskID, models.StatusFailed, err.Error())
+		}
+		return
+	}
+
+	// 异步后端受理后等待回调
+	taskID := job.TaskID
+	if result.TaskID != "" && result.TaskID != job.TaskID {
+		if err := q.dbService.RebindPredictionJob(job.TaskID, result.TaskID); err != nil {
+			q.logService.Error("更新预测任务ID失败", zap.String("task_id", job.TaskID), zap.Error(err))
+		} else {
+			taskID = result.TaskID
+			q.rebindHook(job.TaskID, taskID)
+		}
+		if err := q.dbService.UpdatePredictionSource(taskID, backend.Name, job.Session, job.ImagePath); err != nil {
+			q.logService.Error("记录预测任务后端失败", zap.Error(err))
+		}
+	}
+	now := q.now()
+	if err := q.dbService.DispatchPredictionJob(taskID, now, now.Add(time.Duration(q.cfg.CallbackTimeout)*time.Second)); err != nil {
+		q.logService.Error("更新预测任务状态失败", zap.String("task_id", taskID), zap.Error(err))
+	}
+	q.logService.Info("预测请求已发送，等待回调处理", zap.String("task_id", taskID), zap.String("backend", backend.Name))
+}
+
+// ExpireWaiting 结束等待回调超时的任务
+func (q *PredictionQueue) ExpireWaiting() {
+	jobs, err := q.dbService.ListExpiredPredictionJobs(q.now())
+	if err != nil {
+		q.logService.Error("获取超时的预测任务失败", zap.Error(err))
+		return
+	}
+	for _, job := range jobs {
+		q.finish(job.TaskID, models.StatusTimeout, "未在等待时间内收到回调")
+	}
+}
+
+// finish 以失败或超时结束任务，并把结果推送给调用方。任务没有结果，不调用OnResult
+func (q *PredictionQueue) finish(taskID string, status models.PredictionStatus, message string) {
+	q.takeHook(taskID)
+	finished, err := q.dbService.FinishPredictionJob(taskID, status, truncate(message, 255), q.now())
+	if err != nil {
+		q.logService.Error("更新预测任务状态失败", zap.String("task_id", taskID), zap.Error(err))
+		return
+	}
+	if !finished {
+		return
+	}
+	q.logService.Error("预测任务未完成",
+		zap.String("task_id", taskID),
+		zap.String("status", status.String()),
+		zap.String("error", message))
+	q.deliveries.Ready(taskID)
+}
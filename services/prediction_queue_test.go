@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeQueueAI 按任务ID返回预设结果的AI后端，记录调用顺序
+type fakeQueueAI struct {
+	calls   []string
+	predict func(ctx context.Context, taskID string) (*models.PredictionResult, error)
+}
+
+func (a *fakeQueueAI) Predict(ctx context.Context, imageURL string, taskID string) (*models.PredictionResult, error) {
+	a.calls = append(a.calls, taskID)
+	return a.predict(ctx, taskID)
+}
+
+func (a *fakeQueueAI) PredictWithFile(ctx context.Context, imagePath string, taskID string) (*models.PredictionResult, error) {
+	return nil, errors.New("不支持本地文件")
+}
+
+// newTestQueue 创建使用临时数据库的队列，worker不启动，由测试调用RunNext
+func newTestQueue(t *testing.T, ai *fakeQueueAI) (*PredictionQueue, *DBService) {
+	processor, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	registry := NewBackendRegistry(NewHealthMonitor(processor.logService))
+	registry.Add("local", "fake", InputURL, nil, ai, "")
+	processor.backends = registry
+	queue := NewPredictionQueue(config.QueueConfig{JobTimeout: 1, CallbackTimeout: 60}, registry, processor, db, processor.logService)
+	processor.SetPredictionQueue(queue)
+	return queue, db
+}
+
+func getJob(t *testing.T, db *DBService, taskID string) models.PredictionJob {
+	var job models.PredictionJob
+	require.NoError(t, db.db.Where("task_id = ?", taskID).First(&job).Error)
+	return job
+}
+
+// TestPredictionQueuePriority 测试手动检测优先于定时检测，以及成功、失败和超时的状态
+func TestPredictionQueuePriority(t *testing.T) {
+	ai := &fakeQueueAI{predict: func(ctx context.Context, taskID string) (*models.PredictionResult, error) {
+		switch taskID {
+		case "FAIL":
+			return nil, errors.New("connection refused")
+		case "SLOW":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &models.PredictionResult{TaskID: taskID, PredictionStatus: models.StatusCompleted, PredictionModel: "yolo", Confidence: 0.1}, nil
+	}}
+	queue, db := newTestQueue(t, ai)
+
+	var hooked []string
+	for _, req := range []PredictionRequest{
+		{TaskID: "PT1", ImageURL: "http://camera/1", Priority: models.PriorityPeriodic, OnResult: func(r *models.PredictionResult) {
+			hooked = append(hooked, r.TaskID)
+		}},
+		{TaskID: "FAIL", ImageURL: "http://camera/2", Priority: models.PriorityPeriodic},
+		{TaskID: "MANUAL", ImageURL: "http://example.com/3", Priority: models.PriorityManual},
+		{TaskID: "SLOW", ImageURL: "http://camera/4", Priority: models.PriorityPeriodic},
+	} {
+		_, err := queue.Submit(req)
+		require.NoError(t, err)
+	}
+	_, err := queue.Submit(PredictionRequest{TaskID: "PT1"})
+	assert.ErrorIs(t, err, ErrTaskExists)
+	_, err = queue.Submit(PredictionRequest{TaskID: "PT9", Backend: "missing"})
+	assert.ErrorIs(t, err, ErrUnknownBackend)
+
+	for queue.RunNext(context.Background(), "local") {
+	}
+	assert.Equal(t, []string{"MANUAL", "PT1", "FAIL", "SLOW"}, ai.calls)
+	assert.Equal(t, []string{"PT1"}, hooked)
+
+	for taskID, status := range map[string]models.PredictionStatus{
+		"MANUAL": models.StatusCompleted,
+		"PT1":    models.StatusCompleted,
+		"FAIL":   models.StatusFailed,
+		"SLOW":   models.StatusTimeout,
+	} {
+		job := getJob(t, db, taskID)
+		assert.Equal(t, status, job.Status, taskID)
+		assert.NotNil(t, job.FinishedAt, taskID)
+		result, err := db.GetPredictionResult(taskID)
+		require.NoError(t, err)
+		assert.Equal(t, status, result.PredictionStatus, taskID)
+	}
+	assert.Contains(t, getJob(t, db, "FAIL").Error, "connection refused")
+}
+
+// TestPredictionQueueCallbackTimeout 测试异步后端受理后等待回调，超过截止时间记为超时
+func TestPredictionQueueCallbackTimeout(t *testing.T) {
+	ai := &fakeQueueAI{predict: func(ctx context.Context, taskID string) (*models.PredictionResult, error) {
+		return &models.PredictionResult{TaskID: taskID, PredictionStatus: models.StatusProcessing}, nil
+	}}
+	queue, db := newTestQueue(t, ai)
+	now := time.Now()
+	queue.now = func() time.Time { return now }
+
+	for _, taskID := range []string{"T1", "T2"} {
+		_, err := queue.Submit(PredictionRequest{TaskID: taskID, ImageURL: "http://example.com/a.jpg", Priority: models.PriorityManual})
+		require.NoError(t, err)
+		assert.True(t, queue.RunNext(context.Background(), "local"))
+	}
+	job := getJob(t, db, "T1")
+	assert.Equal(t, models.StatusProcessing, job.Status)
+	require.NotNil(t, job.Deadline)
+	assert.WithinDuration(t, now.Add(time.Minute), *job.Deadline, time.Second)
+
+	// T1的回调在截止时间前到达
+	require.NoError(t, queue.processor.Process(&models.PredictionResult{TaskID: "T1", PredictionModel: "yolo", Confidence: 0.2}))
+	assert.Equal(t, models.StatusCompleted, getJob(t, db, "T1").Status)
+
+	now = now.Add(2 * time.Minute)
+	queue.ExpireWaiting()
+	assert.Equal(t, models.StatusTimeout, getJob(t, db, "T2").Status)
+	result, err := db.GetPredictionResult("T2")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusTimeout, result.PredictionStatus)
+	assert.Equal(t, models.StatusCompleted, getJob(t, db, "T1").Status)
+}
+
+// TestPredictionQueueRecovery 测试重启后未受理的任务重新排队，后端已删除的任务记为失败
+func TestPredictionQueueRecovery(t *testing.T) {
+	ai := &fakeQueueAI{predict: func(ctx context.Context, taskID string) (*models.PredictionResult, error) {
+		return &models.PredictionResult{TaskID: taskID, PredictionStatus: models.StatusCompleted, Confidence: 0.1}, nil
+	}}
+	queue, db := newTestQueue(t, ai)
+	now := time.Now()
+	deadline := now.Add(time.Minute)
+
+	for _, job := range []*models.PredictionJob{
+		{TaskID: "INFLIGHT", Backend: "local", ImageURL: "http://camera/1"},
+		{TaskID: "WAITING", Backend: "local", ImageURL: "http://camera/2"},
+		{TaskID: "REMOVED", Backend: "cloud", ImageURL: "http://camera/3"},
+	} {
+		require.NoError(t, db.EnqueuePredictionJob(job, ""))
+		_, err := db.ClaimPredictionJob(job.Backend, now)
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.DispatchPredictionJob("WAITING", now, deadline))
+
+	require.NoError(t, queue.Start())
+	assert.Eventually(t, func() bool {
+		return getJob(t, db, "INFLIGHT").Status == models.StatusCompleted
+	}, 2*time.Second, 10*time.Millisecond)
+	queue.Stop()
+
+	assert.Equal(t, 2, getJob(t, db, "INFLIGHT").Attempts)
+	assert.Equal(t, models.StatusProcessing, getJob(t, db, "WAITING").Status)
+	assert.Equal(t, models.StatusFailed, getJob(t, db, "REMOVED").Status)
+	result, err := db.GetPredictionResult("REMOVED")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusFailed, result.PredictionStatus)
+	assert.Equal(t, []string{"INFLIGHT"}, ai.calls)
+}
+
+// TestPredictionStatusCheckMigration 测试旧数据库的prediction_status约束允许失败和超时状态
+func TestPredictionStatusCheckMigration(t *testing.T) {
+	// 按旧版本的约束重建预测结果表
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, old.AutoMigrate(&models.PredictionResult{}))
+	var ddl string
+	require.NoError(t, old.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'prediction_results'").Scan(&ddl).Error)
+	require.Contains(t, ddl, "prediction_status IN (0, 1, 2, 3, 4))")
+	require.NoError(t, old.Exec("DROP TABLE prediction_results").Error)
+	require.NoError(t, old.Exec(strings.Replace(ddl, "IN (0, 1, 2, 3, 4)", "IN (0, 1, 2)", 1)).Error)
+	require.NoError(t, old.Create(&models.PredictionResult{TaskID: "OLD", PredictionStatus: models.StatusCompleted}).Error)
+	assert.Error(t, old.Create(&models.PredictionResult{TaskID: "BAD", PredictionStatus: models.StatusTimeout}).Error)
+	sqlDB, err := old.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	db, err := NewDBService(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{TaskID: "NEW", PredictionStatus: models.StatusTimeout}))
+
+	saved, err := db.GetPredictionResult("OLD")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCompleted, saved.PredictionStatus)
+	saved, err = db.GetPredictionResult("NEW")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusTimeout, saved.PredictionStatus)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"mingda_ai_helper/models"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrMachineNotRegistered 本机尚未注册，无法校验machine_sn
+	ErrMachineNotRegistered = errors.New("设备未注册")
+	// ErrMachineMismatch 请求的machine_sn与本机不符
+	ErrMachineMismatch = errors.New("machine_sn与本机不符")
+	// ErrPrinterState 打印机当前状态不能执行该指令
+	ErrPrinterState = errors.New("打印机当前状态不能执行该指令")
+)
+
+// PrintCommander 可以直接发送打印控制指令的打印机
+type PrintCommander interface {
+	GetPrinterStatus() (*PrinterStatus, error)
+	SendPrintCommand(command models.PrinterCommand) error
+}
+
+// PrinterControl 处理用户的暂停、恢复和取消请求：校验设备、发送指令并等待打印机进入预期状态，每次操作都记录到数据库
+type PrinterControl struct {
+	printer    PrintCommander
+	dbService  DBInterface
+	events     *EventBus
+	logService LogInterface
+
+	// 等待打印机状态变化的时间和轮询间隔
+	confirmTimeout time.Duration
+	pollInterval   time.Duration
+}
+
+// NewPrinterControl 创建打印控制
+func NewPrinterControl(printer PrintCommander, dbService DBInterface, events *EventBus, logService LogInterface) *PrinterControl {
+	return &PrinterControl{
+		printer:        printer,
+		dbService:      dbService,
+		events:         events,
+		logService:     logService,
+		confirmTimeout: 10 * time.Second,
+		pollInterval:   500 * time.Millisecond,
+	}
+}
+
+// Execute 校验machineSN后执行指令，actor和reason记录操作人和原因。
+// 指令已发送但打印机未在超时内进入预期状态时返回的记录Confirmed为false
+func (p *PrinterControl) Execute(machineSN string, command models.PrinterCommand, actor, reason string) (*models.PrinterAction, error) {
+	info, err := p.dbService.GetMachineInfo()
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && info == nil) {
+		return nil, ErrMachineNotRegistered
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取设备信息失败: %v", err)
+	}
+	if info.MachineSN != machineSN {
+		return nil, ErrMachineMismatch
+	}
+
+	status, err := p.printer.GetPrinterStatus()
+	if err != nil {
+		return nil, err
+	}
+	action := &models.PrinterAction{
+		Command:     command,
+		Source:      models.SourceAPI,
+		Actor:       actor,
+		Reason:      reason,
+		StateBefore: status.PrintStats.State,
+	}
+
+	if !command.AllowedFrom(action.StateBefore) {
+		err = fmt.Errorf("%w: %s", ErrPrinterState, action.StateBefore)
+	} else if err = p.printer.SendPrintCommand(command); err == nil {
+		action.StateAfter, action.Confirmed = p.waitFor(command)
+	}
+	if err != nil {
+		action.Error = err.Error()
+	}
+	if saveErr := p.dbService.SavePrinterAction(action); saveErr != nil {
+		p.logService.Error("记录打印控制操作失败", zap.Error(saveErr))
+	}
+
+	p.logService.Info("执行打印控制指令",
+		zap.String("command", string(command)),
+		zap.String("actor", actor),
+		zap.String("reason", reason),
+		zap.String("state_before", action.StateBefore),
+		zap.String("state_after", action.StateAfter),
+		zap.Bool("confirmed", action.Confirmed),
+		zap.String("error", action.Error))
+	if err != nil {
+		return action, err
+	}
+	if command == models.CommandPause {
+		p.events.Publish(EventPauseIssued, map[string]interface{}{
+			"source": models.SourceAPI,
+			"actor":  actor,
+			"reason": reason,
+		})
+	}
+	return action, nil
+}
+
+// waitFor 轮询打印机状态直到进入指令的预期状态或超时，返回最后观察到的状态
+func (p *PrinterControl) waitFor(command models.PrinterCommand) (string, bool) {
+	deadline := time.Now().Add(p.confirmTimeout)
+	var state string
+	for {
+		if status, err := p.printer.GetPrinterStatus(); err == nil {
+			state = status.PrintStats.State
+			if command.Reached(state) {
+				return state, true
+			}
+		}
+		if time.Now().After(deadline) {
+			return state, false
+		}
+		time.Sleep(p.pollInterval)
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCommander 按指令切换状态的打印机，stuck为true时指令不生效
+type fakeCommander struct {
+	state    string
+	stuck    bool
+	commands []models.PrinterCommand
+}
+
+func (p *fakeCommander) GetPrinterStatus() (*PrinterStatus, error) {
+	status := &PrinterStatus{}
+	status.PrintStats.State = p.state
+	return status, nil
+}
+
+func (p *fakeCommander) SendPrintCommand(command models.PrinterCommand) error {
+	p.commands = append(p.commands, command)
+	if p.stuck {
+		return nil
+	}
+	switch command {
+	case models.CommandPause:
+		p.state = "paused"
+	case models.CommandResume:
+		p.state = "printing"
+	case models.CommandCancel:
+		p.state = "cancelled"
+	}
+	return nil
+}
+
+// TestPrinterControl 测试设备校验、状态检查、确认结果和操作记录
+func TestPrinterControl(t *testing.T) {
+	processor, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	printer := &fakeCommander{state: "printing"}
+	control := NewPrinterControl(printer, db, nil, processor.logService)
+	control.confirmTimeout, control.pollInterval = 20*time.Millisecond, 5*time.Millisecond
+
+	_, err := control.Execute("SN001", models.CommandPause, "app", "")
+	assert.True(t, errors.Is(err, ErrMachineNotRegistered))
+
+	require.NoError(t, db.SaveMachineInfo(&models.MachineInfo{MachineSN: "SN001", MachineModel: "MD-400"}))
+	_, err = control.Execute("SN002", models.CommandPause, "app", "")
+	assert.True(t, errors.Is(err, ErrMachineMismatch))
+	assert.Empty(t, printer.commands)
+
+	// 正在打印时不能恢复
+	_, err = control.Execute("SN001", models.CommandResume, "app", "")
+	assert.True(t, errors.Is(err, ErrPrinterState))
+	assert.Empty(t, printer.commands)
+
+	action, err := control.Execute("SN001", models.CommandPause, "alice", "喷嘴拉丝")
+	require.NoError(t, err)
+	assert.True(t, action.Confirmed)
+	assert.Equal(t, "paused", action.StateAfter)
+
+	// 打印机没有响应时返回未确认
+	printer.stuck = true
+	action, err = control.Execute("SN001", models.CommandCancel, "alice", "")
+	require.NoError(t, err)
+	assert.False(t, action.Confirmed)
+	assert.Equal(t, "paused", action.StateAfter)
+
+	actions, err := db.ListPrinterActions(10)
+	require.NoError(t, err)
+	require.Len(t, actions, 3)
+	assert.Equal(t, models.CommandPause, actions[1].Command)
+	assert.Equal(t, "alice", actions[1].Actor)
+	assert.Equal(t, "喷嘴拉丝", actions[1].Reason)
+	assert.Equal(t, models.SourceAPI, actions[1].Source)
+	assert.NotEmpty(t, actions[2].Error)
+}
+
+// TestProcessorRecordsPolicyActions 测试策略自动暂停时记录操作来源和原因
+func TestProcessorRecordsPolicyActions(t *testing.T) {
+	processor, printer, db := newTestProcessor(t, &models.UserSettings{
+		EnableAI: true, ConfidenceThreshold: 80, PauseOnThreshold: true,
+	})
+	for _, taskID := range []string{"TASK001", "TASK002"} {
+		require.NoError(t, processor.Process(&models.PredictionResult{
+			TaskID: taskID, PredictionModel: "yolo", Backend: "local", HasDefect: true, DefectType: models.DefectSpaghetti, Confidence: 0.9,
+		}))
+	}
+	require.Equal(t, 1, printer.paused)
+
+	actions, err := db.ListPrinterActions(10)
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+	assert.Equal(t, models.SourcePolicy, actions[0].Source)
+	assert.Equal(t, "local", actions[0].Actor)
+	assert.Equal(t, "TASK002", actions[0].TaskID)
+	assert.NotEmpty(t, actions[0].Reason)
+}
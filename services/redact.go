@@ -0,0 +1,333 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redactedMark 敏感信息被替换后的标记
+const redactedMark = "[REDACTED]"
+
+// redactPattern 按模式脱敏，keep为需要保留的前缀分组
+type redactPattern struct {
+	re   *regexp.Regexp
+	keep bool
+}
+
+var defaultRedactPatterns = []redactPattern{
+	// Authorization头（包括 "Authorization: Bearer xxx" 和JSON/键值形式）
+	{re: regexp.MustCompile(`(?i)(authorization["']?\s*[:=]\s*["']?(?:bearer\s+|basic\s+)?)[^\s"',]+`), keep: true},
+	// 单独出现的Bearer凭证
+	{re: regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`), keep: true},
+	// JWT
+	{re: regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+	// 已加密字段
+	{re: regexp.MustCompile(`enc:v1:[0-9a-f]+:[A-Za-z0-9+/=]+`)},
+	// 签名URL中的签名和凭证参数
+	{re: regexp.MustCompile(`(?i)([?&](?:sign|signature|token|access_token|secret|key|api_key|x-amz-signature|x-amz-credential|x-amz-security-token)=)[^&\s"']+`), keep: true},
+	// JSON中的敏感字段
+	{re: regexp.MustCompile(`(?i)("(?:secret|device_secret|sign|signature|token|auth_token|new_token|old_token|api_key|password)"\s*:\s*")[^"]*`), keep: true},
+	// 键值形式的敏感字段，如 secret=xxx
+	{re: regexp.MustCompile(`(?i)(\b(?:secret|device_secret|auth_token|api_key|password)\s*[=:]\s*)[^\s,;&"']+`), keep: true},
+}
+
+// Redactor 日志脱敏器，按模式和已知密钥值替换敏感信息
+type Redactor struct {
+	mu       sync.RWMutex
+	patterns []redactPattern
+	// fixed 通过AddSecret登记的值，named 按名称登记、会被新值替换的值（如当前token）
+	fixed []string
+	named map[string]string
+	// secrets 合并后的所有密钥值，按长度从长到短排列
+	secrets []string
+}
+
+// NewRedactor 创建使用默认规则的脱敏器
+func NewRedactor() *Redactor {
+	return &Redactor{patterns: defaultRedactPatterns, named: make(map[string]string)}
+}
+
+// AddSecret 登记一个已知的密钥值（如设备密钥），出现在任何位置都会被替换
+func (r *Redactor) AddSecret(secret string) {
+	// 过短的值容易误伤普通文本
+	if len(secret) < 6 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.fixed {
+		if s == secret {
+			return
+		}
+	}
+	r.fixed = append(r.fixed, secret)
+	r.rebuildLocked()
+}
+
+// SetSecret 按名称登记密钥值，替换该名称之前登记的值。用于会轮换的值，
+// 如每次刷新的token，避免需要匹配的密钥越来越多
+func (r *Redactor) SetSecret(name, secret string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(secret) < 6 {
+		delete(r.named, name)
+	} else {
+		r.named[name] = secret
+	}
+	r.rebuildLocked()
+}
+
+// rebuildLocked 合并所有密钥值，先替换较长的值，避免部分替换
+func (r *Redactor) rebuildLocked() {
+	secrets := append([]string(nil), r.fixed...)
+	for _, secret := range r.named {
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	r.secrets = secrets
+}
+
+// Redact 返回脱敏后的文本
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedMark)
+	}
+	for _, p := range r.patterns {
+		if p.keep {
+			s = p.re.ReplaceAllString(s, "${1}"+redactedMark)
+		} else {
+			s = p.re.ReplaceAllString(s, redactedMark)
+		}
+	}
+	return s
+}
+
+// RedactField 对单个zap字段脱敏，非文本字段原样返回
+func (r *Redactor) RedactField(f zap.Field) zap.Field {
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = r.Redact(f.String)
+	case zapcore.ByteStringType, zapcore.BinaryType:
+		if b, ok := f.Interface.([]byte); ok {
+			return zap.String(f.Key, r.Redact(string(b)))
+		}
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok {
+			return zap.String(f.Key, r.Redact(err.Error()))
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok {
+			return zap.String(f.Key, r.Redact(s.String()))
+		}
+	case zapcore.ReflectType:
+		return zap.String(f.Key, r.Redact(fmt.Sprintf("%+v", f.Interface)))
+	case zapcore.ArrayMarshalerType:
+		if m, ok := f.Interface.(zapcore.ArrayMarshaler); ok {
+			f.Interface = redactArray{m: m, redactor: r}
+		}
+	case zapcore.ObjectMarshalerType, zapcore.InlineMarshalerType:
+		if m, ok := f.Interface.(zapcore.ObjectMarshaler); ok {
+			f.Interface = redactObject{m: m, redactor: r}
+		}
+	}
+	return f
+}
+
+// redactArray 包装zap.Array的值，序列化时经过脱敏编码器
+type redactArray struct {
+	m        zapcore.ArrayMarshaler
+	redactor *Redactor
+}
+
+func (a redactArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return a.m.MarshalLogArray(&redactArrayEncoder{ArrayEncoder: enc, redactor: a.redactor})
+}
+
+// redactObject 包装zap.Object和zap.Inline的值，序列化时经过脱敏编码器
+type redactObject struct {
+	m        zapcore.ObjectMarshaler
+	redactor *Redactor
+}
+
+func (o redactObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return o.m.MarshalLogObject(&redactObjectEncoder{ObjectEncoder: enc, redactor: o.redactor})
+}
+
+// redactObjectEncoder 对写入对象的文本值脱敏，嵌套的数组和对象同样包装
+type redactObjectEncoder struct {
+	zapcore.ObjectEncoder
+	redactor *Redactor
+}
+
+func (e *redactObjectEncoder) AddString(key, value string) {
+	e.ObjectEncoder.AddString(key, e.redactor.Redact(value))
+}
+
+func (e *redactObjectEncoder) AddByteString(key string, value []byte) {
+	e.ObjectEncoder.AddString(key, e.redactor.Redact(string(value)))
+}
+
+func (e *redactObjectEncoder) AddBinary(key string, value []byte) {
+	e.ObjectEncoder.AddString(key, e.redactor.Redact(string(value)))
+}
+
+func (e *redactObjectEncoder) AddReflected(key string, value interface{}) error {
+	e.ObjectEncoder.AddString(key, e.redactor.Redact(fmt.Sprintf("%+v", value)))
+	return nil
+}
+
+func (e *redactObjectEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	return e.ObjectEncoder.AddArray(key, redactArray{m: arr, redactor: e.redactor})
+}
+
+func (e *redactObjectEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	return e.ObjectEncoder.AddObject(key, redactObject{m: obj, redactor: e.redactor})
+}
+
+// redactArrayEncoder 对写入数组的文本值脱敏
+type redactArrayEncoder struct {
+	zapcore.ArrayEncoder
+	redactor *Redactor
+}
+
+func (e *redactArrayEncoder) AppendString(value string) {
+	e.ArrayEncoder.AppendString(e.redactor.Redact(value))
+}
+
+func (e *redactArrayEncoder) AppendByteString(value []byte) {
+	e.ArrayEncoder.AppendString(e.redactor.Redact(string(value)))
+}
+
+func (e *redactArrayEncoder) AppendReflected(value interface{}) error {
+	e.ArrayEncoder.AppendString(e.redactor.Redact(fmt.Sprintf("%+v", value)))
+	return nil
+}
+
+func (e *redactArrayEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	return e.ArrayEncoder.AppendArray(redactArray{m: arr, redactor: e.redactor})
+}
+
+func (e *redactArrayEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	return e.ArrayEncoder.AppendObject(redactObject{m: obj, redactor: e.redactor})
+}
+
+func (r *Redactor) redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		redacted[i] = r.RedactField(f)
+	}
+	return redacted
+}
+
+// redactCore 在写入任何日志输出之前对消息和字段脱敏
+type redactCore struct {
+	zapcore.Core
+	redactor *Redactor
+}
+
+func newRedactCore(core zapcore.Core, redactor *Redactor) zapcore.Core {
+	return &redactCore{Core: core, redactor: redactor}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.redactor.redactFields(fields)), redactor: c.redactor}
+}
+
+func (c *redactCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.redactor.Redact(entry.Message)
+	entry.Stack = c.redactor.Redact(entry.Stack)
+	return c.Core.Write(entry, c.redactor.redactFields(fields))
+}
+
+// RedactingWriter 按行脱敏后再写入下游
+type RedactingWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	redactor *Redactor
+	buf      []byte
+}
+
+// NewRedactingWriter 创建脱敏写入器
+func NewRedactingWriter(w io.Writer, redactor *Redactor) *RedactingWriter {
+	return &RedactingWriter{w: w, redactor: redactor}
+}
+
+// Write 缓存不完整的行，只输出脱敏后的完整行，避免密钥被拆分到两次写入中
+func (w *RedactingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.redactor.Redact(string(w.buf[:i+1]))
+		w.buf = w.buf[i+1:]
+		if _, err := io.WriteString(w.w, line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush 输出缓冲中剩余的不完整行
+func (w *RedactingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.w, w.redactor.Redact(string(w.buf)))
+	w.buf = nil
+	return err
+}
+
+// RedirectStdout 把os.Stdout替换为管道，所有fmt输出脱敏后再写到原来的终端/journal。
+// 返回的函数恢复原始输出并等待缓冲写完。
+func RedirectStdout(redactor *Redactor) (func(), error) {
+	origStdout := os.Stdout
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("创建输出管道失败: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer pr.Close()
+		writer := NewRedactingWriter(origStdout, redactor)
+		io.Copy(writer, pr)
+		writer.Flush()
+	}()
+
+	os.Stdout = pw
+	return func() {
+		os.Stdout = origStdout
+		pw.Close()
+		<-done
+	}, nil
+}
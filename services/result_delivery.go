@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// deliveryBatch 每轮最多发送的推送数
+	deliveryBatch = 20
+	// deliveryPollInterval 没有新结果时检查重试的间隔
+	deliveryPollInterval = 5 * time.Second
+	// DeviceSNHeader 推送请求中的设备序列号，接收方据此选择验签密钥
+	DeviceSNHeader = "X-Device-SN"
+)
+
+// DeliveryPayload 推送的请求体，与AI回调的格式一致
+type DeliveryPayload struct {
+	TaskID      string                `json:"task_id"`
+	Status      string                `json:"status"`
+	MachineSN   string                `json:"machine_sn,omitempty"`
+	Error       string                `json:"error,omitempty"` // 失败和超时的原因
+	Result      DeliveryPayloadResult `json:"result"`
+	CompletedAt time.Time             `json:"completed_at"`
+}
+
+// DeliveryPayloadResult 推送的预测结果，置信度为0-1
+type DeliveryPayloadResult struct {
+	PredictModel string              `json:"predict_model"`
+	HasDefect    bool                `json:"has_defect"`
+	DefectType   models.DefectType   `json:"defect_type,omitempty"`
+	Confidence   models.Confidence   `json:"confidence"`
+	Detections   models.Detections   `json:"detections,omitempty"`
+	Backend      string              `json:"backend,omitempty"`
+	Action       models.PolicyAction `json:"action,omitempty"`
+	Shadow       bool                `json:"shadow"`
+}
+
+// ResultDeliveryService 预测完成后把结果推送到/predict请求中的callback_url。
+// 推送记录保存在数据库中，失败后按指数退避重试，重启后继续发送
+type ResultDeliveryService struct {
+	cfg        config.DeliveryConfig
+	dbService  *DBService
+	client     *http.Client
+	logService LogInterface
+	now        func() time.Time
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewResultDeliveryService 创建结果推送服务
+func NewResultDeliveryService(cfg config.DeliveryConfig, dbService *DBService, logService LogInterface) *ResultDeliveryService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ResultDeliveryService{
+		cfg:        cfg,
+		dbService:  dbService,
+		client:     &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		logService: logService,
+		now:        time.Now,
+		wake:       make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Ready 预测完成后调用，把该任务的推送加入发送队列
+func (s *ResultDeliveryService) Ready(taskID string) {
+	if s == nil {
+		return
+	}
+	n, err := s.dbService.ActivateResultDeliveries(taskID, s.now())
+	if err != nil {
+		s.logService.Error("更新结果推送状态失败", zap.String("task_id", taskID), zap.Error(err))
+		return
+	}
+	if n == 0 {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start 启动发送协程
+func (s *ResultDeliveryService) Start() {
+	if s == nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(deliveryPollInterval)
+		defer ticker.Stop()
+		for {
+			s.DeliverDue(s.ctx)
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-s.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止发送协程
+func (s *ResultDeliveryService) Stop() {
+	if s == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+// DeliverDue 发送到达发送时间的推送
+func (s *ResultDeliveryService) DeliverDue(ctx context.Context) {
+	deliveries, err := s.dbService.ListDueResultDeliveries(s.now(), deliveryBatch)
+	if err != nil {
+		s.logService.Error("获取待推送的结果失败", zap.Error(err))
+		return
+	}
+	for i := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		s.deliver(ctx, &deliveries[i])
+	}
+}
+
+func (s *ResultDeliveryService) deliver(ctx context.Context, delivery *models.ResultDelivery) {
+	attempt := &models.DeliveryAttempt{Attempt: delivery.Attempts + 1}
+	started := s.now()
+	code, err := s.send(ctx, delivery)
+	if ctx.Err() != nil {
+		// 停止服务时中断的请求不计入次数
+		return
+	}
+	attempt.StatusCode = code
+	attempt.DurationMs = s.now().Sub(started).Milliseconds()
+
+	delivery.Attempts = attempt.Attempt
+	delivery.LastStatusCode = code
+	fields := []zap.Field{
+		zap.String("task_id", delivery.TaskID),
+		zap.String("url", delivery.URL),
+		zap.Int("attempt", attempt.Attempt),
+		zap.Int("status_code", code),
+	}
+	switch {
+	case err == nil:
+		now := s.now()
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+		s.logService.Info("已推送预测结果", fields...)
+	case permanentDeliveryFailure(code) || delivery.Attempts >= s.cfg.MaxAttempts:
+		attempt.Error = truncate(err.Error(), 255)
+		delivery.Status = models.DeliveryFailed
+		delivery.NextAttemptAt = nil
+		delivery.LastError = attempt.Error
+		s.logService.Error("推送预测结果失败，不再重试", append(fields, zap.Error(err))...)
+	default:
+		attempt.Error = truncate(err.Error(), 255)
+		next := s.now().Add(s.backoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		delivery.LastError = attempt.Error
+		s.logService.Error("推送预测结果失败，稍后重试", append(fields, zap.Time("next_attempt_at", next), zap.Error(err))...)
+	}
+
+	if err := s.dbService.RecordDeliveryAttempt(delivery, attempt); err != nil {
+		s.logService.Error("保存推送记录失败", zap.String("task_id", delivery.TaskID), zap.Error(err))
+	}
+}
+
+// send 发送一次推送，返回对方的状态码
+func (s *ResultDeliveryService) send(ctx context.Context, delivery *models.ResultDelivery) (int, error) {
+	result, err := s.dbService.GetPredictionResult(delivery.TaskID)
+	if err != nil {
+		return 0, fmt.Errorf("获取预测结果失败: %v", err)
+	}
+	if result == nil || !result.PredictionStatus.Finished() {
+		return 0, fmt.Errorf("预测任务未完成: %s", delivery.TaskID)
+	}
+	// 与AI回调一致，成功为success，失败和超时为状态名称，原因取自预测任务
+	status, reason := "success", ""
+	if result.PredictionStatus != models.StatusCompleted {
+		status = result.PredictionStatus.String()
+		job, err := s.dbService.GetPredictionJob(delivery.TaskID)
+		if err != nil {
+			return 0, fmt.Errorf("获取预测任务失败: %v", err)
+		}
+		if job != nil {
+			reason = job.Error
+		}
+	}
+
+	secret, machineSN, err := s.signingKey()
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(DeliveryPayload{
+		TaskID:    result.TaskID,
+		Status:    status,
+		MachineSN: machineSN,
+		Error:     reason,
+		Result: DeliveryPayloadResult{
+			PredictModel: result.PredictionModel,
+			HasDefect:    result.HasDefect,
+			DefectType:   result.DefectType,
+			Confidence:   result.Confidence,
+			Detections:   result.Detections,
+			Backend:      result.Backend,
+			Action:       result.Action,
+			Shadow:       result.Shadow,
+		},
+		CompletedAt: result.UpdatedAt,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("序列化预测结果失败: %v", err)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, fmt.Errorf("生成nonce失败: %v", err)
+	}
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("创建推送请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CallbackTimestampHeader, timestamp)
+	req.Header.Set(CallbackNonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(CallbackSignatureHeader, SignCallback(secret, timestamp, hex.EncodeToString(nonce), body))
+	if machineSN != "" {
+		req.Header.Set(DeviceSNHeader, machineSN)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("发送推送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("对方返回%d: %s", resp.StatusCode, string(respBody))
+	}
+	return resp.StatusCode, nil
+}
+
+// signingKey 返回签名密钥和设备序列号，未配置密钥时使用设备密钥
+func (s *ResultDeliveryService) signingKey() ([]byte, string, error) {
+	var machineSN, deviceSecret string
+	if info, err := s.dbService.GetMachineInfo(); err == nil && info != nil {
+		machineSN, deviceSecret = info.MachineSN, info.DeviceSecret
+	}
+	if s.cfg.Secret != "" {
+		return []byte(s.cfg.Secret), machineSN, nil
+	}
+	if deviceSecret == "" {
+		return nil, machineSN, fmt.Errorf("未配置推送签名密钥，设备也未注册")
+	}
+	return []byte(deviceSecret), machineSN, nil
+}
+
+// backoff 第n次失败后的等待时间，从initial_backoff开始翻倍，不超过max_backoff
+func (s *ResultDeliveryService) backoff(attempts int) time.Duration {
+	wait := time.Duration(s.cfg.InitialBackoff) * time.Second
+	limit := time.Duration(s.cfg.MaxBackoff) * time.Second
+	for i := 1; i < attempts && wait < limit; i++ {
+		wait *= 2
+	}
+	if wait > limit {
+		wait = limit
+	}
+	return wait
+}
+
+// permanentDeliveryFailure 对方明确拒绝的请求重试也不会成功，超时和限流除外
+func permanentDeliveryFailure(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
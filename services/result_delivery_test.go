@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mingda_ai_helper/config"
+	"mingda_ai_helper/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestResultDelivery 测试结果推送的签名、失败重试和推送记录
+func TestResultDelivery(t *testing.T) {
+	processor, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	require.NoError(t, db.SaveMachineInfo(&models.MachineInfo{MachineSN: "SN001", MachineModel: "MD-400", DeviceSecret: "device-secret"}))
+
+	codes := []int{http.StatusServiceUnavailable, http.StatusOK}
+	var payloads []DeliveryPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		expected := SignCallback([]byte("device-secret"), r.Header.Get(CallbackTimestampHeader), r.Header.Get(CallbackNonceHeader), body)
+		assert.Equal(t, expected, r.Header.Get(CallbackSignatureHeader))
+		assert.Equal(t, "SN001", r.Header.Get(DeviceSNHeader))
+		var payload DeliveryPayload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		payloads = append(payloads, payload)
+
+		w.WriteHeader(codes[0])
+		codes = codes[1:]
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	deliveries := NewResultDeliveryService(config.DeliveryConfig{MaxAttempts: 3, InitialBackoff: 5, MaxBackoff: 60, Timeout: 5}, db, processor.logService)
+	deliveries.now = func() time.Time { return now }
+	processor.SetDeliveryService(deliveries)
+
+	require.NoError(t, db.SavePredictionResult(&models.PredictionResult{TaskID: "T1", PredictionStatus: models.StatusPending}))
+	require.NoError(t, db.CreateResultDelivery(&models.ResultDelivery{TaskID: "T1", URL: server.URL, Status: models.DeliveryWaiting}))
+
+	// 预测完成前不推送
+	deliveries.DeliverDue(context.Background())
+	assert.Empty(t, payloads)
+
+	require.NoError(t, processor.Process(&models.PredictionResult{
+		TaskID: "T1", PredictionModel: "yolo", HasDefect: true, DefectType: models.DefectStringing, Confidence: 0.6,
+	}))
+	deliveries.DeliverDue(context.Background())
+	require.Len(t, payloads, 1)
+	assert.Equal(t, "T1", payloads[0].TaskID)
+	assert.Equal(t, "SN001", payloads[0].MachineSN)
+	assert.Equal(t, models.DefectStringing, payloads[0].Result.DefectType)
+
+	list, err := db.ListResultDeliveries("T1")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, models.DeliveryPending, list[0].Status)
+	assert.Equal(t, http.StatusServiceUnavailable, list[0].LastStatusCode)
+	require.NotNil(t, list[0].NextAttemptAt)
+	assert.WithinDuration(t, now.Add(5*time.Second), *list[0].NextAttemptAt, time.Second)
+
+	// 退避时间未到不重试
+	deliveries.DeliverDue(context.Background())
+	assert.Len(t, payloads, 1)
+
+	now = now.Add(6 * time.Second)
+	deliveries.DeliverDue(context.Background())
+	assert.Len(t, payloads, 2)
+	list, err = db.ListResultDeliveries("T1")
+	require.NoError(t, err)
+	assert.Equal(t, models.DeliveryDelivered, list[0].Status)
+	assert.Equal(t, 2, list[0].Attempts)
+	require.Len(t, list[0].History, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, list[0].History[0].StatusCode)
+	assert.Equal(t, http.StatusOK, list[0].History[1].StatusCode)
+}
+
+// TestResultDeliveryGivesUp 测试对方拒绝时不再重试，以及退避时间的上限
+func TestResultDeliveryGivesUp(t *testing.T) {
+	processor, _, db := newTestProcessor(t, &models.UserSettings{EnableAI: true, ConfidenceThreshold: 80})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	deliveries := NewResultDeliveryService(config.DeliveryConfig{MaxAttempts: 5, InitialBackoff: 5, MaxBackoff: 30, Timeout: 5, Secret: "configured"}, db, processor.logService)
+	processor.SetDeliveryService(deliveries)
+	require.NoError(t, db.CreateResultDelivery(&models.ResultDelivery{TaskID: "T1", URL: server.URL, Status: models.DeliveryWaiting}))
+	require.NoError(t, processor.Process(&models.PredictionResult{TaskID: "T1", PredictionModel: "yolo", Confidence: 0.1}))
+
+	deliveries.DeliverDue(context.Background())
+	list, err := db.ListResultDeliveries("T1")
+	require.NoError(t, err)
+	assert.Equal(t, models.DeliveryFailed, list[0].Status)
+	assert.Equal(t, 1, list[0].Attempts)
+
+	assert.Equal(t, 5*time.Second, deliveries.backoff(1))
+	assert.Equal(t, 20*time.Second, deliveries.backoff(3))
+	assert.Equal(t, 30*time.Second, deliveries.backoff(10))
+}
+
+// TestResultDeliveryFailures 测试后端失败、回调超时和回调结果无效的任务同样推送，并带上原因
+func TestResultDeliveryFailures(t *testing.T) {
+	ai := &fakeQueueAI{predict: func(ctx context.Context, taskID string) (*models.PredictionResult, error) {
+		if taskID == "FAIL" {
+			return nil, errors.New("connection refused")
+		}
+		return &models.PredictionResult{TaskID: taskID, PredictionStatus: models.StatusProcessing}, nil
+	}}
+	queue, db := newTestQueue(t, ai)
+	now := time.Now()
+	queue.now = func() time.Time { return now }
+
+	payloads := make(map[string]DeliveryPayload)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload DeliveryPayload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		payloads[payload.TaskID] = payload
+	}))
+	defer server.Close()
+
+	deliveries := NewResultDeliveryService(config.DeliveryConfig{MaxAttempts: 3, InitialBackoff: 5, MaxBackoff: 60, Timeout: 5, Secret: "configured"}, db, queue.logService)
+	queue.SetDeliveryService(deliveries)
+	queue.processor.SetDeliveryService(deliveries)
+
+	for _, taskID := range []string{"FAIL", "SILENT", "INVALID"} {
+		_, err := queue.Submit(PredictionRequest{TaskID: taskID, ImageURL: "http://example.com/a.jpg", Priority: models.PriorityManual, CallbackURL: server.URL})
+		require.NoError(t, err)
+		require.True(t, queue.RunNext(context.Background(), "local"))
+	}
+
+	// 回调的置信度超出范围，结果不保存，任务立即失败
+	err := queue.processor.Process(&models.PredictionResult{TaskID: "INVALID", PredictionModel: "yolo", Confidence: 87})
+	assert.ErrorIs(t, err, ErrInvalidConfidence)
+	assert.Equal(t, models.StatusFailed, getJob(t, db, "INVALID").Status)
+
+	deliveries.DeliverDue(context.Background())
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, "failed", payloads["FAIL"].Status)
+	assert.Contains(t, payloads["FAIL"].Error, "connection refused")
+	assert.Equal(t, "failed", payloads["INVALID"].Status)
+	assert.NotEmpty(t, payloads["INVALID"].Error)
+
+	// SILENT没有回调，超过截止时间后推送超时
+	now = now.Add(2 * time.Minute)
+	queue.ExpireWaiting()
+	deliveries.now = queue.now
+	deliveries.DeliverDue(context.Background())
+	require.Contains(t, payloads, "SILENT")
+	assert.Equal(t, "timeout", payloads["SILENT"].Status)
+	assert.Equal(t, "未在等待时间内收到回调", payloads["SILENT"].Error)
+
+	for _, taskID := range []string{"FAIL", "SILENT", "INVALID"} {
+		list, err := db.ListResultDeliveries(taskID)
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, models.DeliveryDelivered, list[0].Status, taskID)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"mingda_ai_helper/models"
+	"mingda_ai_helper/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storedMachineInfo 直接读取数据库中保存的值
+func storedMachineInfo(t *testing.T, db *DBService) models.MachineInfo {
+	var info models.MachineInfo
+	require.NoError(t, db.DB().First(&info).Error)
+	return info
+}
+
+// TestSecretEncryptionMigratesPlaintext 测试启用加密时迁移已有的明文数据，读写对调用方透明
+func TestSecretEncryptionMigratesPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDBService(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// 旧版本保存的明文
+	require.NoError(t, db.SaveMachineInfo(&models.MachineInfo{
+		MachineSN: "SN001", MachineModel: "MD-400", AuthToken: "plain-token", DeviceSecret: "plain-secret",
+	}))
+	assert.Equal(t, "plain-secret", storedMachineInfo(t, db).DeviceSecret)
+
+	cipher, err := utils.NewSecretCipher(filepath.Join(dir, "secret.key"))
+	require.NoError(t, err)
+	require.NoError(t, db.EnableSecretEncryption(cipher))
+
+	stored := storedMachineInfo(t, db)
+	for _, v := range []string{stored.AuthToken, stored.DeviceSecret} {
+		assert.True(t, utils.IsEncrypted(v), v)
+	}
+	info, err := db.GetMachineInfo()
+	require.NoError(t, err)
+	assert.Equal(t, "plain-token", info.AuthToken)
+	assert.Equal(t, "plain-secret", info.DeviceSecret)
+
+	// 再次启用不会重复加密
+	require.NoError(t, db.EnableSecretEncryption(cipher))
+	assert.Equal(t, stored.DeviceSecret, storedMachineInfo(t, db).DeviceSecret)
+
+	// 新写入的值同样加密
+	require.NoError(t, db.UpdateMachineToken("SN001", "new-token"))
+	assert.True(t, utils.IsEncrypted(storedMachineInfo(t, db).AuthToken))
+	info, err = db.GetMachineInfo()
+	require.NoError(t, err)
+	assert.Equal(t, "new-token", info.AuthToken)
+}
+
+// TestSecretEncryptionWrongKey 测试密钥文件被替换后读取失败，不会返回密文
+func TestSecretEncryptionWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDBService(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	cipher, err := utils.NewSecretCipher(filepath.Join(dir, "secret.key"))
+	require.NoError(t, err)
+	require.NoError(t, db.EnableSecretEncryption(cipher))
+	require.NoError(t, db.SaveMachineInfo(&models.MachineInfo{MachineSN: "SN001", MachineModel: "MD-400", AuthToken: "token", DeviceSecret: "secret"}))
+
+	other, err := utils.NewSecretCipher(filepath.Join(dir, "other.key"))
+	require.NoError(t, err)
+	db.cipher = other
+	_, err = db.GetMachineInfo()
+	assert.Error(t, err)
+	assert.Error(t, db.EnableSecretEncryption(other))
+}
+
+// TestRotateSecretKey 测试密钥轮换后所有敏感字段使用新密钥，重启后可以读取
+func TestRotateSecretKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "secret.key")
+	db, err := NewDBService(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	assert.Error(t, db.RotateSecretKey(keyFile))
+
+	cipher, err := utils.NewSecretCipher(keyFile)
+	require.NoError(t, err)
+	require.NoError(t, db.EnableSecretEncryption(cipher))
+	require.NoError(t, db.SaveMachineInfo(&models.MachineInfo{
+		MachineSN: "SN001", MachineModel: "MD-400", AuthToken: "token", DeviceSecret: "secret",
+	}))
+	before := storedMachineInfo(t, db)
+
+	require.NoError(t, db.RotateSecretKey(keyFile))
+	after := storedMachineInfo(t, db)
+	assert.NotEqual(t, before.AuthToken, after.AuthToken)
+	assert.NotEqual(t, before.DeviceSecret, after.DeviceSecret)
+
+	// 重启后只用新的密钥文件加载
+	reloaded, err := utils.NewSecretCipher(keyFile)
+	require.NoError(t, err)
+	assert.NotEqual(t, cipher.KeyID(), reloaded.KeyID())
+	for _, v := range []string{after.AuthToken, after.DeviceSecret} {
+		assert.Contains(t, v, ":"+reloaded.KeyID()+":")
+	}
+	db.cipher = reloaded
+	info, err := db.GetMachineInfo()
+	require.NoError(t, err)
+	assert.Equal(t, "token", info.AuthToken)
+	assert.Equal(t, "secret", info.DeviceSecret)
+}
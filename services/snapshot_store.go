@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotStore 保存检测用的图片，监控拍摄的快照和上传的图片放在同一个目录
+type SnapshotStore struct {
+	dir string
+	now func() time.Time
+}
+
+// NewSnapshotStore 创建快照存储，dir为空时使用~/printer_data/ai_snapshots
+func NewSnapshotStore(dir string) (*SnapshotStore, error) {
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("获取用户主目录失败: %v", err)
+		}
+		dir = filepath.Join(homeDir, "printer_data", "ai_snapshots")
+	}
+	return &SnapshotStore{dir: dir, now: time.Now}, nil
+}
+
+// Dir 返回保存目录
+func (s *SnapshotStore) Dir() string {
+	return s.dir
+}
+
+// Save 把图片保存为prefix_时间.ext，返回文件路径。写入失败时删除不完整的文件
+func (s *SnapshotStore) Save(prefix, ext string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	// 同一秒内保存多张时加序号，避免覆盖
+	timestamp := s.now().Format("20060102_150405")
+	var file *os.File
+	var savePath string
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%s_%s%s", prefix, timestamp, ext)
+		if i > 0 {
+			name = fmt.Sprintf("%s_%s_%d%s", prefix, timestamp, i, ext)
+		}
+		savePath = filepath.Join(s.dir, name)
+		f, err := os.OpenFile(savePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			file = f
+			break
+		}
+		if !os.IsExist(err) || i >= 100 {
+			return "", fmt.Errorf("创建文件失败: %v", err)
+		}
+	}
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(savePath)
+		return "", fmt.Errorf("保存图片失败: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(savePath)
+		return "", fmt.Errorf("保存图片失败: %v", err)
+	}
+	return savePath, nil
+}
@@ -126,7 +126,7 @@ func TestAIService() {
 
 	// 发送预测请求
 	fmt.Printf("\n开始发送预测请求...\n")
-	result, err := cloudAI.PredictWithFile(context.Background(), savePath)
+	result, err := cloudAI.PredictWithFile(context.Background(), savePath, "")
 	if err != nil {
 		log.Fatalf("\033[31m发送预测请求失败: %v\033[0m\n", err)
 	}
@@ -56,6 +56,7 @@ func TestPredict() {
 	cloudResult, err := cloudAI.PredictWithFile(
 		context.Background(),
 		"test_data/test_image.jpg",
+		"",
 	)
 	if err != nil {
 		log.Printf("\033[31m云端预测失败: %v\033[0m\n", err)
@@ -0,0 +1,251 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// encryptedPrefix 加密字段的前缀，格式为 enc:v1:<密钥ID>:<base64(nonce|密文)>
+	encryptedPrefix = "enc:v1:"
+	// keyMaterialSize 密钥文件中随机材料的字节数
+	keyMaterialSize = 32
+	// keyDerivationLabel 密钥派生标签，避免密钥文件材料被直接用作加密密钥
+	keyDerivationLabel = "mingda_ai_helper/credentials/aes-256-gcm"
+)
+
+type secretKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// SecretCipher 使用AES-256-GCM加密敏感字段（token、设备密钥、API Key）
+type SecretCipher struct {
+	current secretKey
+	// 轮换过程中仍可用于解密的旧密钥
+	fallback []secretKey
+}
+
+// NewSecretCipher 从密钥文件加载加密器，密钥文件不存在时自动生成
+func NewSecretCipher(keyFile string) (*SecretCipher, error) {
+	material, err := loadOrCreateKeyFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	current, err := deriveSecretKey(material)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &SecretCipher{current: current}
+	// 轮换中断时，旧密钥或新密钥可能仍有数据依赖
+	for _, path := range []string{keyFile + ".old", keyFile + ".new"} {
+		material, err := readKeyFile(path)
+		if err != nil {
+			continue
+		}
+		if key, err := deriveSecretKey(material); err == nil && key.id != current.id {
+			c.fallback = append(c.fallback, key)
+		}
+	}
+	return c, nil
+}
+
+// KeyID 返回当前密钥的标识
+func (c *SecretCipher) KeyID() string {
+	return c.current.id
+}
+
+// IsEncrypted 判断字段值是否已加密
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Encrypt 加密字段值，空字符串和已用当前密钥加密的值原样返回
+func (c *SecretCipher) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" || strings.HasPrefix(plaintext, encryptedPrefix+c.current.id+":") {
+		return plaintext, nil
+	}
+	if IsEncrypted(plaintext) {
+		decrypted, err := c.Decrypt(plaintext)
+		if err != nil {
+			return "", err
+		}
+		plaintext = decrypted
+	}
+
+	nonce := make([]byte, c.current.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %v", err)
+	}
+	sealed := c.current.aead.Seal(nonce, nonce, []byte(plaintext), []byte(c.current.id))
+	return encryptedPrefix + c.current.id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密字段值，未加密的旧数据原样返回
+func (c *SecretCipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, encryptedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("加密字段格式错误")
+	}
+	key, ok := c.keyByID(parts[0])
+	if !ok {
+		return "", fmt.Errorf("找不到密钥: %s", parts[0])
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("解码加密字段失败: %v", err)
+	}
+	nonceSize := key.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("加密字段长度错误")
+	}
+	plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(key.id))
+	if err != nil {
+		return "", fmt.Errorf("解密失败: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+func (c *SecretCipher) keyByID(id string) (secretKey, bool) {
+	if c.current.id == id {
+		return c.current, true
+	}
+	for _, key := range c.fallback {
+		if key.id == id {
+			return key, true
+		}
+	}
+	return secretKey{}, false
+}
+
+// PrepareKeyRotation 生成新密钥文件（<keyFile>.new），返回可解密新旧数据、用新密钥加密的加密器。
+// 数据重新加密完成后调用 CommitKeyRotation 替换密钥文件。
+func PrepareKeyRotation(keyFile string, old *SecretCipher) (*SecretCipher, error) {
+	material := make([]byte, keyMaterialSize)
+	if _, err := io.ReadFull(rand.Reader, material); err != nil {
+		return nil, fmt.Errorf("生成新密钥失败: %v", err)
+	}
+	if err := writeKeyFile(keyFile+".new", material); err != nil {
+		return nil, err
+	}
+
+	current, err := deriveSecretKey(material)
+	if err != nil {
+		return nil, err
+	}
+	rotated := &SecretCipher{current: current}
+	rotated.fallback = append(rotated.fallback, old.current)
+	rotated.fallback = append(rotated.fallback, old.fallback...)
+	return rotated, nil
+}
+
+// CommitKeyRotation 用新密钥替换当前密钥文件，旧密钥保留为<keyFile>.old
+func CommitKeyRotation(keyFile string) error {
+	if err := os.Rename(keyFile, keyFile+".old"); err != nil {
+		return fmt.Errorf("备份旧密钥失败: %v", err)
+	}
+	if err := os.Rename(keyFile+".new", keyFile); err != nil {
+		return fmt.Errorf("启用新密钥失败: %v", err)
+	}
+	return nil
+}
+
+func deriveSecretKey(material []byte) (secretKey, error) {
+	mac := hmac.New(sha256.New, material)
+	mac.Write([]byte(keyDerivationLabel))
+	key := mac.Sum(nil)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return secretKey{}, fmt.Errorf("创建AES加密器失败: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return secretKey{}, fmt.Errorf("创建GCM加密器失败: %v", err)
+	}
+
+	sum := sha256.Sum256(key)
+	return secretKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// LoadOrCreateSecret 读取密钥文件中的随机材料，文件不存在时生成（权限0600），用于其他签名密钥
+func LoadOrCreateSecret(path string) ([]byte, error) {
+	return loadOrCreateKeyFile(path)
+}
+
+func loadOrCreateKeyFile(path string) ([]byte, error) {
+	material, err := readKeyFile(path)
+	if err == nil {
+		return material, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	material = make([]byte, keyMaterialSize)
+	if _, err := io.ReadFull(rand.Reader, material); err != nil {
+		return nil, fmt.Errorf("生成密钥失败: %v", err)
+	}
+	if err := writeKeyFile(path, material); err != nil {
+		return nil, err
+	}
+	return material, nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	// 密钥文件只允许属主读写
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(path, 0600); err != nil {
+			return nil, fmt.Errorf("密钥文件权限过宽且无法修正: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %v", err)
+	}
+	material, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(material) < keyMaterialSize {
+		return nil, fmt.Errorf("密钥文件格式错误: %s", path)
+	}
+	return material, nil
+}
+
+func writeKeyFile(path string, material []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("创建密钥目录失败: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(material)+"\n"), 0600); err != nil {
+		return fmt.Errorf("写入密钥文件失败: %v", err)
+	}
+	return nil
+}
+
+// MaskSecret 只保留前几位用于排查问题
+func MaskSecret(secret string) string {
+	if len(secret) <= 8 {
+		return "***"
+	}
+	return secret[:6] + "***"
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSecretCipherRoundTrip 测试加密解密、明文透传和密钥文件权限
+func TestSecretCipherRoundTrip(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys", "secret.key")
+	c, err := NewSecretCipher(keyFile)
+	require.NoError(t, err)
+
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	encrypted, err := c.Encrypt("device-secret")
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(encrypted))
+	assert.True(t, strings.HasPrefix(encrypted, encryptedPrefix+c.KeyID()+":"))
+	assert.NotContains(t, encrypted, "device-secret")
+
+	// 同一明文每次加密结果不同，已加密的值不重复加密
+	again, err := c.Encrypt("device-secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, again)
+	same, err := c.Encrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, encrypted, same)
+
+	plaintext, err := c.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "device-secret", plaintext)
+
+	// 未加密的旧数据和空值原样返回
+	plaintext, err = c.Decrypt("legacy-token")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-token", plaintext)
+	empty, err := c.Encrypt("")
+	require.NoError(t, err)
+	assert.Equal(t, "", empty)
+
+	// 重新加载同一个密钥文件可以解密
+	reloaded, err := NewSecretCipher(keyFile)
+	require.NoError(t, err)
+	plaintext, err = reloaded.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "device-secret", plaintext)
+}
+
+// TestSecretCipherWrongKey 测试其他密钥、篡改的密文和格式错误时解密失败
+func TestSecretCipherWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewSecretCipher(filepath.Join(dir, "a.key"))
+	require.NoError(t, err)
+	other, err := NewSecretCipher(filepath.Join(dir, "b.key"))
+	require.NoError(t, err)
+
+	encrypted, err := c.Encrypt("auth-token")
+	require.NoError(t, err)
+	_, err = other.Decrypt(encrypted)
+	assert.Error(t, err)
+
+	// 伪造密钥ID后，用其他密钥解密也不能通过认证
+	forged := strings.Replace(encrypted, c.KeyID(), other.KeyID(), 1)
+	_, err = other.Decrypt(forged)
+	assert.Error(t, err)
+
+	tampered := encrypted[:len(encrypted)-2] + "AA"
+	if tampered == encrypted {
+		tampered = encrypted[:len(encrypted)-2] + "BB"
+	}
+	_, err = c.Decrypt(tampered)
+	assert.Error(t, err)
+	_, err = c.Decrypt(encryptedPrefix + "broken")
+	assert.Error(t, err)
+
+	// 密钥文件内容错误
+	bad := filepath.Join(dir, "bad.key")
+	require.NoError(t, os.WriteFile(bad, []byte("not-hex"), 0600))
+	_, err = NewSecretCipher(bad)
+	assert.Error(t, err)
+}
+
+// TestSecretKeyRotation 测试密钥轮换：新加密器可解密旧数据，提交后旧密钥保留为.old
+func TestSecretKeyRotation(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "secret.key")
+	c, err := NewSecretCipher(keyFile)
+	require.NoError(t, err)
+	old, err := c.Encrypt("auth-token")
+	require.NoError(t, err)
+
+	rotated, err := PrepareKeyRotation(keyFile, c)
+	require.NoError(t, err)
+	assert.NotEqual(t, c.KeyID(), rotated.KeyID())
+	plaintext, err := rotated.Decrypt(old)
+	require.NoError(t, err)
+	assert.Equal(t, "auth-token", plaintext)
+
+	// 用旧密钥加密的值换成新密钥
+	reencrypted, err := rotated.Encrypt(old)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reencrypted, encryptedPrefix+rotated.KeyID()+":"))
+
+	// 提交前重启：两个密钥都可用
+	interrupted, err := NewSecretCipher(keyFile)
+	require.NoError(t, err)
+	for _, v := range []string{old, reencrypted} {
+		plaintext, err := interrupted.Decrypt(v)
+		require.NoError(t, err)
+		assert.Equal(t, "auth-token", plaintext)
+	}
+
+	require.NoError(t, CommitKeyRotation(keyFile))
+	_, err = os.Stat(keyFile + ".old")
+	assert.NoError(t, err)
+	_, err = os.Stat(keyFile + ".new")
+	assert.True(t, os.IsNotExist(err))
+
+	committed, err := NewSecretCipher(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, rotated.KeyID(), committed.KeyID())
+	plaintext, err = committed.Decrypt(reencrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "auth-token", plaintext)
+}
@@ -1,19 +1,22 @@
 package utils
 
 import (
+	"fmt"
 	"time"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type Claims struct {
 	MachineSN string `json:"machine_sn"`
+	// Scope 本地API的访问权限：read、control或admin
+	Scope string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateToken(machineSN string, secret string, expiration time.Duration) (string, error) {
 	claims := Claims{
-		machineSN,
-		jwt.RegisteredClaims{
+		MachineSN: machineSN,
+		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
@@ -23,10 +26,27 @@ func GenerateToken(machineSN string, secret string, expiration time.Duration) (s
 	return token.SignedString([]byte(secret))
 }
 
+// GenerateAPIToken 签发访问本地API的token，subject为使用者名称（如手机App），用于审计
+func GenerateAPIToken(subject, scope string, secret string, expiration time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Scope: scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
 func ValidateToken(tokenString string, secret string) (*Claims, error) {
+	// 只接受HS256，避免alg被替换
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(secret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
 
 	if err != nil {
 		return nil, err
@@ -37,4 +57,16 @@ func ValidateToken(tokenString string, secret string) (*Claims, error) {
 	}
 
 	return nil, jwt.ErrSignatureInvalid
-} 
\ No newline at end of file
+} 
+
+// ParseTokenExpiry 读取JWT中的exp声明，不校验签名（云端token由服务器签发，本地无密钥）
+func ParseTokenExpiry(tokenString string) (time.Time, error) {
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return time.Time{}, fmt.Errorf("解析token失败: %v", err)
+	}
+	if claims.ExpiresAt == nil {
+		return time.Time{}, fmt.Errorf("token中缺少exp声明")
+	}
+	return claims.ExpiresAt.Time, nil
+}